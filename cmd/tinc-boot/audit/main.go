@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+)
+
+type Cmd struct {
+	Dir     string        `short:"d" long:"dir" env:"DIR" description:"tinc-boot directory" default:"vpn"`
+	File    string        `short:"f" long:"file" env:"FILE" description:"Audit log file. If not set - run/audit.log in tinc-boot directory"`
+	Name    string        `short:"n" long:"name" env:"NAME" description:"Filter by claimed node name"`
+	Source  string        `short:"s" long:"source" env:"SOURCE" description:"Filter by source IP"`
+	Outcome []string      `short:"o" long:"outcome" env:"OUTCOME" description:"Filter by outcome (joined, rejected, banned, invalid, conflict, failed, refused, removed, admitted)"`
+	Since   time.Duration `long:"since" env:"SINCE" description:"Show only records not older than duration"`
+	JSON    bool          `long:"json" env:"JSON" description:"Print records as JSON lines"`
+}
+
+func (cmd *Cmd) Execute([]string) error {
+	file := cmd.File
+	if file == "" {
+		file = filepath.Join(cmd.Dir, "run", "audit.log")
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !cmd.JSON {
+		_, _ = fmt.Fprintln(out, "TIME\tSOURCE\tNAME\tOUTCOME\tFINGERPRINT\tREASON")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	var after time.Time
+	if cmd.Since > 0 {
+		after = time.Now().Add(-cmd.Since)
+	}
+	err = boot.ReadAudit(f, func(record boot.AuditRecord) bool {
+		if !cmd.match(record, after) {
+			return true
+		}
+		if cmd.JSON {
+			_ = enc.Encode(record)
+			return true
+		}
+		_, _ = fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\n", record.Time.Format(time.RFC3339), record.Source, record.Name, record.Outcome, record.Fingerprint, record.Reason)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("read audit log: %w", err)
+	}
+	return out.Flush()
+}
+
+func (cmd *Cmd) match(record boot.AuditRecord, after time.Time) bool {
+	if cmd.Name != "" && record.Name != cmd.Name {
+		return false
+	}
+	if cmd.Source != "" && record.Source != cmd.Source {
+		return false
+	}
+	if record.Time.Before(after) {
+		return false
+	}
+	if len(cmd.Outcome) == 0 {
+		return true
+	}
+	for _, outcome := range cmd.Outcome {
+		if outcome == record.Outcome {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package authority
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/boot"
+	"github.com/reddec/tinc-boot/types"
+)
+
+type Cmd struct {
+	Init  InitCmd  `command:"init" description:"Create network authority key"`
+	Issue IssueCmd `command:"issue" description:"Issue (or renew) admission for host file offline"`
+}
+
+type InitCmd struct {
+	Key string `short:"k" long:"key" env:"KEY" description:"Authority key file. Keep it offline or only on trusted boot servers" default:"authority.key"`
+	Dir string `short:"d" long:"dir" env:"DIR" description:"tinc-boot directory of node to enable authority mode (optional)"`
+}
+
+func (cmd *InitCmd) Execute([]string) error {
+	ca, err := authority.Generate()
+	if err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	if err := ca.SaveKey(cmd.Key); err != nil {
+		return fmt.Errorf("save key: %w", err)
+	}
+	if cmd.Dir != "" {
+		file := filepath.Join(cmd.Dir, "run", "network.json")
+		network, err := boot.ReadNetwork(file)
+		if err != nil {
+			return fmt.Errorf("read network settings: %w", err)
+		}
+		network.Authority = ca.Public().String()
+		if err := boot.SaveNetwork(file, network); err != nil {
+			return fmt.Errorf("save network settings: %w", err)
+		}
+	}
+	fmt.Println("Authority key:", cmd.Key)
+	fmt.Println("Public key:   ", ca.Public())
+	fmt.Println()
+	fmt.Println("issue admissions by boot server:")
+	fmt.Println()
+	fmt.Println("     ", os.Args[0], "run --authority-key", cmd.Key)
+	fmt.Println()
+	fmt.Println("enforce admissions on other nodes:")
+	fmt.Println()
+	fmt.Println("     ", os.Args[0], "run --authority", ca.Public())
+	fmt.Println()
+	return nil
+}
+
+type IssueCmd struct {
+	Key    string        `short:"k" long:"key" env:"KEY" description:"Authority key file" default:"authority.key"`
+	Name   string        `short:"n" long:"name" env:"NAME" description:"Node name. If not set - host file name will be used"`
+	TTL    time.Duration `long:"ttl" env:"TTL" description:"Admission validity" default:"720h"`
+	Output string        `short:"o" long:"output" env:"OUTPUT" description:"Output file. If not set - <name>.admission"`
+	Args   struct {
+		Host string `description:"host file of node" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (cmd *IssueCmd) Execute([]string) error {
+	ca, err := authority.ReadKey(cmd.Key)
+	if err != nil {
+		return fmt.Errorf("read authority key: %w", err)
+	}
+	name := cmd.Name
+	if name == "" {
+		name = filepath.Base(cmd.Args.Host)
+	}
+	if types.CleanString(name) != name {
+		return fmt.Errorf("invalid node name %s", name)
+	}
+	content, err := ioutil.ReadFile(cmd.Args.Host)
+	if err != nil {
+		return fmt.Errorf("read host file: %w", err)
+	}
+	admission, err := ca.Issue(name, content, cmd.TTL)
+	if err != nil {
+		return err
+	}
+	output := cmd.Output
+	if output == "" {
+		output = name + ".admission"
+	}
+	if err := admission.SaveFile(output); err != nil {
+		return fmt.Errorf("save admission: %w", err)
+	}
+	fmt.Println("Admission:", output)
+	fmt.Println("Subnets:  ", admission.Subnets)
+	fmt.Println("Expires:  ", admission.Expires.Format(time.RFC3339))
+	fmt.Println()
+	fmt.Println("on the node (renew the same way before expiration):")
+	fmt.Println()
+	fmt.Println("     ", os.Args[0], "run --admission", filepath.Base(output))
+	fmt.Println()
+	return nil
+}
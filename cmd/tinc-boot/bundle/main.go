@@ -0,0 +1,366 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/invite"
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/boot"
+	"github.com/reddec/tinc-boot/tincd/config"
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/reddec/tinc-boot/tincd/ipam"
+	"github.com/reddec/tinc-boot/types"
+)
+
+// KeyFiles generated by tincd -K (1.0 and 1.1) which can be carried by bundle.
+var KeyFiles = []string{"rsa_key.priv", "ed25519_key.priv"}
+
+type Cmd struct {
+	Export ExportCmd `command:"export" description:"Export offline join bundle for a new node"`
+	Import ImportCmd `command:"import" description:"Import reverse bundle with host file of a new node"`
+}
+
+type ExportCmd struct {
+	Dir    string `short:"d" long:"dir" env:"DIR" description:"tinc-boot directory" default:"vpn"`
+	Token  string `short:"t" long:"token" env:"TOKEN" description:"Boot token. If not set - token from saved invite will be used"`
+	For    string `long:"for" env:"FOR" description:"Name of the new node" required:"yes"`
+	IP     string `long:"ip" env:"IP" description:"VPN IP for the new node. If not set - free address will be allocated"`
+	CIDR   string `long:"cidr" env:"CIDR" description:"VPN network CIDR if there is no saved network settings" default:"172.16.0.0/12"`
+	Keys   bool   `long:"keys" env:"KEYS" description:"Pre-generate keys for the new node. Host file with keys will be added right away"`
+	Tincd  string `long:"tincd" env:"TINCD" description:"tincd binary location (for keys generation)" default:"tincd"`
+	URL    string `short:"u" long:"url" env:"URL" description:"Greeting service of running node (ex: http://127.0.0.1:8655) to reserve address. If not set - files will be changed directly (node must be stopped)"`
+	Output string `short:"o" long:"output" env:"OUTPUT" description:"Output file. If not set - <name>.bundle"`
+}
+
+func (cmd *ExportCmd) Execute([]string) error {
+	if types.CleanString(cmd.For) != cmd.For {
+		return fmt.Errorf("invalid node name %s", cmd.For)
+	}
+	token, err := resolveToken(cmd.Dir, cmd.Token)
+	if err != nil {
+		return err
+	}
+	dc := daemon.Default(filepath.Join(cmd.Dir, "config"))
+	dc.PidFile = filepath.Join(cmd.Dir, "run", "pid.run")
+	if cmd.URL == "" && dc.Running() {
+		// running node overwrites files from own in-memory state
+		return fmt.Errorf("node is running: use --url to reserve address through its greeting service")
+	}
+	main, err := dc.Main()
+	if err != nil {
+		return fmt.Errorf("read main config: %w", err)
+	}
+	if _, err := dc.Host(cmd.For); err == nil {
+		return fmt.Errorf("node %s already exists", cmd.For)
+	}
+	ssd := discovery.NewSSD(filepath.Join(cmd.Dir, "run", "discovery.json"))
+	if err := ssd.Read(); err != nil {
+		return fmt.Errorf("read discovery: %w", err)
+	}
+
+	network, err := boot.ReadNetwork(filepath.Join(cmd.Dir, "run", "network.json"))
+	if err != nil {
+		return fmt.Errorf("read network settings: %w", err)
+	}
+	if network.CIDR == "" {
+		network.CIDR = cmd.CIDR
+	}
+	network.Mode = main.Mode
+	network.Cipher = main.Cipher
+
+	subnet, err := cmd.subnet(network.CIDR, dc)
+	if err != nil {
+		return err
+	}
+
+	hosts, err := dc.Hosts()
+	if err != nil {
+		return fmt.Errorf("read hosts: %w", err)
+	}
+	var bundle = boot.Bundle{
+		Name:    cmd.For,
+		Server:  main.Name,
+		Subnet:  subnet,
+		Network: network,
+		Created: time.Now(),
+	}
+	for name, content := range hosts {
+		info, _ := ssd.Get(name)
+		bundle.Hosts = append(bundle.Hosts, boot.Host{Name: name, Version: info.Version, Config: content, Signature: info.Signature, Admission: info.Admission, Catalog: info.Catalog})
+	}
+
+	// register node right now to reserve address: without keys host file has only subnet and will be
+	// replaced by reverse bundle or join of the new node
+	host, err := config.Marshal(config.Node{Subnet: []string{subnet}})
+	if err != nil {
+		return fmt.Errorf("create host file: %w", err)
+	}
+	if cmd.Keys {
+		keys, generated, err := cmd.generateKeys(subnet)
+		if err != nil {
+			return fmt.Errorf("generate keys: %w", err)
+		}
+		bundle.Keys = keys
+		bundle.Hosts = append(bundle.Hosts, boot.Host{Name: cmd.For, Config: generated})
+		host = generated
+	}
+	if err := cmd.reserve(dc, ssd, host, token); err != nil {
+		return fmt.Errorf("reserve address: %w", err)
+	}
+
+	output := cmd.Output
+	if output == "" {
+		output = cmd.For + ".bundle"
+	}
+	if err := bundle.SaveFile(output, token); err != nil {
+		return fmt.Errorf("save bundle: %w", err)
+	}
+	fmt.Println("Bundle:", output)
+	fmt.Println("Subnet:", subnet)
+	fmt.Println()
+	fmt.Println("on the new node:")
+	fmt.Println()
+	fmt.Println("     ", os.Args[0], "run -t <token> --import-bundle", filepath.Base(output))
+	fmt.Println()
+	return nil
+}
+
+func (cmd *ExportCmd) subnet(cidr string, dc *daemon.Config) (string, error) {
+	if cmd.IP != "" {
+		subnet := cmd.IP + "/32"
+		conflicts, err := ipam.Conflicts(dc, cmd.For, subnet)
+		if err != nil {
+			return "", fmt.Errorf("check conflicts: %w", err)
+		}
+		if len(conflicts) > 0 {
+			return "", fmt.Errorf("subnet %s conflicts with %s", subnet, strings.Join(conflicts, ", "))
+		}
+		return subnet, nil
+	}
+	pool, err := ipam.New(cidr, dc)
+	if err != nil {
+		return "", fmt.Errorf("create address pool: %w", err)
+	}
+	ip, err := pool.Allocate(cmd.For)
+	if err != nil {
+		return "", fmt.Errorf("allocate address: %w", err)
+	}
+	return ip.String() + "/32", nil
+}
+
+// reserve address of the new node by adding its host file directly or through greeting service of running node.
+func (cmd *ExportCmd) reserve(dc *daemon.Config, ssd *discovery.SSD, host []byte, token boot.Token) error {
+	if cmd.URL != "" {
+		_, err := boot.NewClient(cmd.URL, nil, token).Send(context.Background(), boot.Envelope{
+			Name:     cmd.For,
+			Config:   host,
+			Protocol: boot.ProtocolV2,
+			Agent:    boot.AgentVersion,
+		})
+		return err
+	}
+	var err error
+	ssd.ReplaceIfNewer(discovery.Entity{Name: cmd.For}, func() bool {
+		err = dc.AddHost(cmd.For, host)
+		return err == nil
+	})
+	if err != nil {
+		return fmt.Errorf("add host: %w", err)
+	}
+	return ssd.Save()
+}
+
+// generateKeys by tincd in temporary directory. Returns private keys and host file with public keys.
+func (cmd *ExportCmd) generateKeys(subnet string) (map[string][]byte, []byte, error) {
+	tmpDir, err := ioutil.TempDir("", "tinc-boot-bundle-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dc := daemon.Default(tmpDir)
+	dc.Binary = cmd.Tincd
+	if err := os.MkdirAll(dc.HostsDir(), 0755); err != nil {
+		return nil, nil, err
+	}
+	if err := config.SaveFile(filepath.Join(tmpDir, "tinc.conf"), config.Main{Name: cmd.For}); err != nil {
+		return nil, nil, err
+	}
+	if err := config.SaveFile(filepath.Join(dc.HostsDir(), cmd.For), config.Node{Subnet: []string{subnet}}); err != nil {
+		return nil, nil, err
+	}
+	if err := dc.Keygen(context.Background(), 4096); err != nil {
+		return nil, nil, err
+	}
+	var keys = make(map[string][]byte)
+	for _, keyFile := range KeyFiles {
+		content, err := ioutil.ReadFile(filepath.Join(tmpDir, keyFile))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		keys[keyFile] = content
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no keys generated")
+	}
+	host, err := dc.Host(cmd.For)
+	return keys, host, err
+}
+
+type ImportCmd struct {
+	Dir   string `short:"d" long:"dir" env:"DIR" description:"tinc-boot directory" default:"vpn"`
+	Token string `short:"t" long:"token" env:"TOKEN" description:"Boot token. If not set - token from saved invite will be used"`
+	URL   string `short:"u" long:"url" env:"URL" description:"Greeting service of running node (ex: http://127.0.0.1:8655). If not set - files will be changed directly (node must be stopped)"`
+	CIDR  string `long:"cidr" env:"CIDR" description:"VPN network CIDR if there is no saved network settings" default:"172.16.0.0/12"`
+	Args  struct {
+		Bundle string `description:"reverse bundle file" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (cmd *ImportCmd) Execute([]string) error {
+	token, err := resolveToken(cmd.Dir, cmd.Token)
+	if err != nil {
+		return err
+	}
+	bundle, err := boot.ReadBundle(cmd.Args.Bundle, token)
+	if err != nil {
+		return err
+	}
+	if cmd.URL != "" {
+		return cmd.send(token, bundle)
+	}
+
+	dc := daemon.Default(filepath.Join(cmd.Dir, "config"))
+	dc.PidFile = filepath.Join(cmd.Dir, "run", "pid.run")
+	if dc.Running() {
+		// running node overwrites files from own in-memory state
+		return fmt.Errorf("node is running: use --url to import through its greeting service")
+	}
+	ssd := discovery.NewSSD(filepath.Join(cmd.Dir, "run", "discovery.json"))
+	if err := ssd.Read(); err != nil {
+		return fmt.Errorf("read discovery: %w", err)
+	}
+	network, err := boot.ReadNetwork(filepath.Join(cmd.Dir, "run", "network.json"))
+	if err != nil {
+		return fmt.Errorf("read network settings: %w", err)
+	}
+	if network.CIDR == "" {
+		network.CIDR = cmd.CIDR
+	}
+	pool, err := ipam.New(network.CIDR, dc)
+	if err != nil {
+		return fmt.Errorf("create address pool: %w", err)
+	}
+	var pub authority.PublicKey
+	if network.Authority != "" {
+		pub, err = authority.ParsePublicKey(network.Authority)
+		if err != nil {
+			return fmt.Errorf("parse network authority: %w", err)
+		}
+	}
+	for _, host := range bundle.Hosts {
+		if err := importHost(dc, ssd, pool, host, pub); err != nil {
+			return fmt.Errorf("import %s: %w", host.Name, err)
+		}
+	}
+	if err := ssd.Save(); err != nil {
+		return fmt.Errorf("save discovery: %w", err)
+	}
+	log.Println("imported hosts will be distributed after start of node")
+	return nil
+}
+
+// send hosts to running node by greeting protocol.
+func (cmd *ImportCmd) send(token boot.Token, bundle *boot.Bundle) error {
+	client := boot.NewClient(cmd.URL, nil, token)
+	for _, host := range bundle.Hosts {
+		_, err := client.Send(context.Background(), boot.Envelope{
+			Name:     host.Name,
+			Config:   host.Config,
+			Protocol: boot.ProtocolV2,
+			Version:  host.Version,
+			Agent:    boot.AgentVersion,
+
+			Signature: host.Signature,
+			Admission: host.Admission,
+			Catalog:   host.Catalog,
+		})
+		if err != nil {
+			return fmt.Errorf("send %s: %w", host.Name, err)
+		}
+		log.Println("imported", host.Name)
+	}
+	return nil
+}
+
+// importHost from bundle if it's newer than known and not conflicts with other hosts.
+func importHost(dc *daemon.Config, ssd *discovery.SSD, pool *ipam.Pool, host boot.Host, pub authority.PublicKey) error {
+	if types.CleanString(host.Name) != host.Name {
+		return fmt.Errorf("invalid node name")
+	}
+	if pub != nil {
+		if err := pub.Verify(host.Admission, host.Name, host.Config, time.Now()); err != nil {
+			return err
+		}
+	}
+	for _, subnet := range ipam.HostSubnets(host.Config) {
+		if pool.Covers(subnet.String()) {
+			continue // default route of exit node
+		}
+		conflicts, err := pool.Conflicts(host.Name, subnet.String())
+		if err != nil {
+			return err
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("subnet %s conflicts with %s", subnet, strings.Join(conflicts, ", "))
+		}
+	}
+	entity := discovery.Entity{Name: host.Name, Version: host.Version, Signature: host.Signature, Admission: host.Admission, Catalog: host.Catalog}
+	pinned, _ := dc.Host(host.Name)
+	if discovery.Ahead(entity.Version) {
+		return fmt.Errorf("version %d from the future", entity.Version)
+	}
+	if err := discovery.VerifyRecord(entity, host.Config, pinned, false); err != nil {
+		return err
+	}
+	if err := discovery.VerifyCatalog(entity, host.Config, pinned); err != nil && !errors.Is(err, discovery.ErrUnsigned) {
+		return err
+	}
+	var err error
+	imported := ssd.ReplaceIfNewer(entity, func() bool {
+		err = dc.AddHost(host.Name, host.Config)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if imported {
+		log.Println("imported", host.Name)
+	} else {
+		log.Println("skipped", host.Name, "(already known)")
+	}
+	return nil
+}
+
+// resolveToken returns explicit token or token from invite saved by run.
+func resolveToken(dir, token string) (boot.Token, error) {
+	if token != "" {
+		return boot.Token(token), nil
+	}
+	inv, err := invite.Saved(dir)
+	if err != nil {
+		return "", fmt.Errorf("token not set: %w", err)
+	}
+	return inv.Token, nil
+}
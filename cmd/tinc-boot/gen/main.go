@@ -11,6 +11,8 @@ import (
 	"github.com/phayes/permbits"
 	"github.com/reddec/tinc-boot/cmd/tinc-boot/gen/internal"
 	"github.com/reddec/tinc-boot/scripts"
+	"github.com/reddec/tinc-boot/tincd/boot"
+	"github.com/reddec/tinc-boot/tincd/daemon"
 	"github.com/reddec/tinc-boot/types"
 	"golang.org/x/crypto/chacha20poly1305"
 	"io"
@@ -41,6 +43,7 @@ type Cmd struct {
 	ConnectTo  []string      `long:"connect-to" env:"CONNECT_TO" description:"Add ConnectTo instruction (recommended nodes to connect)"`
 	Public     []string      `short:"a" alias:"addr" long:"public" env:"PUBLIC" description:"Public addresses that could be used for incoming connections"`
 	Standalone bool          `long:"standalone" env:"STANDALONE" description:"Do not use bootnodes (usefull for very-very first initialization)"`
+	Protocol   string        `long:"protocol" env:"PROTOCOL" description:"Boot protocol: bootnode (tinc-boot bootnode) or run (greeting service of tinc-boot run)" choice:"bootnode" choice:"run" default:"bootnode"`
 	Args       struct {
 		URLs []string `description:"boot node urls"`
 	} `positional-args:"yes"`
@@ -188,6 +191,9 @@ func (cmd *Cmd) boot() error {
 	if cmd.Standalone {
 		return nil
 	}
+	if cmd.Protocol == "run" {
+		return cmd.bootRun()
+	}
 	tokenData := sha256.Sum256([]byte(cmd.Token)) // normalize to 32 bytes
 	crypter, err := chacha20poly1305.NewX(tokenData[:])
 	if err != nil {
@@ -217,6 +223,26 @@ func (cmd *Cmd) boot() error {
 	return errors.New("all boot nodes failed")
 }
 
+// bootRun exchanges host files with greeting service of tinc-boot run.
+func (cmd *Cmd) bootRun() error {
+	config := daemon.Default(cmd.Dir())
+	for _, URL := range cmd.Args.URLs {
+		if !strings.Contains(URL, "://") {
+			URL = "http://" + URL
+		}
+		log.Println("trying", URL)
+		ctx, cancel := context.WithTimeout(context.Background(), cmd.Timeout)
+		err := boot.NewClient(URL, config, boot.Token(cmd.Token)).Exchange(ctx)
+		cancel()
+		if err != nil {
+			log.Println(URL, err)
+		} else {
+			return nil
+		}
+	}
+	return errors.New("all boot nodes failed")
+}
+
 func (cmd *Cmd) runKeyGen() error {
 	if cmd.NoGenKey {
 		return nil
@@ -0,0 +1,102 @@
+package invite
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rsc.io/qr"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+)
+
+// File name of invite saved by run command in work directory.
+const File = "invite"
+
+type Cmd struct {
+	Show ShowCmd `command:"show" description:"Show invite of running node"`
+}
+
+type ShowCmd struct {
+	Dir  string `short:"d" long:"dir" env:"DIR" description:"tinc-boot directory" default:"vpn"`
+	QR   bool   `long:"qr" env:"QR" description:"Render invite as QR code in terminal"`
+	Args struct {
+		Invite string `description:"invite to show instead of saved one"`
+	} `positional-args:"yes"`
+}
+
+func (cmd *ShowCmd) Execute([]string) error {
+	var invite *boot.Invite
+	var err error
+	if cmd.Args.Invite != "" {
+		invite, err = boot.ParseInvite(cmd.Args.Invite)
+	} else {
+		invite, err = Saved(cmd.Dir)
+	}
+	if err != nil {
+		return err
+	}
+	for _, u := range invite.URLs {
+		fmt.Println("URL:", u)
+	}
+	if len(invite.Fingerprint) > 0 {
+		fmt.Println("Fingerprint:", invite.FingerprintHex())
+	}
+	if len(invite.Key) > 0 {
+		fmt.Println("Key:", invite.KeyHex())
+	}
+	fmt.Println()
+	fmt.Println(invite.Encode())
+	if !cmd.QR {
+		return nil
+	}
+	fmt.Println()
+	return RenderQR(os.Stdout, invite.Encode())
+}
+
+// Saved invite of node in tinc-boot directory.
+func Saved(dir string) (*boot.Invite, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "run", File))
+	if err != nil {
+		return nil, fmt.Errorf("read saved invite (is node started?): %w", err)
+	}
+	return boot.ParseInvite(string(data))
+}
+
+// RenderQR prints text as QR code using unicode half-blocks (two modules per symbol).
+// Light modules are drawn, so it's readable on dark terminals.
+func RenderQR(out io.Writer, text string) error {
+	const quiet = 2
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return fmt.Errorf("encode QR: %w", err)
+	}
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true
+		}
+		return !code.Black(x, y)
+	}
+	var buf strings.Builder
+	for y := -quiet; y < code.Size+quiet; y += 2 {
+		for x := -quiet; x < code.Size+quiet; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				buf.WriteString("█")
+			case top:
+				buf.WriteString("▀")
+			case bottom:
+				buf.WriteString("▄")
+			default:
+				buf.WriteString(" ")
+			}
+		}
+		buf.WriteString("\n")
+	}
+	_, err = io.WriteString(out, buf.String())
+	return err
+}
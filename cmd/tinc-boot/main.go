@@ -6,13 +6,22 @@ import (
 
 	"github.com/jessevdk/go-flags"
 
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/audit"
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/authority"
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/bundle"
 	"github.com/reddec/tinc-boot/cmd/tinc-boot/forget"
 	"github.com/reddec/tinc-boot/cmd/tinc-boot/gen"
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/invite"
 	"github.com/reddec/tinc-boot/cmd/tinc-boot/kill"
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/migrate"
 	"github.com/reddec/tinc-boot/cmd/tinc-boot/monitor"
 	"github.com/reddec/tinc-boot/cmd/tinc-boot/node"
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/prune"
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/remove"
 	"github.com/reddec/tinc-boot/cmd/tinc-boot/run"
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/services"
 	"github.com/reddec/tinc-boot/cmd/tinc-boot/watch"
+	"github.com/reddec/tinc-boot/tincd/boot"
 )
 
 var (
@@ -21,16 +30,25 @@ var (
 )
 
 type Config struct {
-	Gen     gen.Cmd     `command:"gen" description:"Generate new tinc node over bootnode"`
-	Node    node.Cmd    `command:"bootnode" description:"Serve as a boot node"`
-	Monitor monitor.Cmd `command:"monitor" description:"Run as a daemon for watching new subnet and provide own host key (tinc-up)"`
-	Watch   watch.Cmd   `command:"watch" description:"Add new subnet to watch daemon to get it host file (subnet-up)"`
-	Forget  forget.Cmd  `command:"forget" description:"Forget subnet and stop watching it (subnet-down)"`
-	Kill    kill.Cmd    `command:"kill" description:"Kill monitor daemon (tinc-down)"`
-	Run     run.Cmd     `command:"run" description:"Run tincd daemon in managed way"`
+	Gen       gen.Cmd       `command:"gen" description:"Generate new tinc node over bootnode"`
+	Node      node.Cmd      `command:"bootnode" description:"Serve as a boot node"`
+	Monitor   monitor.Cmd   `command:"monitor" description:"Run as a daemon for watching new subnet and provide own host key (tinc-up)"`
+	Watch     watch.Cmd     `command:"watch" description:"Add new subnet to watch daemon to get it host file (subnet-up)"`
+	Forget    forget.Cmd    `command:"forget" description:"Forget subnet and stop watching it (subnet-down)"`
+	Kill      kill.Cmd      `command:"kill" description:"Kill monitor daemon (tinc-down)"`
+	Run       run.Cmd       `command:"run" description:"Run tincd daemon in managed way"`
+	Invite    invite.Cmd    `command:"invite" description:"Compact invite links for joining"`
+	Audit     audit.Cmd     `command:"audit" description:"Show audit log of join attempts"`
+	Migrate   migrate.Cmd   `command:"migrate" description:"Migrate legacy node (created by gen) to managed run layout"`
+	Bundle    bundle.Cmd    `command:"bundle" description:"Offline join bundles for nodes without access to boot servers"`
+	Remove    remove.Cmd    `command:"remove" description:"Remove node from the network (or re-admit removed node)"`
+	Authority authority.Cmd `command:"authority" description:"Network authority which signs admissions of nodes"`
+	Prune     prune.Cmd     `command:"prune" description:"Drop or archive nodes not seen alive for a long time"`
+	Services  services.Cmd  `command:"services" description:"Services catalog of nodes"`
 }
 
 func main() {
+	boot.AgentVersion = version
 	var config Config
 	parser := flags.NewParser(&config, flags.Default)
 	parser.LongDescription = fmt.Sprintf("Tinc nodes creator\n\nVersion: %s\nCommit: %s\n\nAuthor: Baryshnikov Aleksandr (reddec) <owner@reddec.net>",
@@ -0,0 +1,88 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// printDiff of file content in unified-like format (without hunks, all lines printed).
+func printDiff(file string, old []byte, exists bool, content []byte) {
+	if exists && bytes.Equal(old, content) {
+		fmt.Println("=", file, "(unchanged)")
+		return
+	}
+	if exists {
+		fmt.Println("---", file)
+	} else {
+		fmt.Println("---", "/dev/null")
+	}
+	fmt.Println("+++", file)
+	for _, line := range diffLines(splitLines(old), splitLines(content)) {
+		fmt.Println(line)
+	}
+	fmt.Println()
+}
+
+// printSecret reports file change without content.
+func printSecret(file string, old []byte, exists bool, content []byte) {
+	switch {
+	case !exists:
+		fmt.Println("+", file, "(secret, created)")
+	case bytes.Equal(old, content):
+		fmt.Println("=", file, "(unchanged)")
+	default:
+		fmt.Println("~", file, "(secret, replaced)")
+	}
+}
+
+func splitLines(data []byte) []string {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffLines based on longest common subsequence. Lines prefixed by ' ', '-' or '+'.
+func diffLines(a, b []string) []string {
+	// lcs[i][j] - length of LCS for a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var ans []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ans = append(ans, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ans = append(ans, "-"+a[i])
+			i++
+		default:
+			ans = append(ans, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ans = append(ans, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		ans = append(ans, "+"+b[j])
+	}
+	return ans
+}
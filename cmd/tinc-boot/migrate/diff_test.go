@@ -0,0 +1,29 @@
+package migrate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	cases := []struct {
+		name     string
+		old, new string
+		expected []string
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", []string{" a", " b"}},
+		{"created", "", "a\nb\n", []string{"+a", "+b"}},
+		{"removed", "a\nb\n", "", []string{"-a", "-b"}},
+		{"replaced", "a\nb\nc\n", "a\nx\nc\n", []string{" a", "-b", "+x", " c"}},
+		{"appended", "a\n", "a\nb\n", []string{" a", "+b"}},
+		{"trailing newline added", "a\nb", "a\nb\n", []string{" a", " b"}},
+		{"trailing newline only", "\n", "", nil},
+		{"moved", "a\nb\nc\n", "b\nc\na\n", []string{"-a", " b", " c", "+a"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, diffLines(splitLines([]byte(c.old)), splitLines([]byte(c.new))))
+		})
+	}
+}
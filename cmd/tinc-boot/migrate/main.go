@@ -0,0 +1,276 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+	"github.com/reddec/tinc-boot/tincd/config"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/reddec/tinc-boot/types"
+)
+
+// private keys generated by tincd -K (1.0 and 1.1)
+var keyFiles = []string{"rsa_key.priv", "ed25519_key.priv"}
+
+type Cmd struct {
+	From      string `long:"from" env:"FROM" description:"Legacy network directory (created by gen)" required:"yes"`
+	To        string `long:"to" env:"TO" description:"tinc-boot directory for run command" required:"yes"`
+	Network   string `long:"network" env:"NETWORK" description:"Legacy network name for tinc@<network> unit. If not set - base name of source directory"`
+	DryRun    bool   `long:"dry-run" env:"DRY_RUN" description:"Show changes as diff without applying them"`
+	Force     bool   `long:"force" env:"FORCE" description:"Overwrite already configured destination"`
+	KeepUnit  bool   `long:"keep-unit" env:"KEEP_UNIT" description:"Do not stop and disable tinc@<network> unit"`
+	Systemctl string `long:"systemctl" env:"SYSTEMCTL" description:"systemctl binary" default:"systemctl"`
+}
+
+// planned file change
+type change struct {
+	File    string
+	Content []byte
+	Mode    os.FileMode
+	Secret  bool // do not show content in diff
+}
+
+func (cmd *Cmd) Execute([]string) error {
+	if cmd.Network == "" {
+		cmd.Network = filepath.Base(filepath.Clean(cmd.From))
+	}
+	configDir := filepath.Join(cmd.To, "config")
+	workDir := filepath.Join(cmd.To, "run")
+	if !cmd.DryRun && !cmd.Force {
+		if _, err := os.Stat(filepath.Join(configDir, "tinc.conf")); err == nil {
+			return fmt.Errorf("destination %s already configured (use --force to overwrite)", cmd.To)
+		}
+	}
+
+	changes, err := cmd.plan(configDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	if cmd.DryRun {
+		for _, c := range changes {
+			old, err := ioutil.ReadFile(c.File)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("read %s: %w", c.File, err)
+			}
+			if c.Secret {
+				printSecret(c.File, old, err == nil, c.Content)
+			} else {
+				printDiff(c.File, old, err == nil, c.Content)
+			}
+		}
+		if !cmd.KeepUnit {
+			fmt.Println("#", cmd.Systemctl, "disable --now", cmd.unit())
+		}
+		return nil
+	}
+
+	for _, dir := range []string{filepath.Join(configDir, "hosts"), workDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("create %s: %w", dir, err)
+		}
+	}
+	for _, c := range changes {
+		if err := ioutil.WriteFile(c.File, c.Content, c.Mode); err != nil {
+			return fmt.Errorf("write %s: %w", c.File, err)
+		}
+		log.Println("saved", c.File)
+	}
+
+	if !cmd.KeepUnit {
+		out, err := exec.Command(cmd.Systemctl, "disable", "--now", cmd.unit()).CombinedOutput()
+		if err != nil {
+			log.Println("failed disable", cmd.unit(), ":", err, strings.TrimSpace(string(out)))
+		} else {
+			log.Println("disabled", cmd.unit())
+		}
+	}
+	fmt.Println("migration complete, start node by:")
+	fmt.Println()
+	fmt.Println("     ", os.Args[0], "run --dir", cmd.To)
+	fmt.Println()
+	return nil
+}
+
+func (cmd *Cmd) unit() string {
+	return "tinc@" + cmd.Network
+}
+
+// plan all files changes without touching destination.
+func (cmd *Cmd) plan(configDir, workDir string) ([]change, error) {
+	var main config.Main
+	if err := config.ReadFile(filepath.Join(cmd.From, "tinc.conf"), &main); err != nil {
+		return nil, fmt.Errorf("read tinc.conf: %w", err)
+	}
+	if main.Name == "" {
+		return nil, fmt.Errorf("node name not defined in tinc.conf")
+	}
+	if main.Interface == "" {
+		main.Interface = cmd.Network
+	}
+	original, err := ioutil.ReadFile(filepath.Join(cmd.From, "tinc.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("read tinc.conf: %w", err)
+	}
+	tincConf, err := config.Marshal(main)
+	if err != nil {
+		return nil, fmt.Errorf("marshal tinc.conf: %w", err)
+	}
+	for _, key := range droppedKeys(original, tincConf) {
+		log.Println("option", key, "from tinc.conf is not supported by run and will be dropped")
+	}
+	var changes = []change{{File: filepath.Join(configDir, "tinc.conf"), Content: tincConf, Mode: 0644}}
+
+	hosts, err := cmd.hosts()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := hosts[main.Name]; !ok {
+		return nil, fmt.Errorf("host file of self node %s not found", main.Name)
+	}
+	var names = make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		changes = append(changes, change{File: filepath.Join(configDir, "hosts", name), Content: hosts[name], Mode: 0644})
+	}
+
+	for _, keyFile := range keyFiles {
+		content, err := ioutil.ReadFile(filepath.Join(cmd.From, keyFile))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("read key: %w", err)
+		}
+		changes = append(changes, change{File: filepath.Join(configDir, keyFile), Content: content, Mode: 0600, Secret: true})
+	}
+
+	ssdContent, err := seedSSD(filepath.Join(workDir, "discovery.json"), names)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, change{File: filepath.Join(workDir, "discovery.json"), Content: ssdContent, Mode: 0644})
+
+	cidr, err := tincUpNetwork(filepath.Join(cmd.From, "tinc-up"))
+	if err != nil {
+		log.Println("network CIDR not detected (--cidr of run will be used):", err)
+	} else {
+		network, err := json.MarshalIndent(boot.Network{CIDR: cidr}, "", " ")
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change{File: filepath.Join(workDir, "network.json"), Content: network, Mode: 0644})
+	}
+	return changes, nil
+}
+
+// hosts reads and validates all host files.
+func (cmd *Cmd) hosts() (map[string][]byte, error) {
+	list, err := ioutil.ReadDir(filepath.Join(cmd.From, "hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("read hosts: %w", err)
+	}
+	var ans = make(map[string][]byte)
+	for _, item := range list {
+		if item.IsDir() {
+			continue
+		}
+		name := item.Name()
+		if types.CleanString(name) != name {
+			log.Println("skipping host file", name, "(invalid node name)")
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(cmd.From, "hosts", name))
+		if err != nil {
+			return nil, fmt.Errorf("read host %s: %w", name, err)
+		}
+		var node config.Node
+		if err := config.Unmarshal(content, &node); err != nil {
+			log.Println("skipping host file", name, ":", err)
+			continue
+		}
+		ans[name] = content
+	}
+	return ans, nil
+}
+
+// seedSSD merges hosts into existent discovery metadata (if any) with initial version.
+func seedSSD(file string, names []string) ([]byte, error) {
+	ssd := discovery.NewSSD(file)
+	if err := ssd.Read(); err != nil {
+		return nil, fmt.Errorf("read discovery: %w", err)
+	}
+	for _, name := range names {
+		ssd.ReplaceIfNewer(discovery.Entity{Name: name}, nil)
+	}
+	var out bytes.Buffer
+	if err := ssd.Marshal(&out); err != nil {
+		return nil, fmt.Errorf("marshal discovery: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// tincUpNetwork finds `ip addr add <ip>/<mask>` in tinc-up script and returns network CIDR.
+func tincUpNetwork(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for i := 0; i+3 < len(fields); i++ {
+			if fields[i] != "ip" || !strings.HasPrefix(fields[i+1], "a") || fields[i+2] != "add" {
+				continue
+			}
+			_, network, err := net.ParseCIDR(fields[i+3])
+			if err != nil {
+				return "", fmt.Errorf("parse address %s: %w", fields[i+3], err)
+			}
+			return network.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no address assignment in %s", file)
+}
+
+// droppedKeys returns config keys from original file which are not in converted file.
+func droppedKeys(original, converted []byte) []string {
+	keep := configKeys(converted)
+	var ans []string
+	for key := range configKeys(original) {
+		if !keep[key] {
+			ans = append(ans, key)
+		}
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+func configKeys(content []byte) map[string]bool {
+	var ans = make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "-----") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 {
+			ans[strings.ToLower(strings.TrimSpace(kv[0]))] = true
+		}
+	}
+	return ans
+}
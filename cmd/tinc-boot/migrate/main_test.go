@@ -0,0 +1,39 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTincUpNetwork(t *testing.T) {
+	cases := []struct {
+		name     string
+		script   string
+		expected string // empty - error
+	}{
+		{"gen script", "#!/usr/bin/env bash\nip addr add 10.155.0.7/16 dev $INTERFACE\nip link set dev $INTERFACE up\n", "10.155.0.0/16"},
+		{"short command", "ip a add 172.16.3.1/12 dev $INTERFACE", "172.16.0.0/12"},
+		{"inside of line", "sudo ip addr add 192.168.5.5/24 dev tun0 || true\n", "192.168.5.0/24"},
+		{"first assignment", "ip addr add 10.0.0.1/8 dev $INTERFACE\nip addr add 192.168.0.1/24 dev $INTERFACE\n", "10.0.0.0/8"},
+		{"no prefix", "ip addr add 10.0.0.1 dev $INTERFACE\n", ""},
+		{"no assignment", "#!/bin/sh\nip link set dev $INTERFACE up\n", ""},
+		{"empty", "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "tinc-up")
+			require.NoError(t, ioutil.WriteFile(file, []byte(c.script), 0755))
+			network, err := tincUpNetwork(file)
+			if c.expected == "" {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, network)
+		})
+	}
+}
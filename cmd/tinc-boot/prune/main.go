@@ -0,0 +1,65 @@
+package prune
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+type Cmd struct {
+	Dir       string        `short:"d" long:"dir" env:"DIR" description:"tinc-boot directory" default:"vpn"`
+	Retention time.Duration `long:"retention" env:"RETENTION" description:"Nodes not seen alive during this time are dead" default:"720h"`
+	Mode      string        `long:"mode" env:"MODE" description:"Policy for dead nodes: drop from ConnectTo only or move host files to hosts.archive" choice:"connect" choice:"archive" default:"connect"`
+	DryRun    bool          `long:"dry-run" env:"DRY_RUN" description:"Only show dead nodes without changes"`
+}
+
+func (cmd *Cmd) Execute([]string) error {
+	if cmd.Retention <= 0 {
+		return fmt.Errorf("retention should be positive")
+	}
+	daemonConfig := daemon.Default(filepath.Join(cmd.Dir, "config"))
+	main, err := daemonConfig.Main()
+	if err != nil {
+		return fmt.Errorf("read main config: %w", err)
+	}
+	ssd := discovery.NewSSD(filepath.Join(cmd.Dir, "run", "discovery.json"))
+	if err := ssd.Read(); err != nil {
+		return fmt.Errorf("read discovery: %w", err)
+	}
+	retention := discovery.Retention{
+		Period: cmd.Retention,
+		Mode:   cmd.Mode,
+	}
+	now := time.Now()
+	dead := retention.Dead(ssd, main.Name, now)
+	connected := make(map[string]bool, len(main.ConnectTo))
+	for _, name := range main.ConnectTo {
+		connected[name] = true
+	}
+	for _, entity := range dead {
+		state := "archived"
+		if _, err := os.Stat(filepath.Join(daemonConfig.HostsDir(), entity.Name)); err == nil {
+			state = "host file"
+			if connected[entity.Name] {
+				state += ", connect"
+			}
+		}
+		fmt.Println(entity.Name, "last seen", entity.Seen.Format(time.RFC3339), "("+now.Sub(entity.Seen).Truncate(time.Minute).String(), "ago;", state+")")
+	}
+	fmt.Println(len(dead), "dead nodes")
+	if cmd.DryRun || len(dead) == 0 {
+		return nil
+	}
+	if err := retention.Apply(daemonConfig, dead); err != nil {
+		return err
+	}
+	fmt.Println("policy", cmd.Mode, "applied - changes are used after reload of tincd")
+	if cmd.Mode == discovery.RetainConnect {
+		fmt.Println("run node with --retention to keep dead nodes out of ConnectTo after restart")
+	}
+	return nil
+}
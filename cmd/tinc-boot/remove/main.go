@@ -0,0 +1,61 @@
+package remove
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/invite"
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/boot"
+)
+
+type Cmd struct {
+	Dir   string   `short:"d" long:"dir" env:"DIR" description:"tinc-boot directory" default:"vpn"`
+	Token string   `short:"t" long:"token" env:"TOKEN" description:"Boot token. If not set - token from saved invite will be used"`
+	URL   []string `short:"u" long:"url" env:"URL" env-delim:"," description:"Greeting service of any running node. If not set - URLs from saved invite will be used"`
+	Admit bool     `long:"admit" env:"ADMIT" description:"Re-admit previously removed node instead of removal"`
+	Key   string   `short:"k" long:"key" env:"KEY" description:"Key of network administrator (see authority init): admin key or authority key" default:"admin.key"`
+	Args  struct {
+		Name string `description:"node name" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (cmd *Cmd) Execute([]string) error {
+	admin, err := authority.ReadKey(cmd.Key)
+	if err != nil {
+		return fmt.Errorf("read administrator key: %w", err)
+	}
+	token := boot.Token(cmd.Token)
+	urls := cmd.URL
+	if token == "" || len(urls) == 0 {
+		inv, err := invite.Saved(cmd.Dir)
+		if err != nil {
+			return fmt.Errorf("token or URL not set: %w", err)
+		}
+		if token == "" {
+			token = inv.Token
+		}
+		if len(urls) == 0 {
+			urls = inv.JoinURLs()
+		}
+	}
+	action := boot.ActionRemove
+	if cmd.Admit {
+		action = boot.ActionAdmit
+	}
+	for _, url := range urls {
+		entity, err := boot.NewClient(url, nil, token).Admin(context.Background(), action, cmd.Args.Name, admin)
+		if err != nil {
+			log.Println(url, err)
+			continue
+		}
+		if entity.Removed {
+			fmt.Println("node", entity.Name, "removed, version", entity.Version)
+		} else {
+			fmt.Println("node", entity.Name, "re-admitted, version", entity.Version, "- join it again by boot server")
+		}
+		return nil
+	}
+	return fmt.Errorf("all boot servers failed")
+}
@@ -1,7 +1,12 @@
 package run
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -12,16 +17,22 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/reddec/tinc-boot/cmd/tinc-boot/invite"
+	"github.com/reddec/tinc-boot/tincd/authority"
 	"github.com/reddec/tinc-boot/tincd/boot"
 	"github.com/reddec/tinc-boot/tincd/config"
 	"github.com/reddec/tinc-boot/tincd/daemon"
 	"github.com/reddec/tinc-boot/tincd/daemon/utils"
 	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/reddec/tinc-boot/tincd/dns"
+	"github.com/reddec/tinc-boot/tincd/gateway"
+	"github.com/reddec/tinc-boot/tincd/hostsfile"
+	"github.com/reddec/tinc-boot/tincd/ipam"
+	"github.com/reddec/tinc-boot/tincd/lan"
 	"github.com/reddec/tinc-boot/types"
 )
 
@@ -32,16 +43,65 @@ type Cmd struct {
 	Device            string        `long:"device" env:"DEVICE" description:"Device name. If not defined - will use last 5 symbols of resolved name"`
 	Port              uint16        `short:"p" long:"port" env:"PORT" description:"Greeting service binding port" default:"8655"`
 	Host              string        `short:"h" long:"host" env:"HOST" description:"Greeting service binding host" default:""`
-	Token             string        `short:"t" long:"token" env:"TOKEN" description:"Boot token. If not defined - random string will be generated and printed"`
+	Token             string        `short:"t" long:"token" env:"TOKEN" description:"Boot token. If not defined - saved token (run/token) is used or random string will be generated and printed"`
 	TLS               bool          `long:"tls" env:"TLS" description:"Enable TLS for greeting protocol"`
+	TLSAuto           bool          `long:"tls-auto" env:"TLS_AUTO" description:"Enable TLS for greeting protocol with generated once self-signed certificate (cert and key flags are ignored)"`
+	TLSClientCA       string        `long:"tls-client-ca" env:"TLS_CLIENT_CA" description:"Require greeting clients to present certificate signed by CA from the bundle"`
 	Cert              string        `long:"cert" env:"CERT" description:"TLS certificate" default:"server.crt"`
 	Key               string        `long:"key" env:"KEY" description:"TLS key" default:"server.key"`
-	IP                string        `long:"ip" env:"IP" description:"VPN IP for fresh node. If not set - random will be generated once in 172.16.0.0/12"`
+	IP                string        `long:"ip" env:"IP" description:"VPN IP for fresh node. If not set - free address will be allocated once in network CIDR (by boot server if joining)"`
+	IP6               string        `long:"ip6" env:"IP6" description:"Own IPv6 address in the mesh (ex: fd00::1), required to route IPv6 through exit node"`
+	CIDR              string        `long:"cidr" env:"CIDR" description:"VPN network CIDR used for addresses allocation. Saved network settings (run/network.json) have priority" default:"172.16.0.0/12"`
 	Dir               string        `short:"d" long:"dir" env:"DIR" description:"tinc-boot directory. Will be created if not exists" default:"vpn"`
 	Tincd             string        `long:"tincd" env:"TINCD" description:"tincd binary location" default:"tincd"`
 	Join              []string      `short:"j" long:"join" env:"JOIN" description:"URLs to join to another network"`
+	JoinInvite        string        `long:"join-invite" env:"JOIN_INVITE" description:"Invite (tinc-boot://...) to join to another network. Replaces token and join URLs"`
+	ImportBundle      string        `long:"import-bundle" env:"IMPORT_BUNDLE" description:"Offline join bundle (see bundle export). Token is required"`
+	JoinLAN           bool          `long:"join-lan" env:"JOIN_LAN" description:"Find greeting services in local network by mDNS and join them (token is required). Only for fresh node"`
+	LANAnnounce       bool          `long:"lan-announce" env:"LAN_ANNOUNCE" description:"Announce greeting service in local network by mDNS (_tinc-boot._tcp). Token is never announced"`
+	LANNetwork        string        `long:"lan-network" env:"LAN_NETWORK" description:"Network name for LAN announces and filter for --join-lan. If not set - network CIDR is announced and any network is joined"`
+	JoinCA            string        `long:"join-ca" env:"JOIN_CA" description:"CA bundle to verify boot servers. Fingerprint in URL (#sha256=<hex>) has priority"`
+	JoinCert          string        `long:"join-cert" env:"JOIN_CERT" description:"Client TLS certificate for boot servers"`
+	JoinKey           string        `long:"join-key" env:"JOIN_KEY" description:"Client TLS key for boot servers"`
 	JoinRetry         time.Duration `long:"join-retry" env:"JOIN_RETRY" description:"Retry interval" default:"15s"`
-	DiscoveryInterval time.Duration `long:"discovery-interval" env:"DISCOVERY_INTERVAL" description:"Interval between discovery" default:"5s"`
+	ResyncInterval    time.Duration `long:"resync-interval" env:"RESYNC_INTERVAL" description:"Interval between re-exchange with known boot servers (0 - disable)" default:"1h"`
+	ResyncIsolation   time.Duration `long:"resync-isolation" env:"RESYNC_ISOLATION" description:"Re-exchange with known boot servers if there are no reachable peers during this time (0 - disable)" default:"5m"`
+	DiscoveryInterval time.Duration `long:"discovery-interval" env:"DISCOVERY_INTERVAL" description:"Interval between discovery for peers without long-polling support and retries after failures" default:"5s"`
+	GossipFanout      int           `long:"gossip-fanout" env:"GOSSIP_FANOUT" description:"Gossip mode for large networks: sync with this number of random peers per discovery interval instead of watching every peer (0 - disable)"`
+	Registry          []string      `long:"registry" env:"REGISTRY" env-delim:"," description:"External registry to publish own record and pull others: shared directory, s3://bucket/prefix?endpoint=... or dns://server/zone"`
+	RegistryInterval  time.Duration `long:"registry-interval" env:"REGISTRY_INTERVAL" description:"Interval between sync with external registries" default:"1m"`
+	RateLimit         float64       `long:"rate-limit" env:"RATE_LIMIT" description:"Greeting requests per second per source IP" default:"1"`
+	RateBurst         int           `long:"rate-burst" env:"RATE_BURST" description:"Maximum burst of greeting requests per source IP" default:"10"`
+	BanFailures       int           `long:"ban-failures" env:"BAN_FAILURES" description:"Ban source IP after this number of failed attempts in a row (0 - disable)" default:"5"`
+	BanTime           time.Duration `long:"ban-time" env:"BAN_TIME" description:"Ban duration" default:"15m"`
+	AuditLog          string        `long:"audit-log" env:"AUDIT_LOG" description:"Audit log of join attempts. If not set - run/audit.log in tinc-boot directory"`
+	Role              string        `long:"role" env:"ROLE" description:"Requested role of node, reported to boot servers"`
+	Tags              []string      `long:"tag" env:"TAG" env-delim:"," description:"Node tags, reported to boot servers"`
+	RequireSignatures bool          `long:"require-signatures" env:"REQUIRE_SIGNATURES" description:"Reject unsigned host records from discovery (records with invalid signatures are always rejected)"`
+	Authority         string        `long:"authority" env:"AUTHORITY" description:"Public key of network authority (see authority init). Hosts without valid admission are refused. Saved in network settings"`
+	AuthorityKey      string        `long:"authority-key" env:"AUTHORITY_KEY" description:"Authority key to issue and renew admissions for joining nodes by this boot server"`
+	AdmissionTTL      time.Duration `long:"admission-ttl" env:"ADMISSION_TTL" description:"Validity of issued admissions. Admissions are renewed by re-exchange when less than half left" default:"720h"`
+	Admin             string        `long:"admin" env:"ADMIN" description:"Public key of network administrator (see authority init) which signs removals and re-admissions of nodes. Network authority is trusted too. Saved in network settings"`
+	Admission         string        `long:"admission" env:"ADMISSION" description:"Admission of this node issued offline (see authority issue)"`
+	Retention         time.Duration `long:"retention" env:"RETENTION" description:"Nodes not seen alive during this time are dead (0 - disable). See also prune"`
+	RetentionMode     string        `long:"retention-mode" env:"RETENTION_MODE" description:"Policy for dead nodes: drop from ConnectTo only or move host files to hosts.archive" choice:"connect" choice:"archive" default:"connect"`
+	Services          string        `long:"services" env:"SERVICES" description:"Services catalog of node (list of name, port, protocol, tags). If not set - services.yaml in tinc-boot directory"`
+	API               string        `long:"api" env:"API" description:"Local API binding address (services catalog). Empty - disabled" default:"127.0.0.1:18656"`
+	DNS               bool          `long:"dns" env:"DNS" description:"Start DNS server on own VPN IP (port 53): <name>.<zone> for nodes, SRV records for services, other names are forwarded"`
+	DNSZone           string        `long:"dns-zone" env:"DNS_ZONE" description:"DNS zone of the network. If not set - <network>.vpn, where network is --lan-network or base name of tinc-boot directory"`
+	DNSUpstream       []string      `long:"dns-upstream" env:"DNS_UPSTREAM" env-delim:"," description:"Resolvers (host:port) for names outside of zone. If not set - nameservers from /etc/resolv.conf"`
+	DNSSplit          bool          `long:"dns-split" env:"DNS_SPLIT" description:"Route DNS zone to own DNS server by systemd-resolved (split DNS on VPN interface)"`
+	Routes            []string      `long:"route" env:"ROUTE" env-delim:"," description:"Extra subnet behind this node (ex: office LAN) advertised through the mesh, with optional weight for failover: 10.20.0.0/24#10. Enables IP forwarding"`
+	Masquerade        bool          `long:"masquerade" env:"MASQUERADE" description:"Masquerade traffic from VPN to advertised routes (if LAN has no return route to VPN)"`
+	ExitNode          bool          `long:"exit-node" env:"EXIT_NODE" description:"Route internet traffic of other nodes: advertise default routes (0.0.0.0/0 and ::/0), enable forwarding and NAT"`
+	ExitWeight        uint          `long:"exit-weight" env:"EXIT_WEIGHT" description:"Weight of default routes of exit node (lower is preferred)" default:"10"`
+	UseExit           string        `long:"use-exit" env:"USE_EXIT" description:"Route all traffic through named exit node (IPv6 only with --ip6, otherwise it's blocked). Traffic to public addresses of tinc peers stays outside of the tunnel"`
+	HostsFile         string        `long:"hosts-file" env:"HOSTS_FILE" description:"Manage block of node names (<name>.<domain>) in hosts file, ex: /etc/hosts. Empty - disabled"`
+	HostsDomain       string        `long:"hosts-domain" env:"HOSTS_DOMAIN" description:"Domain of node names in hosts file" default:"tinc"`
+	Mode              string        `long:"mode" env:"MODE" description:"Tinc mode saved in tinc.conf: router (IP, default) or switch (layer 2 over tap device). If not set - configured or provided by boot server" choice:"router" choice:"switch"`
+	Bridge            string        `long:"bridge" env:"BRIDGE" description:"Switch mode: attach tap device to existent Linux bridge (own address is assigned to the bridge)"`
+	Addressing        string        `long:"addressing" env:"ADDRESSING" description:"Switch mode: own VPN IP with network prefix only (static) or also address from DHCP server of the segment (dhcp)" choice:"static" choice:"dhcp" default:"static"`
+	DHCPClient        string        `long:"dhcp-client" env:"DHCP_CLIENT" description:"Switch mode: DHCP client command running in foreground, interface name is appended" default:"dhclient -d"`
 	UFW               bool          `long:"ufw" env:"UFW" description:"Open ports using ufw" `
 }
 
@@ -65,8 +125,86 @@ func (cmd Cmd) ssdFile() string {
 	return filepath.Join(cmd.workDir(), "discovery.json")
 }
 
-func (cmd Cmd) clockFile() string {
-	return filepath.Join(cmd.workDir(), "clock")
+func (cmd Cmd) networkFile() string {
+	return filepath.Join(cmd.workDir(), "network.json")
+}
+
+// dnsZone of the network: explicit or <network>.vpn, so nodes are resolved as <name>.<network>.vpn.
+func (cmd Cmd) dnsZone() string {
+	if cmd.DNSZone != "" {
+		return cmd.DNSZone
+	}
+	network := cmd.LANNetwork
+	if network == "" {
+		dir, err := filepath.Abs(cmd.Dir)
+		if err != nil {
+			dir = cmd.Dir
+		}
+		network = filepath.Base(dir)
+	}
+	return strings.ToLower(network) + ".vpn"
+}
+
+func (cmd Cmd) reverseBundleFile() string {
+	return filepath.Join(cmd.workDir(), "reverse.bundle")
+}
+
+func (cmd Cmd) admissionFile() string {
+	return filepath.Join(cmd.workDir(), "admission.json")
+}
+
+func (cmd Cmd) servicesFile() string {
+	if cmd.Services != "" {
+		return cmd.Services
+	}
+	return filepath.Join(cmd.Dir, "services.yaml")
+}
+
+func (cmd Cmd) auditFile() string {
+	if cmd.AuditLog != "" {
+		return cmd.AuditLog
+	}
+	return filepath.Join(cmd.workDir(), "audit.log")
+}
+
+func (cmd Cmd) tlsEnabled() bool {
+	return cmd.TLS || cmd.TLSAuto
+}
+
+func (cmd Cmd) certFiles() (string, string) {
+	if cmd.TLSAuto {
+		return filepath.Join(cmd.workDir(), "server.crt"), filepath.Join(cmd.workDir(), "server.key")
+	}
+	return cmd.Cert, cmd.Key
+}
+
+func (cmd Cmd) joinTLS() boot.TLSOptions {
+	return boot.TLSOptions{
+		CA:   cmd.JoinCA,
+		Cert: cmd.JoinCert,
+		Key:  cmd.JoinKey,
+	}
+}
+
+func (cmd Cmd) bootFile() string {
+	return filepath.Join(cmd.workDir(), "boot.json")
+}
+
+func (cmd Cmd) tokenFile() string {
+	return filepath.Join(cmd.workDir(), "token")
+}
+
+// savedToken from previous run. Empty if not saved.
+func (cmd Cmd) savedToken() (string, error) {
+	data, err := ioutil.ReadFile(cmd.tokenFile())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+func (cmd Cmd) inviteFile() string {
+	return filepath.Join(cmd.workDir(), invite.File)
 }
 
 func (cmd Cmd) advertise() []string {
@@ -118,16 +256,57 @@ func (cmd Cmd) deviceName() string {
 	return name[len(name)-5:]
 }
 
-func (cmd Cmd) ip() string {
+func (cmd Cmd) ip(pool *ipam.Pool) (string, error) {
 	if cmd.IP != "" {
-		return cmd.IP
+		return cmd.IP, nil
 	}
-
-	return net.IPv4(172, 16 + byte(rand.Intn(15)), byte(rand.Intn(255)), 1+byte(rand.Intn(254))).String()
+	ip, err := pool.Allocate(cmd.name())
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
 }
 
 func (cmd *Cmd) Execute([]string) error {
 	rand.Seed(time.Now().UnixNano())
+	if cmd.JoinInvite != "" {
+		inv, err := boot.ParseInvite(cmd.JoinInvite)
+		if err != nil {
+			return fmt.Errorf("parse invite: %w", err)
+		}
+		cmd.Token = string(inv.Token)
+		cmd.Join = append(cmd.Join, inv.JoinURLs()...)
+	}
+	if cmd.JoinLAN && !daemon.Default(cmd.configDir()).Configured() {
+		if cmd.Token == "" {
+			return fmt.Errorf("token required to join network found in LAN")
+		}
+		urls, err := cmd.browseLAN()
+		if err != nil {
+			return fmt.Errorf("browse LAN: %w", err)
+		}
+		cmd.Join = append(cmd.Join, urls...)
+	}
+	if cmd.ExitNode && cmd.UseExit != "" {
+		return fmt.Errorf("exit node can't use another exit node")
+	}
+	if cmd.ExitNode {
+		cmd.Routes = append(cmd.Routes, fmt.Sprint("0.0.0.0/0#", cmd.ExitWeight), fmt.Sprint("::/0#", cmd.ExitWeight))
+	}
+	if cmd.IP6 != "" {
+		ip := net.ParseIP(cmd.IP6)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %s", cmd.IP6)
+		}
+		cmd.IP6 = ip.String()
+	}
+	for i, route := range cmd.Routes {
+		normalized, err := gateway.ParseRoute(route)
+		if err != nil {
+			return fmt.Errorf("parse route: %w", err)
+		}
+		cmd.Routes[i] = normalized
+	}
 	if err := os.MkdirAll(cmd.configDir(), 0755); err != nil {
 		return fmt.Errorf("create configuration dir: %w", err)
 	}
@@ -137,14 +316,32 @@ func (cmd *Cmd) Execute([]string) error {
 	if err := os.MkdirAll(cmd.workDir(), 0755); err != nil {
 		return fmt.Errorf("create work dir: %w", err)
 	}
+	if cmd.Token == "" {
+		saved, err := cmd.savedToken()
+		if err != nil {
+			return fmt.Errorf("read saved token: %w", err)
+		}
+		cmd.Token = saved
+	}
+	if cmd.ImportBundle != "" && cmd.Token == "" {
+		return fmt.Errorf("token required to open bundle")
+	}
+	if cmd.Token == "" {
+		cmd.Token = utils.RandStringRunes(64)
+	}
+	// re-exchange with known boot servers (boot.json) after restart requires the same token
+	if err := ioutil.WriteFile(cmd.tokenFile(), []byte(cmd.Token), 0600); err != nil {
+		return fmt.Errorf("save token: %w", err)
+	}
 
-	tick, err := cmd.nextTick()
+	bundle, err := cmd.importBundle()
 	if err != nil {
-		return fmt.Errorf("count clock tick: %w", err)
+		return fmt.Errorf("import bundle: %w", err)
 	}
 
 	daemonConfig := daemon.Default(cmd.configDir())
 	daemonConfig.PidFile = filepath.Join(cmd.workDir(), "pid.run")
+	daemonConfig.IP6 = cmd.IP6
 
 	ssd := discovery.NewSSD(cmd.ssdFile())
 
@@ -158,11 +355,39 @@ func (cmd *Cmd) Execute([]string) error {
 		return fmt.Errorf("read hosts: %w", err)
 	}
 	for _, host := range hosts {
+		if ssd.Removed(host) {
+			if err := daemonConfig.RemoveHost(host); err != nil {
+				log.Println("failed remove host", host, ":", err)
+			}
+			continue
+		}
 		ssd.ReplaceIfNewer(discovery.Entity{
 			Name: host,
 		}, nil)
 	}
 
+	network, err := boot.ReadNetwork(cmd.networkFile())
+	if err != nil {
+		return fmt.Errorf("read network settings: %w", err)
+	}
+	if network.CIDR == "" {
+		network.CIDR = cmd.CIDR
+	}
+
+	pool, err := ipam.New(network.CIDR, daemonConfig)
+	if err != nil {
+		return fmt.Errorf("create address pool: %w", err)
+	}
+
+	authorityKey, issuer, err := cmd.authority(&network)
+	if err != nil {
+		return fmt.Errorf("configure authority: %w", err)
+	}
+	admins, err := cmd.admins(&network, authorityKey)
+	if err != nil {
+		return fmt.Errorf("configure administrator: %w", err)
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Kill, os.Interrupt)
 	defer cancel()
 
@@ -170,43 +395,226 @@ func (cmd *Cmd) Execute([]string) error {
 		cmd.automaticFirewall(ctx, daemonConfig)
 	}
 
+	token := boot.Token(cmd.Token)
+	var (
+		self     discovery.Entity // own discovery record
+		selfLock sync.Mutex
+	)
+	self.Version = ssd.Next()
+	self.Admission = cmd.readAdmission()
+	var admitted func(admission *authority.Admission)
+	prepareClient := func(client *boot.Client) {
+		selfLock.Lock()
+		defer selfLock.Unlock()
+		client.TLS = cmd.joinTLS()
+		client.SSD = ssd
+		client.Version = self.Version
+		client.Role = cmd.Role
+		client.Tags = cmd.Tags
+		client.Signature = self.Signature
+		client.Catalog = self.Catalog
+		client.Authority = authorityKey
+		client.Admission = self.Admission
+		client.Admitted = func(admission *authority.Admission) {
+			if admitted != nil {
+				admitted(admission)
+				return
+			}
+			// during configuration
+			cmd.saveAdmission(admission)
+			selfLock.Lock()
+			self.Admission = admission
+			selfLock.Unlock()
+		}
+		client.Settings = func(server string, network boot.Network) {
+			cmd.applyNetwork(daemonConfig, pool, server, network)
+		}
+	}
+
 	// configure daemon if needed
 	if !daemonConfig.Configured() {
 		log.Println("configuration not exists or invalid - creating a new one")
-		err := cmd.createConfig(ctx, daemonConfig)
+		err := cmd.createConfig(ctx, daemonConfig, pool, bundle)
 		if err != nil {
 			return fmt.Errorf("create config: %w", err)
 		}
+		if cmd.IP == "" && len(cmd.Join) > 0 {
+			cmd.allocateAddress(ctx, daemonConfig, token, prepareClient)
+		}
 	} else {
 		log.Println("using existent configuration")
 	}
 
-	main, _, err := config.ReadNodeConfig(daemonConfig.ConfigDir)
+	if bundle != nil {
+		cmd.applyNetwork(daemonConfig, pool, bundle.Server, bundle.Network)
+		cmd.importBundleHosts(daemonConfig, ssd, bundle, authorityKey)
+	}
+
+	if cmd.Mode != "" {
+		err := daemonConfig.UpdateMain(func(main *config.Main) {
+			main.Mode = cmd.Mode
+		})
+		if err != nil {
+			return fmt.Errorf("set mode: %w", err)
+		}
+	}
+
+	main, node, err := config.ReadNodeConfig(daemonConfig.ConfigDir)
 	if err != nil {
 		return fmt.Errorf("read generated config: %w", err)
 	}
+	cmd.switchMode(daemonConfig, pool, main.Mode)
+
+	for _, subnet := range node.Subnet {
+		if _, err := ipam.ParseSubnet(subnet); err != nil || pool.Covers(subnet) {
+			continue // not IP (ex: MAC) or covers whole network (ex: default route of exit node)
+		}
+		conflicts, err := pool.Conflicts(main.Name, subnet)
+		if err != nil {
+			return fmt.Errorf("check subnet conflicts: %w", err)
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("subnet %s conflicts with hosts: %s", subnet, strings.Join(conflicts, ", "))
+		}
+	}
+
+	if err := cmd.applyRoutes(daemonConfig, main.Name); err != nil {
+		return fmt.Errorf("apply routes: %w", err)
+	}
 
 	// add to discovery information about self node
-	ssd.Replace(discovery.Entity{
-		Name:    main.Name,
-		Version: tick,
-	})
+	ssd.SetSelf(main.Name)
+	self.Name = main.Name
+	self.Version = ssd.Next() // after own record is known: clock follows it
+	self.Admission = cmd.selfAdmission(daemonConfig, main.Name, self.Admission, authorityKey, issuer)
+	self.Catalog, err = cmd.catalog()
+	if err != nil {
+		return fmt.Errorf("read services: %w", err)
+	}
+	if err := cmd.sign(daemonConfig, &self); err != nil {
+		log.Println("failed sign self host record (unsigned record will be distributed):", err)
+	}
+	ssd.Replace(self)
 
 	err = ssd.Save() // replace self discovery
 	if err != nil {
 		log.Println("save discovery meta config (fallback to in-memory only):", err)
 	}
 
+	// publish new version of self record after changes
+	publish := func(update func(self *discovery.Entity)) {
+		selfLock.Lock()
+		defer selfLock.Unlock()
+		update(&self)
+		self.Version = ssd.Next()
+		if err := cmd.sign(daemonConfig, &self); err != nil {
+			log.Println("failed sign self host record:", err)
+		}
+		ssd.Replace(self)
+		if err := ssd.Save(); err != nil {
+			log.Println("failed save discovery metadata:", err)
+		}
+		log.Println("published self record version", self.Version)
+	}
+	admitted = func(admission *authority.Admission) {
+		cmd.saveAdmission(admission)
+		publish(func(self *discovery.Entity) {
+			self.Admission = admission
+		})
+		log.Println("admission renewed till", admission.Expires.Format(time.RFC3339))
+	}
+
+	if bundle != nil {
+		if err := cmd.reverseBundle(daemonConfig, self); err != nil {
+			log.Println("failed create reverse bundle:", err)
+		}
+	}
+
 	// re-index config
+	retention := cmd.retention()
+	daemonConfig.ConnectFilter = retention.Alive(ssd, main.Name)
 	err = daemonConfig.IndexHosts()
 	if err != nil {
 		return fmt.Errorf("index hosts: %w", err)
 	}
 
+	var hostChanged []func(name string) // hooks after changes of host files
+	if cmd.HostsFile != "" {
+		block, rebuild := cmd.hostsBlock(daemonConfig)
+		hostChanged = append(hostChanged, rebuild)
+		defer func() {
+			if err := block.Remove(); err != nil {
+				log.Println("failed remove hosts block:", err)
+			}
+		}()
+	}
+
 	discoveryService := discovery.New(ssd, daemonConfig, cmd.DiscoveryInterval)
+	discoveryService.Client().RequireSignature = cmd.RequireSignatures
+	discoveryService.Client().Authority = authorityKey
+	discoveryService.Client().Admins = admins
+	discoveryService.Fanout = cmd.GossipFanout
+	discoveryService.RegistrySync = cmd.RegistryInterval
+	for _, location := range cmd.Registry {
+		registry, err := discovery.ParseRegistry(location)
+		if err != nil {
+			return fmt.Errorf("parse registry: %w", err)
+		}
+		discoveryService.Registries = append(discoveryService.Registries, registry)
+	}
+	discoveryService.Client().Self = main.Name
+	discoveryService.Client().Outdated = func() {
+		publish(func(*discovery.Entity) {})
+	}
+
+	resync := boot.NewResync(cmd.bootFile(), daemonConfig, token)
+	resync.Self = main.Name
+	resync.Interval = cmd.ResyncInterval
+	resync.Isolation = cmd.ResyncIsolation
+	resync.Prepare = prepareClient
+	if err := resync.Read(); err != nil {
+		log.Println("failed read known boot servers:", err)
+	}
+	if err := resync.Add(cmd.Join...); err != nil {
+		log.Println("failed save known boot servers:", err)
+	}
+	daemonConfig.Events().SubnetAdded.Subscribe(resync.SubnetAdded)
+	daemonConfig.Events().SubnetRemoved.Subscribe(resync.SubnetRemoved)
 
 	daemonConfig.Events().SubscribeAll(discoveryService)
 
+	if len(cmd.Routes) > 0 {
+		daemonConfig.Events().SubscribeAll(&gateway.Gateway{
+			Network:    pool.Network().String(),
+			Routes:     cmd.Routes,
+			Masquerade: cmd.Masquerade,
+		})
+	}
+
+	if cmd.UseExit != "" {
+		exit := gateway.NewExit(cmd.UseExit, daemonConfig)
+		exit.IPv6 = cmd.IP6 != ""
+		daemonConfig.Events().SubscribeAll(exit)
+		hostChanged = append(hostChanged, func(string) {
+			go exit.Refresh() // resolves addresses of peers
+		})
+	}
+
+	if cmd.DNS {
+		dnsServer := dns.New(cmd.dnsZone(), ssd, daemonConfig)
+		dnsServer.Upstream = cmd.DNSUpstream
+		daemonConfig.Events().SubscribeAll(dnsServer)
+		if cmd.DNSSplit {
+			cmd.splitDNS(daemonConfig, dnsServer.Zone())
+		}
+	}
+
+	daemonConfig.HostChanged = func(name string) {
+		for _, hook := range hostChanged {
+			hook(name)
+		}
+	}
+
 	instance, err := daemonConfig.Spawn(ctx)
 	if err != nil {
 		return fmt.Errorf("spawn daemon: %w", err)
@@ -214,37 +622,69 @@ func (cmd *Cmd) Execute([]string) error {
 	defer instance.Stop()
 
 	// setup boot/greeting service
-	if cmd.Token == "" {
-		cmd.Token = utils.RandStringRunes(64)
-	}
 	var proto = "http"
-	if cmd.TLS {
+	var fingerprint []byte
+	if cmd.tlsEnabled() {
 		proto = "https"
+		certFile, keyFile := cmd.certFiles()
+		if cmd.TLSAuto {
+			if err := boot.SelfSigned(certFile, keyFile, cmd.advertiseHosts()); err != nil {
+				return fmt.Errorf("generate self-signed certificate: %w", err)
+			}
+		}
+		fingerprint, err = boot.CertificateFingerprint(certFile, keyFile)
+		if err != nil {
+			log.Println("failed calculate TLS fingerprint:", err)
+		}
 	}
 	port := fmt.Sprint(cmd.Port) // TODO: replace to listener Listen and get real port
 	var lines = []string{
 		"Use one of this commands to join the network",
 		"",
 	}
-	for _, address := range cmd.advertise() {
-		lines = append(lines, os.Args[0]+" run -t "+cmd.Token+" --join "+proto+"://"+address+":"+port)
+	var inviteInfo = boot.Invite{
+		Token:       boot.Token(cmd.Token),
+		Fingerprint: fingerprint,
 	}
+	if !cmd.tlsEnabled() {
+		// plain HTTP: joining node verifies boot server by key of this node
+		if selfHost, err := daemonConfig.Host(main.Name); err != nil {
+			log.Println("failed read self host for invite:", err)
+		} else {
+			inviteInfo.Key, _ = hex.DecodeString(boot.KeyFingerprint(selfHost))
+		}
+	}
+	for _, address := range cmd.advertiseHosts() {
+		url := proto + "://" + net.JoinHostPort(address, port)
+		inviteInfo.URLs = append(inviteInfo.URLs, url)
+	}
+	for _, url := range inviteInfo.JoinURLs() {
+		lines = append(lines, os.Args[0]+" run -t "+cmd.Token+" --join '"+url+"'")
+	}
+	lines = append(lines, "", "or use invite (see also: "+os.Args[0]+" invite show --qr)", "", os.Args[0]+" run --join-invite "+inviteInfo.Encode())
 	fmt.Println(strings.Join(lines, "\n"))
+	if err := ioutil.WriteFile(cmd.inviteFile(), []byte(inviteInfo.Encode()), 0600); err != nil {
+		log.Println("failed save invite:", err)
+	}
 
-	token := boot.Token(cmd.Token)
 	// setup greeting clients
+	exchanged := func(url, name string) {
+		if ssd.ReplaceIfNewer(discovery.Entity{
+			Name: name,
+		}, nil) {
+			log.Println("got new node", name, "from", url)
+		}
+		if err := ssd.Save(); err != nil {
+			log.Println("failed save discovery metadata after exchange:", err)
+		}
+	}
 	var greetClients sync.WaitGroup
 	for _, url := range cmd.Join {
+		url := url
 		client := boot.NewClient(url, daemonConfig, token)
+		prepareClient(client)
 		client.Exchanged = func(name string) {
-			if ssd.ReplaceIfNewer(discovery.Entity{
-				Name: name,
-			}, nil) {
-				log.Println("got new node", name, "from", url)
-			}
-			if err := ssd.Save(); err != nil {
-				log.Println("failed save discovery metadata after exchange:", err)
-			}
+			exchanged(url, name)
 		}
 		client.Complete = func() {
 			instance.Reload()
@@ -256,14 +696,93 @@ func (cmd *Cmd) Execute([]string) error {
 		}(client)
 	}
 
+	if cmd.LANAnnounce {
+		go cmd.announceLAN(ctx, main.Name, pool.Network().String(), fingerprint)
+	}
+
+	go cmd.prune(ctx, daemonConfig, ssd, main.Name, retention, instance.Reload)
+
+	go cmd.watchFile(ctx, func() ([]byte, error) {
+		return daemonConfig.Host(main.Name)
+	}, func() {
+		log.Println("self host file changed")
+		publish(func(*discovery.Entity) {})
+		instance.Reload()
+	})
+	go cmd.watchFile(ctx, func() ([]byte, error) {
+		content, err := ioutil.ReadFile(cmd.servicesFile())
+		if os.IsNotExist(err) {
+			return nil, nil // services removed
+		}
+		return content, err
+	}, func() {
+		catalog, err := cmd.catalog()
+		if err != nil {
+			log.Println("failed read services:", err)
+			return
+		}
+		publish(func(self *discovery.Entity) {
+			self.Catalog = catalog
+		})
+	})
+
+	if cmd.API != "" {
+		go cmd.serveAPI(ctx, ssd, daemonConfig)
+	}
+
+	// keep in sync with boot servers after join
+	resync.Exchanged = exchanged
+	resync.Complete = instance.Reload
+
+	// apply removals from other nodes
+	discoveryService.Removed = func(name string) {
+		instance.Reload()
+	}
+	greetClients.Add(1)
+	go func() {
+		defer greetClients.Done()
+		resync.Run(ctx)
+	}()
+
 	// setup own greeting service
 	greetHandler := boot.NewServer(daemonConfig, token)
+	greetHandler.Pool = pool
+	greetHandler.Limiter = boot.NewLimiter(cmd.RateLimit, cmd.RateBurst, cmd.BanFailures, cmd.BanTime)
+	auditLog, err := boot.OpenAudit(cmd.auditFile())
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer auditLog.Close()
+	greetHandler.Audit = auditLog
+	greetHandler.SSD = ssd
+	greetHandler.Network = boot.Network{
+		CIDR: pool.Network().String(),
+	}
+	greetHandler.Authority = authorityKey
+	greetHandler.Issuer = issuer
+	greetHandler.AdmissionTTL = cmd.AdmissionTTL
+	if authorityKey != nil {
+		greetHandler.Network.Authority = authorityKey.String()
+	}
+	greetHandler.Network.Admin = network.Admin
+	greetHandler.Admins = admins
 	greetHandler.Joined = func(info boot.Envelope) {
+		if info.Agent != "" {
+			log.Println("node", info.Name, "joined (agent:", info.Agent, "role:", info.Role, "tags:", strings.Join(info.Tags, ","), ")")
+		}
 		// refresh discovery
-		if ssd.ReplaceIfNewer(discovery.Entity{
-			Name:    info.Name,
-			Version: 0,
-		}, nil) {
+		ssd.Touch(info.Name, time.Now())
+		entity, ok := ssd.Joined(info.Name, info.Version)
+		entity.Signature = info.Signature
+		entity.Admission = info.Admission
+		entity.Catalog = info.Catalog
+		if discovery.Verify(entity, info.Config, nil) != nil {
+			entity.Signature = nil // content re-allocated by server or version changed by re-admission
+		}
+		if err := discovery.VerifyCatalog(entity, info.Config, nil); err != nil && !errors.Is(err, discovery.ErrUnsigned) {
+			entity.Catalog = nil // version changed by re-admission
+		}
+		if ok && ssd.ReplaceIfNewer(entity, nil) {
 			instance.Reload()
 		}
 		if err := ssd.Save(); err != nil {
@@ -271,6 +790,13 @@ func (cmd *Cmd) Execute([]string) error {
 		}
 	}
 
+	greetHandler.Changed = func(entity discovery.Entity) {
+		if err := ssd.Save(); err != nil {
+			log.Println("failed save discovery metadata:", err)
+		}
+		instance.Reload()
+	}
+
 	greetServer := &http.Server{
 		Addr:    fmt.Sprint(cmd.Host, ":", cmd.Port),
 		Handler: greetHandler,
@@ -281,8 +807,19 @@ func (cmd *Cmd) Execute([]string) error {
 		_ = greetServer.Close()
 	}()
 
-	if cmd.TLS {
-		err = greetServer.ListenAndServeTLS(cmd.Cert, cmd.Key)
+	if cmd.TLSClientCA != "" {
+		clientCAs, err := readCertPool(cmd.TLSClientCA)
+		if err != nil {
+			return fmt.Errorf("read client CA: %w", err)
+		}
+		greetServer.TLSConfig = &tls.Config{
+			ClientCAs:  clientCAs,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+
+	if cmd.tlsEnabled() {
+		err = greetServer.ListenAndServeTLS(cmd.certFiles())
 	} else {
 		err = greetServer.ListenAndServe()
 	}
@@ -296,7 +833,110 @@ func (cmd *Cmd) Execute([]string) error {
 	return nil
 }
 
-func (cmd Cmd) createConfig(ctx context.Context, daemonConfig *daemon.Config) error {
+// sign own host record and services catalog by node key.
+func (cmd Cmd) sign(daemonConfig *daemon.Config, self *discovery.Entity) error {
+	self.Signature = nil
+	key, err := discovery.ReadPrivateKey(filepath.Join(cmd.configDir(), "rsa_key.priv"))
+	if err != nil {
+		return fmt.Errorf("read private key: %w", err)
+	}
+	content, err := daemonConfig.Host(self.Name)
+	if err != nil {
+		return fmt.Errorf("read self host: %w", err)
+	}
+	if self.Catalog != nil {
+		if self.Catalog, err = discovery.SignCatalog(key, self.Name, self.Version, self.Catalog.Services); err != nil {
+			return fmt.Errorf("sign services: %w", err)
+		}
+	}
+	self.Signature, err = discovery.Sign(key, self.Name, self.Version, content)
+	return err
+}
+
+// catalog of own services (nil if there are no services).
+func (cmd Cmd) catalog() (*discovery.Catalog, error) {
+	services, err := discovery.ReadServices(cmd.servicesFile())
+	if err != nil || len(services) == 0 {
+		return nil, err
+	}
+	return &discovery.Catalog{Services: services}, nil
+}
+
+// importBundle reads offline join bundle (if defined). Name and address of fresh node are taken from the bundle.
+func (cmd *Cmd) importBundle() (*boot.Bundle, error) {
+	if cmd.ImportBundle == "" {
+		return nil, nil
+	}
+	bundle, err := boot.ReadBundle(cmd.ImportBundle, boot.Token(cmd.Token))
+	if err != nil {
+		return nil, err
+	}
+	if cmd.Name == "" {
+		cmd.Name = bundle.Name
+	}
+	if cmd.IP == "" && bundle.Subnet != "" {
+		cmd.IP = strings.Split(bundle.Subnet, "/")[0]
+	}
+	return bundle, nil
+}
+
+// importBundleHosts saves host files from bundle which are newer than known.
+func (cmd Cmd) importBundleHosts(daemonConfig *daemon.Config, ssd *discovery.SSD, bundle *boot.Bundle, pub authority.PublicKey) {
+	for _, host := range bundle.Hosts {
+		if host.Name == cmd.Name || types.CleanString(host.Name) != host.Name {
+			continue
+		}
+		if pub != nil {
+			if err := pub.Verify(host.Admission, host.Name, host.Config, time.Now()); err != nil {
+				log.Println("rejected host", host.Name, "from bundle:", err)
+				continue
+			}
+		}
+		var err error
+		ssd.ReplaceIfNewer(discovery.Entity{
+			Name:      host.Name,
+			Version:   host.Version,
+			Signature: host.Signature,
+			Admission: host.Admission,
+			Catalog:   host.Catalog,
+		}, func() bool {
+			err = daemonConfig.AddHost(host.Name, host.Config)
+			return err == nil
+		})
+		if err != nil {
+			log.Println("failed import host", host.Name, "from bundle:", err)
+		}
+	}
+}
+
+// reverseBundle saves bundle with own host file to be imported by any member of the network.
+func (cmd Cmd) reverseBundle(daemonConfig *daemon.Config, self discovery.Entity) error {
+	content, err := daemonConfig.Host(self.Name)
+	if err != nil {
+		return fmt.Errorf("read self host: %w", err)
+	}
+	var reverse = boot.Bundle{
+		Name:    self.Name,
+		Server:  self.Name,
+		Hosts:   []boot.Host{{Name: self.Name, Version: self.Version, Config: content, Signature: self.Signature, Admission: self.Admission, Catalog: self.Catalog}},
+		Created: time.Now(),
+	}
+	if err := reverse.SaveFile(cmd.reverseBundleFile(), boot.Token(cmd.Token)); err != nil {
+		return err
+	}
+	fmt.Println("Copy", cmd.reverseBundleFile(), "to any member of the network and import it:")
+	fmt.Println()
+	fmt.Println("     ", os.Args[0], "bundle import", filepath.Base(cmd.reverseBundleFile()))
+	fmt.Println()
+	return nil
+}
+
+func (cmd Cmd) createConfig(ctx context.Context, daemonConfig *daemon.Config, pool *ipam.Pool, bundle *boot.Bundle) error {
+	ip, err := cmd.ip(pool)
+	if err != nil {
+		return fmt.Errorf("allocate address: %w", err)
+	}
+
 	var main = config.Main{
 		Name:           cmd.name(),
 		Port:           cmd.tincPort(),
@@ -309,8 +949,14 @@ func (cmd Cmd) createConfig(ctx context.Context, daemonConfig *daemon.Config) er
 
 	nodeFile := filepath.Join(cmd.hostsDir(), main.Name)
 
+	if bundle != nil && len(bundle.Keys) > 0 {
+		if host, ok := bundle.Host(main.Name); ok {
+			return cmd.useBundleKeys(nodeFile, main.Port, host.Config, bundle.Keys)
+		}
+	}
+
 	var node = config.Node{
-		Subnet:  cmd.ip() + "/32",
+		Subnet:  append([]string{ip + "/32"}, cmd.Routes...),
 		Address: cmd.advertise(),
 		Port:    main.Port,
 	}
@@ -325,22 +971,431 @@ func (cmd Cmd) createConfig(ctx context.Context, daemonConfig *daemon.Config) er
 	return nil
 }
 
-func (cmd Cmd) nextTick() (int64, error) {
-	data, err := ioutil.ReadFile(cmd.clockFile())
-	if os.IsNotExist(err) {
-		data = []byte("0")
-	} else if err != nil {
-		return 0, fmt.Errorf("read clock: %w", err)
+// useBundleKeys saves keys pre-generated by bundle exporter and host file with own addresses.
+func (cmd Cmd) useBundleKeys(nodeFile string, port uint16, hostContent []byte, keys map[string][]byte) error {
+	for file, content := range keys {
+		if filepath.Base(file) != file {
+			return fmt.Errorf("invalid key file name %s", file)
+		}
+		if err := ioutil.WriteFile(filepath.Join(cmd.configDir(), file), content, 0600); err != nil {
+			return fmt.Errorf("save key: %w", err)
+		}
+	}
+	head, err := config.Marshal(config.Node{
+		Address: cmd.advertise(),
+		Port:    port,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal node file: %w", err)
+	}
+	if err := ioutil.WriteFile(nodeFile, append(head, hostContent...), 0755); err != nil {
+		return fmt.Errorf("create node file: %w", err)
+	}
+	return nil
+}
+
+// allocateAddress asks boot servers one by one to allocate non-conflicting address for fresh node.
+// Locally allocated address will be used if all boot servers failed.
+func (cmd Cmd) allocateAddress(ctx context.Context, daemonConfig *daemon.Config, token boot.Token, prepare func(client *boot.Client)) {
+	for _, url := range cmd.Join {
+		client := boot.NewClient(url, daemonConfig, token)
+		prepare(client)
+		client.Allocate = true
+		if err := client.Exchange(ctx); err != nil {
+			log.Println("failed allocate address by", url, ":", err)
+			continue
+		}
+		return
+	}
+	log.Println("using locally allocated address")
+}
+
+// applyNetwork settings received from boot server. Mode and cipher changes will be applied after restart.
+func (cmd Cmd) applyNetwork(daemonConfig *daemon.Config, pool *ipam.Pool, server string, network boot.Network) {
+	if network.CIDR != "" && network.CIDR != pool.Network().String() {
+		if err := pool.SetNetwork(network.CIDR); err != nil {
+			log.Println("failed apply network CIDR from", server, ":", err)
+		} else {
+			log.Println("network CIDR", network.CIDR, "applied from", server)
+			cmd.updateNetwork(func(saved *boot.Network) {
+				saved.CIDR = network.CIDR
+			})
+		}
+	}
+	if network.Authority != "" {
+		cmd.updateNetwork(func(saved *boot.Network) {
+			if saved.Authority == "" {
+				log.Println("network authority", network.Authority, "applied from", server, "(restart required)")
+				saved.Authority = network.Authority
+			} else if saved.Authority != network.Authority {
+				log.Println("boot server", server, "reported another network authority", network.Authority, "- ignored")
+			}
+		})
+	}
+	if network.Admin != "" {
+		cmd.updateNetwork(func(saved *boot.Network) {
+			if saved.Admin == "" {
+				log.Println("network administrator", network.Admin, "applied from", server, "(restart required)")
+				saved.Admin = network.Admin
+			} else if saved.Admin != network.Admin {
+				log.Println("boot server", server, "reported another network administrator", network.Admin, "- ignored")
+			}
+		})
+	}
+	err := daemonConfig.UpdateMain(func(main *config.Main) {
+		if network.Mode != "" && network.Mode != main.Mode {
+			log.Println("network mode", network.Mode, "applied from", server, "(restart required)")
+			main.Mode = network.Mode
+		}
+		if network.Cipher != "" && network.Cipher != main.Cipher {
+			log.Println("network cipher", network.Cipher, "applied from", server, "(restart required)")
+			main.Cipher = network.Cipher
+		}
+	})
+	if err != nil {
+		log.Println("failed apply network settings from", server, ":", err)
+	}
+}
+
+// switchMode configures bridge and addressing of daemon in switch mode.
+func (cmd Cmd) switchMode(daemonConfig *daemon.Config, pool *ipam.Pool, mode string) {
+	if !strings.EqualFold(mode, config.ModeSwitch) {
+		if cmd.Bridge != "" || cmd.Addressing == "dhcp" {
+			log.Println("bridge and addressing are used only in switch mode - ignored")
+		}
+		return
+	}
+	daemonConfig.Bridge = cmd.Bridge
+	daemonConfig.Network = pool.Network().String()
+	if cmd.Addressing == "dhcp" {
+		daemonConfig.DHCPClient = strings.Fields(cmd.DHCPClient)
+	}
+	log.Println("switch mode, network", daemonConfig.Network, "bridge", cmd.Bridge, "addressing", cmd.Addressing)
+}
+
+// updateNetwork settings saved in work dir.
+func (cmd Cmd) updateNetwork(update func(saved *boot.Network)) {
+	saved, err := boot.ReadNetwork(cmd.networkFile())
+	if err != nil {
+		log.Println("failed read network settings:", err)
+		return
+	}
+	update(&saved)
+	if err := boot.SaveNetwork(cmd.networkFile(), saved); err != nil {
+		log.Println("failed save network settings:", err)
+	}
+}
+
+// authority returns public key of network authority (nil if not configured) and issuer if authority key
+// defined. Explicitly defined key is saved to network settings.
+func (cmd Cmd) authority(network *boot.Network) (authority.PublicKey, *authority.Authority, error) {
+	var (
+		pub    authority.PublicKey
+		issuer *authority.Authority
+		err    error
+	)
+	switch {
+	case cmd.AuthorityKey != "":
+		issuer, err = authority.ReadKey(cmd.AuthorityKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		pub = issuer.Public()
+	case cmd.Authority != "":
+		pub, err = authority.ParsePublicKey(cmd.Authority)
+	case network.Authority != "":
+		pub, err = authority.ParsePublicKey(network.Authority)
+	default:
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if network.Authority != pub.String() {
+		if network.Authority != "" {
+			log.Println("network authority changed from", network.Authority)
+		}
+		network.Authority = pub.String()
+		cmd.updateNetwork(func(saved *boot.Network) {
+			saved.Authority = network.Authority
+		})
+	}
+	log.Println("network authority", pub)
+	return pub, issuer, nil
+}
+
+// admins returns keys of network administrators: network authority and explicit or saved admin key.
+// Explicitly defined key is saved to network settings.
+func (cmd Cmd) admins(network *boot.Network, authorityKey authority.PublicKey) ([]authority.PublicKey, error) {
+	var ans []authority.PublicKey
+	if authorityKey != nil {
+		ans = append(ans, authorityKey)
+	}
+	key := network.Admin
+	if cmd.Admin != "" {
+		key = cmd.Admin
+	}
+	if key == "" {
+		return ans, nil
+	}
+	pub, err := authority.ParsePublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if network.Admin != pub.String() {
+		network.Admin = pub.String()
+		cmd.updateNetwork(func(saved *boot.Network) {
+			saved.Admin = network.Admin
+		})
 	}
+	log.Println("network administrator", pub)
+	return append(ans, pub), nil
+}
+
+// readAdmission of self node: offline issued or saved after join. Offline issued admission replaces saved one.
+func (cmd Cmd) readAdmission() *authority.Admission {
+	if cmd.Admission != "" {
+		admission, err := authority.ReadAdmission(cmd.Admission)
+		if err != nil {
+			log.Println("failed read admission:", err)
+		} else {
+			cmd.saveAdmission(admission)
+			return admission
+		}
+	}
+	admission, err := authority.ReadAdmission(cmd.admissionFile())
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("failed read saved admission:", err)
+	}
+	if err != nil {
+		return nil
+	}
+	return admission
+}
+
+func (cmd Cmd) saveAdmission(admission *authority.Admission) {
+	if err := admission.SaveFile(cmd.admissionFile()); err != nil {
+		log.Println("failed save admission:", err)
+	}
+}
 
-	tick, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+// selfAdmission checks (and issues by own authority key if needed) admission of self node.
+func (cmd Cmd) selfAdmission(daemonConfig *daemon.Config, name string, admission *authority.Admission, pub authority.PublicKey, issuer *authority.Authority) *authority.Admission {
+	if pub == nil {
+		return admission
+	}
+	content, err := daemonConfig.Host(name)
+	if err != nil {
+		log.Println("failed read self host:", err)
+		return admission
+	}
+	err = pub.Verify(admission, name, content, time.Now())
+	if err == nil && admission.ExpiresIn(time.Now()) > cmd.AdmissionTTL/2 {
+		return admission
+	}
+	if issuer == nil {
+		if err != nil {
+			log.Println("self node is not admitted (", err, ") - other nodes will refuse it until admission by boot server")
+		}
+		return admission
+	}
+	issued, err := issuer.Issue(name, content, cmd.AdmissionTTL)
 	if err != nil {
-		// broken clock
-		tick = 0
+		log.Println("failed issue self admission:", err)
+		return admission
+	}
+	cmd.saveAdmission(issued)
+	return issued
+}
+
+// watchFile calls changed each time when file content changed on disk (checked every discovery interval).
+func (cmd Cmd) watchFile(ctx context.Context, read func() ([]byte, error), changed func()) {
+	last, _ := read()
+	ticker := time.NewTicker(cmd.DiscoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		content, err := read()
+		if err != nil || bytes.Equal(content, last) {
+			continue
+		}
+		last = content
+		changed()
+	}
+}
+
+// serveAPI of local node till context canceled.
+func (cmd Cmd) serveAPI(ctx context.Context, ssd *discovery.SSD, daemonConfig *daemon.Config) {
+	mux := http.NewServeMux()
+	mux.Handle("/services", discovery.ServicesHandler(ssd, daemonConfig)) // -> [{"Node": "", "Address": "", "Name": "", "Port": 1234, ...}], ?name=&tag=&node=
+	server := &http.Server{
+		Addr:    cmd.API,
+		Handler: mux,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	log.Println("local API started on", cmd.API)
+	if err := server.ListenAndServe(); err != nil && ctx.Err() == nil {
+		log.Println("local API stopped:", err)
+	}
+}
+
+// browseLAN for greeting services till at least one found. Returns URLs of found services.
+func (cmd Cmd) browseLAN() ([]string, error) {
+	const timeout = 3 * time.Second
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Kill, os.Interrupt)
+	defer cancel()
+	for {
+		found, err := lan.Browse(ctx, cmd.LANNetwork, timeout)
+		if err != nil && ctx.Err() == nil {
+			log.Println("failed browse LAN:", err)
+		}
+		if len(found) > 0 {
+			var urls = make([]string, 0, len(found))
+			for _, ann := range found {
+				log.Println("found node", ann.Instance, "of network", ann.Network, "at", ann.URL)
+				urls = append(urls, ann.URL)
+			}
+			return urls, nil
+		}
+		log.Println("no greeting services found in LAN, retry after", cmd.JoinRetry)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(cmd.JoinRetry):
+		}
 	}
+}
 
-	tick++
-	return tick, ioutil.WriteFile(cmd.clockFile(), []byte(strconv.FormatInt(tick, 10)), 0755)
+// announceLAN greeting service by mDNS till context canceled.
+func (cmd Cmd) announceLAN(ctx context.Context, name, cidr string, fingerprint []byte) {
+	responder := &lan.Responder{
+		Instance:    name,
+		Network:     cmd.LANNetwork,
+		Port:        cmd.Port,
+		TLS:         cmd.tlsEnabled(),
+		Fingerprint: fingerprint,
+	}
+	if responder.Network == "" {
+		responder.Network = cidr
+	}
+	for _, host := range cmd.advertiseHosts() {
+		if ip := net.ParseIP(host); ip != nil {
+			responder.IPs = append(responder.IPs, ip)
+		}
+	}
+	log.Println("announcing greeting service in LAN as network", responder.Network)
+	if err := responder.Serve(ctx); err != nil {
+		log.Println("LAN announces stopped:", err)
+	}
+}
+
+func (cmd Cmd) retention() discovery.Retention {
+	return discovery.Retention{
+		Period:  cmd.Retention,
+		Mode:    cmd.RetentionMode,
+		Started: time.Now(),
+	}
+}
+
+// prune dead nodes by retention policy once per hour. Last seen times are saved at the same time.
+func (cmd Cmd) prune(ctx context.Context, daemonConfig *daemon.Config, ssd *discovery.SSD, self string, retention discovery.Retention, changed func()) {
+	const interval = time.Hour
+	if retention.Period <= 0 {
+		return
+	}
+	var known = make(map[string]bool)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		dead := retention.Dead(ssd, self, time.Now())
+		var names = make(map[string]bool, len(dead))
+		var fresh bool
+		for _, entity := range dead {
+			names[entity.Name] = true
+			fresh = fresh || !known[entity.Name]
+		}
+		if fresh || len(names) != len(known) {
+			log.Println("dead nodes:", len(dead))
+			if err := retention.Apply(daemonConfig, dead); err != nil {
+				log.Println("failed apply retention policy:", err)
+			}
+			changed()
+		}
+		known = names
+		if err := ssd.Save(); err != nil {
+			log.Println("failed save discovery metadata:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyRoutes to own host file: extra subnets are managed by flags only.
+func (cmd Cmd) applyRoutes(daemonConfig *daemon.Config, name string) error {
+	content, err := daemonConfig.Host(name)
+	if err != nil {
+		return fmt.Errorf("read self host: %w", err)
+	}
+	routes := cmd.Routes
+	if cmd.IP6 != "" {
+		routes = append([]string{cmd.IP6 + "/128"}, routes...) // own IPv6 address goes right after IPv4 one
+	}
+	updated := ipam.ReplaceRoutes(content, routes)
+	if bytes.Equal(content, updated) {
+		return nil
+	}
+	log.Println("advertised subnets changed:", strings.Join(routes, ", "))
+	return daemonConfig.AddHost(name, updated)
+}
+
+// hostsBlock of node names in hosts file. Returns rebuild function which should be called after changes of host files.
+func (cmd Cmd) hostsBlock(dc *daemon.Config) (*hostsfile.Block, func(name string)) {
+	block := &hostsfile.Block{
+		File:   cmd.HostsFile,
+		Marker: cmd.HostsDomain,
+	}
+	rebuild := func(string) {
+		entries, err := hostsfile.Entries(dc, cmd.HostsDomain)
+		if err == nil {
+			err = block.Write(entries)
+		}
+		if err != nil {
+			log.Println("failed update hosts file:", err)
+		}
+	}
+	rebuild("")
+	return block, rebuild
+}
+
+// splitDNS routes zone to own DNS server by systemd-resolved while interface is up.
+func (cmd Cmd) splitDNS(dc *daemon.Config, zone string) {
+	dc.Events().Configured.Subscribe(func(configuration daemon.Configuration) {
+		for _, args := range [][]string{
+			{"dns", configuration.Interface, configuration.IP},
+			{"domain", configuration.Interface, "~" + strings.TrimSuffix(zone, ".")},
+			{"default-route", configuration.Interface, "false"},
+		} {
+			if err := exec.Command("resolvectl", args...).Run(); err != nil {
+				log.Println("failed configure split DNS (resolvectl", args[0], "):", err)
+			}
+		}
+		log.Println("split DNS for", zone, "on", configuration.Interface)
+	})
+	dc.Events().Stopped.Subscribe(func(configuration daemon.Configuration) {
+		if err := exec.Command("resolvectl", "revert", configuration.Interface).Run(); err != nil {
+			log.Println("failed revert split DNS:", err)
+		}
+	})
 }
 
 func (cmd Cmd) automaticFirewall(ctx context.Context, dc *daemon.Config) {
@@ -360,9 +1415,38 @@ func (cmd Cmd) automaticFirewall(ctx context.Context, dc *daemon.Config) {
 		} else {
 			log.Println("opened discovery port", discovery.Port, "on", configuration.Interface)
 		}
+		if !cmd.DNS {
+			return
+		}
+		if err := exec.CommandContext(ctx, "ufw", "allow", "in", "on", configuration.Interface, "to", "any", "port", dns.Port).Run(); err != nil {
+			log.Println("failed allow internal ports for DNS:", err)
+		} else {
+			log.Println("opened DNS port", dns.Port, "on", configuration.Interface)
+		}
 	})
 }
 
+// advertiseHosts returns only hosts of advertised addresses (without tinc port).
+func (cmd Cmd) advertiseHosts() []string {
+	var ans []string
+	for _, address := range cmd.advertise() {
+		ans = append(ans, strings.Fields(address)[0])
+	}
+	return ans
+}
+
+func readCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", file)
+	}
+	return pool, nil
+}
+
 func getAllRoutableIPs() ([]string, error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
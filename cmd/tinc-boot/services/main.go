@@ -0,0 +1,87 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+type Cmd struct {
+	List ListCmd `command:"list" description:"List services of all known nodes"`
+}
+
+type ListCmd struct {
+	Dir  string `short:"d" long:"dir" env:"DIR" description:"tinc-boot directory" default:"vpn"`
+	API  string `long:"api" env:"API" description:"Local API of running node (ex: 127.0.0.1:18656). If not set - files of tinc-boot directory are used"`
+	Name string `short:"n" long:"name" env:"NAME" description:"Filter by service name"`
+	Tag  string `short:"t" long:"tag" env:"TAG" description:"Filter by service tag"`
+	Node string `long:"node" env:"NODE" description:"Filter by node name"`
+	JSON bool   `long:"json" env:"JSON" description:"Print services as JSON"`
+}
+
+func (cmd *ListCmd) Execute([]string) error {
+	filter := discovery.ServiceFilter{
+		Name: cmd.Name,
+		Tag:  cmd.Tag,
+		Node: cmd.Node,
+	}
+	var entries []discovery.ServiceEntry
+	var err error
+	if cmd.API != "" {
+		entries, err = cmd.fetch(filter)
+	} else {
+		entries, err = cmd.read(filter)
+	}
+	if err != nil {
+		return err
+	}
+	if cmd.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", " ")
+		return enc.Encode(entries)
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(out, "SERVICE\tNODE\tADDRESS\tPORT\tPROTOCOL\tTAGS")
+	for _, entry := range entries {
+		_, _ = fmt.Fprintf(out, "%s\t%s\t%s\t%d\t%s\t%s\n", entry.Name, entry.Node, entry.Address, entry.Port, entry.Protocol, strings.Join(entry.Tags, ","))
+	}
+	return out.Flush()
+}
+
+// read catalog from discovery state of node.
+func (cmd *ListCmd) read(filter discovery.ServiceFilter) ([]discovery.ServiceEntry, error) {
+	ssd := discovery.NewSSD(filepath.Join(cmd.Dir, "run", "discovery.json"))
+	if err := ssd.Read(); err != nil {
+		return nil, fmt.Errorf("read discovery: %w", err)
+	}
+	return discovery.Services(ssd, daemon.Default(filepath.Join(cmd.Dir, "config")), filter), nil
+}
+
+// fetch catalog from local API of running node.
+func (cmd *ListCmd) fetch(filter discovery.ServiceFilter) ([]discovery.ServiceEntry, error) {
+	query := url.Values{}
+	query.Set("name", filter.Name)
+	query.Set("tag", filter.Tag)
+	query.Set("node", filter.Node)
+	res, err := http.Get("http://" + cmd.API + "/services?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("request local API: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("local API returned unexpected status code %d", res.StatusCode)
+	}
+	var entries []discovery.ServiceEntry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decode services: %w", err)
+	}
+	return entries, nil
+}
@@ -7,6 +7,9 @@ require (
 	github.com/jessevdk/go-flags v1.4.1-0.20181221193153-c0795c8afcf4
 	github.com/phayes/permbits v0.0.0-20190612203442-39d7c581d2ee
 	github.com/reddec/struct-view v0.0.0-20191205120822-b0e32034c99a
-	github.com/stretchr/testify v1.7.0 // indirect
+	github.com/stretchr/testify v1.7.0
 	golang.org/x/crypto v0.0.0-20190911031432-227b76d455e7
+	golang.org/x/net v0.0.0-20191204025024-5ee1b9f4859a
+	gopkg.in/yaml.v2 v2.2.2
+	rsc.io/qr v0.2.0
 )
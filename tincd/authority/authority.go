@@ -0,0 +1,232 @@
+// Package authority implements optional network CA: offline signing key issues admission certificates
+// which bind node name, key fingerprint, allowed subnets and expiry. Nodes configured with authority
+// public key refuse host files without valid admission.
+package authority
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/config"
+	"github.com/reddec/tinc-boot/tincd/ipam"
+)
+
+// ErrNoAdmission for host file without admission certificate.
+var ErrNoAdmission = errors.New("no admission")
+
+// Admission certificate of node signed by network authority.
+type Admission struct {
+	Name        string
+	Fingerprint string   // fingerprint of node RSA key (see config.KeyFingerprint)
+	Subnets     []string // allowed subnets: every subnet of host file should be inside one of them
+	Expires     time.Time
+	Signature   []byte
+}
+
+// ExpiresIn returns time left before expiration.
+func (adm *Admission) ExpiresIn(now time.Time) time.Duration {
+	return adm.Expires.Sub(now)
+}
+
+func (adm *Admission) payload() []byte {
+	return []byte(strings.Join([]string{
+		adm.Name,
+		adm.Fingerprint,
+		strings.Join(adm.Subnets, ","),
+		strconv.FormatInt(adm.Expires.Unix(), 10),
+	}, "\n"))
+}
+
+// ReadAdmission from JSON file.
+func ReadAdmission(file string) (*Admission, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var adm Admission
+	return &adm, json.Unmarshal(data, &adm)
+}
+
+// SaveFile of admission as JSON.
+func (adm *Admission) SaveFile(file string) error {
+	data, err := json.MarshalIndent(adm, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// PublicKey of authority. Text form is base64 of raw key.
+type PublicKey ed25519.PublicKey
+
+// ParsePublicKey from text form.
+func ParsePublicKey(text string) (PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("decode authority key: %w", err)
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid authority key size %d", len(data))
+	}
+	return PublicKey(data), nil
+}
+
+func (pk PublicKey) String() string {
+	return base64.StdEncoding.EncodeToString(pk)
+}
+
+// Verify admission of host file at the moment.
+func (pk PublicKey) Verify(adm *Admission, name string, content []byte, now time.Time) error {
+	if adm == nil {
+		return ErrNoAdmission
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pk), adm.payload(), adm.Signature) {
+		return fmt.Errorf("invalid admission signature")
+	}
+	if adm.Name != name {
+		return fmt.Errorf("admission issued for %s", adm.Name)
+	}
+	if now.After(adm.Expires) {
+		return fmt.Errorf("admission expired at %s", adm.Expires.Format(time.RFC3339))
+	}
+	if fingerprint := config.KeyFingerprint(content); fingerprint == "" || fingerprint != adm.Fingerprint {
+		return fmt.Errorf("admission issued for another key")
+	}
+	allowed, err := parseSubnets(adm.Subnets)
+	if err != nil {
+		return err
+	}
+	for _, subnet := range ipam.HostSubnets(content) {
+		if !inside(allowed, subnet) {
+			return fmt.Errorf("subnet %s not allowed by admission", subnet)
+		}
+	}
+	return nil
+}
+
+// Signed payload by the key (see Authority.Sign).
+func (pk PublicKey) Signed(payload, signature []byte) bool {
+	return len(pk) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(pk), payload, signature)
+}
+
+// Authority signing key.
+type Authority struct {
+	key ed25519.PrivateKey
+}
+
+// Generate new authority key.
+func Generate() (*Authority, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{key: key}, nil
+}
+
+// ReadKey of authority from PEM (PKCS8) file.
+func ReadKey(file string) (*Authority, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse authority key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("authority key is not ed25519")
+	}
+	return &Authority{key: key}, nil
+}
+
+// SaveKey to PEM (PKCS8) file readable only by owner. Existent file is not overwritten.
+func (a *Authority) SaveKey(file string) error {
+	data, err := x509.MarshalPKCS8PrivateKey(a.key)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: data})
+}
+
+// Public key of authority.
+func (a *Authority) Public() PublicKey {
+	return PublicKey(a.key.Public().(ed25519.PublicKey))
+}
+
+// Sign arbitrary payload (ex: administrative decisions about nodes).
+func (a *Authority) Sign(payload []byte) []byte {
+	return ed25519.Sign(a.key, payload)
+}
+
+// Issue admission for host file: current key and subnets of host file are allowed.
+func (a *Authority) Issue(name string, content []byte, ttl time.Duration) (*Admission, error) {
+	var subnets []string
+	for _, subnet := range ipam.HostSubnets(content) {
+		subnets = append(subnets, subnet.String())
+	}
+	return a.IssueSubnets(name, content, subnets, ttl)
+}
+
+// IssueSubnets issues admission for current key of host file and only listed subnets.
+func (a *Authority) IssueSubnets(name string, content []byte, subnets []string, ttl time.Duration) (*Admission, error) {
+	fingerprint := config.KeyFingerprint(content)
+	if fingerprint == "" {
+		return nil, fmt.Errorf("no public key in host file")
+	}
+	if _, err := parseSubnets(subnets); err != nil {
+		return nil, err
+	}
+	var adm = Admission{
+		Name:        name,
+		Fingerprint: fingerprint,
+		Subnets:     subnets,
+		Expires:     time.Now().Add(ttl).Truncate(time.Second),
+	}
+	adm.Signature = ed25519.Sign(a.key, adm.payload())
+	return &adm, nil
+}
+
+func parseSubnets(subnets []string) ([]*net.IPNet, error) {
+	var ans = make([]*net.IPNet, 0, len(subnets))
+	for _, subnet := range subnets {
+		ipNet, err := ipam.ParseSubnet(subnet)
+		if err != nil {
+			return nil, err
+		}
+		ans = append(ans, ipNet)
+	}
+	return ans, nil
+}
+
+func inside(allowed []*net.IPNet, subnet *net.IPNet) bool {
+	ones, _ := subnet.Mask.Size()
+	for _, parent := range allowed {
+		parentOnes, _ := parent.Mask.Size()
+		if parent.Contains(subnet.IP) && parentOnes <= ones {
+			return true
+		}
+	}
+	return false
+}
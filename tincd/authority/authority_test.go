@@ -0,0 +1,57 @@
+package authority_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
+)
+
+func hostFile(t *testing.T, subnets string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	pub := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	return append([]byte(subnets), pub...)
+}
+
+func TestAuthority_Issue(t *testing.T) {
+	ca, err := authority.Generate()
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "authority.key")
+	require.NoError(t, ca.SaveKey(keyFile))
+	assert.Error(t, ca.SaveKey(keyFile), "key should not be overwritten")
+	ca, err = authority.ReadKey(keyFile)
+	require.NoError(t, err)
+
+	pub, err := authority.ParsePublicKey(ca.Public().String())
+	require.NoError(t, err)
+
+	content := hostFile(t, "Subnet = 10.0.0.1/32\n")
+	adm, err := ca.Issue("alpha", content, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1/32"}, adm.Subnets)
+
+	now := time.Now()
+	assert.NoError(t, pub.Verify(adm, "alpha", content, now))
+	assert.Equal(t, authority.ErrNoAdmission, pub.Verify(nil, "alpha", content, now))
+	assert.Error(t, pub.Verify(adm, "beta", content, now), "another name")
+	assert.Error(t, pub.Verify(adm, "alpha", content, now.Add(2*time.Hour)), "expired")
+	assert.Error(t, pub.Verify(adm, "alpha", hostFile(t, "Subnet = 10.0.0.1/32\n"), now), "another key")
+	assert.Error(t, pub.Verify(adm, "alpha", append([]byte("Subnet = 10.0.0.0/24\n"), content...), now), "wider subnet")
+
+	forged := *adm
+	forged.Subnets = []string{"10.0.0.0/8"}
+	assert.Error(t, pub.Verify(&forged, "alpha", content, now), "changed certificate")
+
+	other, err := authority.Generate()
+	require.NoError(t, err)
+	assert.Error(t, other.Public().Verify(adm, "alpha", content, now), "another authority")
+}
@@ -0,0 +1,169 @@
+package boot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/reddec/tinc-boot/types"
+)
+
+// AdminPath of greeting service for network management requests.
+const AdminPath = "/admin"
+
+// Administrative actions.
+const (
+	ActionRemove = "remove" // remove node from the network (tombstone)
+	ActionAdmit  = "admit"  // re-admit removed node
+)
+
+// AdminRequest to manage network. Transport is encrypted by token, but the decision should be signed by key of
+// network administrator: request without decision returns proposed one (not applied) to be signed.
+type AdminRequest struct {
+	Action   string
+	Name     string
+	Decision *discovery.Entity `json:",omitempty"` // signed decision (see discovery.SignDecision)
+}
+
+// Admin sends administrative request signed by administrator key to boot server and returns resulting discovery entity.
+func (cl *Client) Admin(ctx context.Context, action, name string, admin *authority.Authority) (*discovery.Entity, error) {
+	proposal, err := cl.admin(ctx, AdminRequest{Action: action, Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("propose: %w", err)
+	}
+	discovery.SignDecision(admin, proposal)
+	return cl.admin(ctx, AdminRequest{Action: action, Name: name, Decision: proposal})
+}
+
+func (cl *Client) admin(ctx context.Context, req AdminRequest) (*discovery.Entity, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cl.post(ctx, strings.TrimRight(cl.url, "/")+AdminPath, cl.token.Encrypt(data))
+	if err != nil {
+		return nil, err
+	}
+	var entity discovery.Entity
+	return &entity, json.Unmarshal(reply, &entity)
+}
+
+func (srv *Server) serveAdmin(writer http.ResponseWriter, request *http.Request, source string) {
+	const maxPayload = 4096
+	payload, err := io.ReadAll(io.LimitReader(request.Body, maxPayload))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	plain, err := srv.token.Decrypt(payload)
+	if err != nil {
+		srv.reject(source, err)
+		http.Error(writer, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if srv.Limiter != nil {
+		srv.Limiter.Succeeded(source)
+	}
+	var req AdminRequest
+	if err := json.Unmarshal(plain, &req); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	record := AuditRecord{Source: source, Name: req.Name}
+	if types.CleanString(req.Name) != req.Name || req.Name == "" {
+		srv.fail(writer, record, OutcomeInvalid, fmt.Errorf("invalid node name"), http.StatusUnprocessableEntity)
+		return
+	}
+	if srv.SSD == nil {
+		srv.fail(writer, record, OutcomeFailed, fmt.Errorf("discovery not configured"), http.StatusNotImplemented)
+		return
+	}
+
+	if len(srv.Admins) == 0 {
+		srv.fail(writer, record, OutcomeFailed, fmt.Errorf("no administrator keys configured"), http.StatusNotImplemented)
+		return
+	}
+
+	var entity discovery.Entity
+	switch req.Action {
+	case ActionRemove:
+		content, err := srv.config.Host(req.Name)
+		if err != nil {
+			srv.fail(writer, record, OutcomeFailed, fmt.Errorf("unknown node: %w", err), http.StatusNotFound)
+			return
+		}
+		record.Fingerprint = KeyFingerprint(content)
+		entity = srv.SSD.Tombstone(req.Name, record.Fingerprint)
+		record.Outcome = OutcomeRemoved
+	case ActionAdmit:
+		var ok bool
+		entity, ok = srv.SSD.Readmission(req.Name)
+		if !ok {
+			srv.fail(writer, record, OutcomeFailed, fmt.Errorf("node is not removed"), http.StatusConflict)
+			return
+		}
+		record.Outcome = OutcomeAdmitted
+	default:
+		srv.fail(writer, record, OutcomeInvalid, fmt.Errorf("unknown action %s", req.Action), http.StatusBadRequest)
+		return
+	}
+	if req.Decision == nil {
+		srv.replyEntity(writer, entity) // proposal to be signed
+		return
+	}
+	if !sameDecision(*req.Decision, entity) {
+		srv.fail(writer, record, OutcomeFailed, fmt.Errorf("decision is outdated"), http.StatusConflict)
+		return
+	}
+	if err := discovery.VerifyDecision(*req.Decision, srv.Admins); err != nil {
+		srv.reject(source, err)
+		srv.fail(writer, record, OutcomeRejected, err, http.StatusForbidden)
+		return
+	}
+	entity = *req.Decision
+	if entity.Removed {
+		if err := srv.config.RemoveHost(req.Name); err != nil {
+			srv.fail(writer, record, OutcomeFailed, err, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if !srv.SSD.ReplaceIfNewer(entity, nil) {
+		srv.fail(writer, record, OutcomeFailed, fmt.Errorf("decision is outdated"), http.StatusConflict)
+		return
+	}
+	log.Println("node", req.Name, record.Outcome, "by request from", source)
+	srv.audit(record)
+	srv.replyEntity(writer, entity)
+	if callback := srv.Changed; callback != nil {
+		callback(entity)
+	}
+}
+
+// replyEntity encrypted by token.
+func (srv *Server) replyEntity(writer http.ResponseWriter, entity discovery.Entity) {
+	data, err := json.Marshal(entity)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encrypted := srv.token.Encrypt(data)
+	writer.Header().Set("Content-Length", strconv.Itoa(len(encrypted)))
+	writer.WriteHeader(http.StatusOK)
+	_, _ = writer.Write(encrypted)
+}
+
+// sameDecision as proposed by server (signature is not checked).
+func sameDecision(decision, proposal discovery.Entity) bool {
+	return decision.Name == proposal.Name &&
+		decision.Version == proposal.Version &&
+		decision.Removed == proposal.Removed &&
+		decision.Key == proposal.Key &&
+		decision.Admitted == proposal.Admitted
+}
@@ -0,0 +1,101 @@
+package boot_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/boot"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+func TestServer_Admin(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := testNode(t, "alpha", "10.0.0.1/32")
+	require.NoError(t, serverConfig.AddHost("beta", []byte("Subnet = 10.0.0.2/32\n")))
+
+	admin, err := authority.Generate()
+	require.NoError(t, err)
+	intruder, err := authority.Generate()
+	require.NoError(t, err)
+
+	handler := boot.NewServer(serverConfig, token)
+	handler.SSD = discovery.NewSSD("")
+	handler.Admins = []authority.PublicKey{admin.Public()}
+	var changed []discovery.Entity
+	handler.Changed = func(entity discovery.Entity) {
+		changed = append(changed, entity)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	client := boot.NewClient(srv.URL, nil, token)
+	_, err = client.Admin(context.Background(), boot.ActionRemove, "alpha", admin)
+	assert.Error(t, err, "self node should not be removed")
+
+	_, err = client.Admin(context.Background(), boot.ActionRemove, "beta", intruder)
+	assert.Error(t, err, "token holder without administrator key should not remove nodes")
+	_, err = serverConfig.Host("beta")
+	assert.NoError(t, err)
+
+	entity, err := client.Admin(context.Background(), boot.ActionRemove, "beta", admin)
+	require.NoError(t, err)
+	assert.True(t, entity.Removed)
+	assert.NoError(t, discovery.VerifyDecision(*entity, handler.Admins), "tombstone should carry decision of administrator")
+	_, err = serverConfig.Host("beta")
+	assert.Error(t, err, "host file should be removed")
+	main, err := serverConfig.Main()
+	require.NoError(t, err)
+	assert.NotContains(t, main.ConnectTo, "beta")
+
+	// removed node can't join again
+	clientConfig := testNode(t, "beta", "10.0.0.2/32")
+	assert.Error(t, boot.NewClient(srv.URL, clientConfig, token).Exchange(context.Background()))
+
+	_, err = client.Admin(context.Background(), boot.ActionAdmit, "beta", intruder)
+	assert.Error(t, err)
+	entity, err = client.Admin(context.Background(), boot.ActionAdmit, "beta", admin)
+	require.NoError(t, err)
+	assert.True(t, entity.Admitted)
+	assert.NoError(t, boot.NewClient(srv.URL, clientConfig, token).Exchange(context.Background()))
+	assert.Len(t, changed, 2)
+}
+
+func TestServer_KeyRotation(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := testNode(t, "alpha", "10.0.0.1/32")
+	admin, err := authority.Generate()
+	require.NoError(t, err)
+	handler := boot.NewServer(serverConfig, token)
+	handler.SSD = discovery.NewSSD("")
+	handler.Admins = []authority.PublicKey{admin.Public()}
+	handler.Joined = func(info boot.Envelope) {
+		if entity, ok := handler.SSD.Joined(info.Name, info.Version); ok {
+			handler.SSD.Replace(entity)
+		}
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	require.NoError(t, boot.NewClient(srv.URL, keyedNode(t, "beta", "10.0.0.2/32"), token).Exchange(context.Background()))
+
+	// another key for known name is refused till removal and re-admission
+	rotated := keyedNode(t, "beta", "10.0.0.2/32")
+	rotatedHost, err := rotated.Host("beta")
+	require.NoError(t, err)
+	require.Error(t, boot.NewClient(srv.URL, rotated, token).Exchange(context.Background()))
+
+	client := boot.NewClient(srv.URL, nil, token)
+	_, err = client.Admin(context.Background(), boot.ActionRemove, "beta", admin)
+	require.NoError(t, err)
+	_, err = client.Admin(context.Background(), boot.ActionAdmit, "beta", admin)
+	require.NoError(t, err)
+	require.NoError(t, boot.NewClient(srv.URL, rotated, token).Exchange(context.Background()))
+	content, err := serverConfig.Host("beta")
+	require.NoError(t, err)
+	assert.Equal(t, boot.KeyFingerprint(rotatedHost), boot.KeyFingerprint(content))
+}
@@ -0,0 +1,93 @@
+package boot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/config"
+)
+
+// Outcomes of join attempts.
+const (
+	OutcomeJoined   = "joined"
+	OutcomeRejected = "rejected" // failed decryption (wrong token) or invalid signature of administrator
+	OutcomeBanned   = "banned"
+	OutcomeInvalid  = "invalid"
+	OutcomeConflict = "conflict"
+	OutcomeFailed   = "failed"   // internal error
+	OutcomeRefused  = "refused"  // node (name or key) removed from the network
+	OutcomeRemoved  = "removed"  // node removed by request
+	OutcomeAdmitted = "admitted" // removed node re-admitted by request
+)
+
+// AuditRecord of single join attempt.
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	Source      string    `json:"source"`
+	Name        string    `json:"name,omitempty"` // claimed name
+	Outcome     string    `json:"outcome"`
+	Fingerprint string    `json:"fingerprint,omitempty"` // SHA-256 of node public key
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// OpenAudit opens (creates if needed) append-only JSON-lines audit log.
+func OpenAudit(filename string) (*AuditLog, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	return &AuditLog{file: f}, nil
+}
+
+// AuditLog of join attempts. Go-routine safe.
+type AuditLog struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// Write record to log. Time will be set if not defined.
+func (al *AuditLog) Write(record AuditRecord) error {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	_, err = al.file.Write(data)
+	return err
+}
+
+// Close log file.
+func (al *AuditLog) Close() error {
+	return al.file.Close()
+}
+
+// ReadAudit scans JSON-lines audit log and calls handler for each record till handler returns false.
+// Malformed lines are skipped.
+func ReadAudit(reader io.Reader, handler func(record AuditRecord) bool) error {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if !handler(record) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// KeyFingerprint of RSA public key in host file (hex SHA-256 of DER). Empty if no key.
+func KeyFingerprint(hostContent []byte) string {
+	return config.KeyFingerprint(hostContent)
+}
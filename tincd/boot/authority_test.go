@@ -0,0 +1,124 @@
+package boot_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/boot"
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+func keyedNode(t *testing.T, name string, subnet string) *daemon.Config {
+	dc := testNode(t, name, subnet)
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	pub := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	require.NoError(t, dc.AddHost(name, append([]byte("Subnet = "+subnet+"\n"), pub...)))
+	return dc
+}
+
+func TestServer_Authority(t *testing.T) {
+	const token = boot.Token("secret")
+	ca, err := authority.Generate()
+	require.NoError(t, err)
+
+	issuerConfig := keyedNode(t, "alpha", "10.0.0.1/32")
+	issuer := boot.NewServer(issuerConfig, token)
+	issuer.SSD = discovery.NewSSD("")
+	issuerHost, err := issuerConfig.Host("alpha")
+	require.NoError(t, err)
+	issuerAdmission, err := ca.Issue("alpha", issuerHost, time.Hour)
+	require.NoError(t, err)
+	issuer.SSD.Replace(discovery.Entity{Name: "alpha", Version: 1, Admission: issuerAdmission})
+	issuer.Issuer = ca
+	issuer.AdmissionTTL = time.Hour
+	issuer.Joined = func(info boot.Envelope) {
+		issuer.SSD.Replace(discovery.Entity{Name: info.Name, Version: info.Version, Admission: info.Admission})
+	}
+	issuerSrv := httptest.NewServer(issuer)
+	defer issuerSrv.Close()
+
+	checker := boot.NewServer(keyedNode(t, "gamma", "10.0.0.3/32"), token)
+	checker.Authority = ca.Public()
+	checkerSrv := httptest.NewServer(checker)
+	defer checkerSrv.Close()
+
+	clientConfig := keyedNode(t, "beta", "10.0.0.2/32")
+
+	// no admission yet
+	client := boot.NewClient(checkerSrv.URL, clientConfig, token)
+	assert.Error(t, client.Exchange(context.Background()))
+
+	// admitted by issuer
+	var admission *authority.Admission
+	client = boot.NewClient(issuerSrv.URL, clientConfig, token)
+	client.Authority = ca.Public()
+	client.Admitted = func(adm *authority.Admission) {
+		admission = adm
+	}
+	require.NoError(t, client.Exchange(context.Background()))
+	require.NotNil(t, admission)
+	assert.Equal(t, "beta", admission.Name)
+	_, err = clientConfig.Host("alpha")
+	assert.NoError(t, err, "issuer host should be imported with admission")
+
+	// valid admission is accepted and not re-issued
+	admission = nil
+	require.NoError(t, client.Exchange(context.Background()))
+	assert.Nil(t, admission)
+
+	client = boot.NewClient(checkerSrv.URL, clientConfig, token)
+	client.Authority = ca.Public()
+	joined, _ := issuer.SSD.Get("beta")
+	client.Admission = joined.Admission
+	require.NoError(t, client.Exchange(context.Background()))
+	_, err = clientConfig.Host("gamma")
+	assert.Error(t, err, "host without admission should be refused")
+}
+
+func TestServer_AuthorityRoutes(t *testing.T) {
+	const token = boot.Token("secret")
+	ca, err := authority.Generate()
+	require.NoError(t, err)
+
+	issuer := boot.NewServer(keyedNode(t, "alpha", "10.0.0.1/32"), token)
+	issuer.Issuer = ca
+	issuer.AdmissionTTL = time.Hour
+	issuerSrv := httptest.NewServer(issuer)
+	defer issuerSrv.Close()
+
+	clientConfig := keyedNode(t, "beta", "10.0.0.2/32")
+	host, err := clientConfig.Host("beta")
+	require.NoError(t, err)
+	host = bytes.Replace(host, []byte("Subnet = 10.0.0.2/32\n"), []byte("Subnet = 10.0.0.2/32\nSubnet = 10.20.0.0/24\n"), 1)
+	require.NoError(t, clientConfig.AddHost("beta", host))
+
+	var admission *authority.Admission
+	client := boot.NewClient(issuerSrv.URL, clientConfig, token)
+	client.Authority = ca.Public()
+	client.Admitted = func(adm *authority.Admission) {
+		admission = adm
+	}
+	assert.Error(t, client.Exchange(context.Background()), "routes should not be admitted by boot server")
+	assert.Nil(t, admission)
+
+	// routes are admitted offline and renewed by boot server
+	client.Admission, err = ca.Issue("beta", host, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, client.Exchange(context.Background()))
+	require.NotNil(t, admission)
+	assert.Contains(t, admission.Subnets, "10.20.0.0/24")
+	assert.True(t, admission.ExpiresIn(time.Now()) > time.Minute)
+}
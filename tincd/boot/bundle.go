@@ -0,0 +1,80 @@
+package boot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Bundle for offline join: everything needed to join the network without access to boot servers.
+// Reverse bundle (created by joined node) carries only host file of the new node back to the network.
+//
+// Bundle is compressed JSON encrypted (and authenticated) by token.
+type Bundle struct {
+	Name    string            // node name bundle created for
+	Server  string            // name of node which created bundle
+	Subnet  string            `json:",omitempty"` // allocated subnet for the node
+	Network Network           // network-level settings
+	Hosts   []Host            // public host files. Host file of the node itself exists only with pre-generated keys
+	Keys    map[string][]byte `json:",omitempty"` // pre-generated private keys (file name -> content)
+	Created time.Time
+}
+
+// Host file of named node from bundle.
+func (b *Bundle) Host(name string) (Host, bool) {
+	for _, host := range b.Hosts {
+		if host.Name == name {
+			return host, true
+		}
+	}
+	return Host{}, false
+}
+
+// Seal bundle: compress and encrypt by token.
+func (b *Bundle) Seal(t Token) ([]byte, error) {
+	var buf bytes.Buffer
+	zip := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zip).Encode(b); err != nil {
+		return nil, fmt.Errorf("encode bundle: %w", err)
+	}
+	if err := zip.Close(); err != nil {
+		return nil, fmt.Errorf("compress bundle: %w", err)
+	}
+	return t.Encrypt(buf.Bytes()), nil
+}
+
+// Open sealed bundle.
+func (b *Bundle) Open(t Token, data []byte) error {
+	plain, err := t.Decrypt(data)
+	if err != nil {
+		return fmt.Errorf("decrypt bundle (invalid token?): %w", err)
+	}
+	zip, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return fmt.Errorf("decompress bundle: %w", err)
+	}
+	defer zip.Close()
+	return json.NewDecoder(zip).Decode(b)
+}
+
+// SaveFile with sealed bundle. Bundle may contain private keys so file is readable only by owner.
+func (b *Bundle) SaveFile(file string, t Token) error {
+	data, err := b.Seal(t)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// ReadBundle from file and open it.
+func ReadBundle(file string, t Token) (*Bundle, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read bundle: %w", err)
+	}
+	var b Bundle
+	return &b, b.Open(t, data)
+}
@@ -0,0 +1,33 @@
+package boot_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+)
+
+func TestBundle_SealOpen(t *testing.T) {
+	bundle := boot.Bundle{
+		Name:    "beta",
+		Server:  "alpha",
+		Subnet:  "10.0.0.2/32",
+		Network: boot.Network{CIDR: "10.0.0.0/24"},
+		Hosts:   []boot.Host{{Name: "alpha", Version: 3, Config: []byte("Subnet = 10.0.0.1/32\n")}},
+		Keys:    map[string][]byte{"rsa_key.priv": []byte("KEY")},
+	}
+	data, err := bundle.Seal("secret")
+	require.NoError(t, err)
+
+	var opened boot.Bundle
+	require.NoError(t, opened.Open("secret", data))
+	assert.Equal(t, bundle, opened)
+
+	host, ok := opened.Host("alpha")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), host.Version)
+
+	assert.Error(t, new(boot.Bundle).Open("wrong", data))
+}
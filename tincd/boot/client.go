@@ -3,32 +3,59 @@ package boot
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/config"
 	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/reddec/tinc-boot/tincd/ipam"
 	"github.com/reddec/tinc-boot/types"
 )
 
+// NewClient for boot server. URL may contain pinned certificate fingerprint: https://host:port#sha256=<hex>
+// or pinned fingerprint of boot node key for plain HTTP: http://host:port#key=<hex>.
 func NewClient(url string, config *daemon.Config, token Token) *Client {
+	_, key := SplitKey(url)
+	url, fingerprint := SplitFingerprint(url)
 	return &Client{
-		token:  token,
-		url:    url,
-		config: config,
+		token:       token,
+		url:         url,
+		fingerprint: fingerprint,
+		key:         key,
+		config:      config,
 	}
 }
 
 type Client struct {
-	Exchanged func(name string)
-	Complete  func()
-	token     Token
-	url       string
-	config    *daemon.Config
-	name      string
+	Exchanged    func(name string)
+	Complete     func()
+	Settings     func(server string, network Network) // hook for network settings from v2 boot server
+	Allocate     bool                                 // ask boot server to allocate non-conflicting address for the node
+	KeepExisting bool                                 // import only unknown hosts, existent host files are not touched
+	TLS          TLSOptions                           // custom CA and client certificates
+	SSD          *discovery.SSD                       // optional discovery metadata: only newer hosts are imported (v2)
+	Version      int64                                // discovery version of self host file
+	Role         string                               // requested role
+	Tags         []string                             // node tags
+	Signature    []byte                               // signature of self host file with Version (see discovery.Sign)
+	Catalog      *discovery.Catalog                   // signed services of self node (see discovery.SignCatalog)
+	Authority    authority.PublicKey                  // if set - only hosts with valid admission are imported
+	Admission    *authority.Admission                 // current admission of self node (authority mode)
+	Admitted     func(admission *authority.Admission) // hook for valid admission issued by boot server
+	token        Token
+	url          string
+	fingerprint  string
+	key          string // fingerprint of boot node key
+	config       *daemon.Config
+	name         string
 }
 
 func (cl *Client) Run(ctx context.Context, retry time.Duration) {
@@ -51,9 +78,12 @@ func (cl *Client) Run(ctx context.Context, retry time.Duration) {
 	}
 }
 
-func (cl *Client) exchange(ctx context.Context) error {
-	const timeout = 30 * time.Second
+// Exchange host files with boot server once.
+func (cl *Client) Exchange(ctx context.Context) error {
+	return cl.exchange(ctx)
+}
 
+func (cl *Client) exchange(ctx context.Context) error {
 	name, err := cl.readName()
 	if err != nil {
 		return fmt.Errorf("get self node name: %w", err)
@@ -65,64 +95,229 @@ func (cl *Client) exchange(ctx context.Context) error {
 	}
 
 	env := Envelope{
-		Name:   cl.name,
-		Config: selfContent,
+		Name:     cl.name,
+		Config:   selfContent,
+		Allocate: cl.Allocate,
+		Protocol: ProtocolV2,
+		Version:  cl.Version,
+		Agent:    AgentVersion,
+		Role:     cl.Role,
+		Tags:     cl.Tags,
+
+		Signature: cl.Signature,
+		Admission: cl.Admission,
+		Catalog:   cl.Catalog,
+	}
+
+	reply, err := cl.Send(ctx, env)
+	if err != nil {
+		return err
+	}
+	if err := cl.verifyServer(reply); err != nil {
+		return err
+	}
+
+	if callback := cl.Settings; callback != nil && reply.Protocol >= ProtocolV2 {
+		callback(reply.Name, reply.Network)
+	}
+
+	for _, host := range reply.Hosts {
+		if host.Name == name && reply.Admission != nil {
+			cl.admitted(host.Config, reply.Admission)
+		}
+		if types.CleanString(host.Name) != host.Name {
+			log.Println("malformed archive entry:", host.Name)
+			continue
+		}
+		imported, err := cl.importHost(host, selfContent, reply.Protocol >= ProtocolV2)
+		if err != nil {
+			return fmt.Errorf("import host %s: %w", host.Name, err)
+		}
+		if !imported {
+			continue
+		}
+		if callback := cl.Exchanged; callback != nil {
+			callback(host.Name)
+		}
 	}
+	return nil
+}
 
+// Send envelope to boot server and decode reply. Nothing is imported.
+func (cl *Client) Send(ctx context.Context, env Envelope) (*Reply, error) {
 	encrypted, err := env.Seal(cl.token)
 	if err != nil {
-		return fmt.Errorf("encrypt envelope: %w", err)
+		return nil, fmt.Errorf("encrypt envelope: %w", err)
+	}
+	archiveData, err := cl.post(ctx, cl.url, encrypted)
+	if err != nil {
+		return nil, err
 	}
+	return decodeReply(archiveData)
+}
+
+// post encrypted payload to boot server and returns decrypted response.
+func (cl *Client) post(ctx context.Context, url string, encrypted []byte) ([]byte, error) {
+	const timeout = 30 * time.Second
 
 	tctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(tctx, http.MethodPost, cl.url, bytes.NewReader(encrypted))
+	req, err := http.NewRequestWithContext(tctx, http.MethodPost, url, bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	client, err := cl.TLS.HTTPClient(cl.fingerprint)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return nil, fmt.Errorf("configure TLS: %w", err)
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("execute request: %w", err)
+		return nil, fmt.Errorf("execute request: %w", err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("unexpected status code: %d %s", res.StatusCode, strings.TrimSpace(string(message)))
 	}
 
 	encryptedArchive, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("read data: %w", err)
+		return nil, fmt.Errorf("read data: %w", err)
 	}
 
 	archiveData, err := cl.token.Decrypt(encryptedArchive)
 	if err != nil {
-		return fmt.Errorf("decrypt data: %w", err)
+		return nil, fmt.Errorf("decrypt data: %w", err)
 	}
+	return archiveData, nil
+}
 
-	var archive map[string][]byte
-	err = json.Unmarshal(archiveData, &archive)
-	if err != nil {
-		return fmt.Errorf("decode archive: %w", err)
+// verifyServer by pinned fingerprint of boot node key (if defined): host file of boot server in reply should
+// have the same key.
+func (cl *Client) verifyServer(reply *Reply) error {
+	if cl.key == "" {
+		return nil
 	}
-
-	for name, content := range archive {
-		if types.CleanString(name) != name {
-			log.Println("malformed archive entry:", name)
-			continue
+	for _, host := range reply.Hosts {
+		if host.Name == reply.Name && reply.Name != "" {
+			if KeyFingerprint(host.Config) != cl.key {
+				return fmt.Errorf("boot server %s key fingerprint mismatch", reply.Name)
+			}
+			return nil
 		}
+	}
+	return fmt.Errorf("boot server didn't provide own host file to verify key")
+}
 
-		err = cl.config.AddHost(name, content)
-		if err != nil {
-			return fmt.Errorf("import host %s: %w", name, err)
+// importHost saves host file. Own host file is changed only by allocated address (if requested).
+// For versioned (v2) replies only newer than known hosts are imported if SSD defined.
+func (cl *Client) importHost(host Host, selfContent []byte, versioned bool) (bool, error) {
+	if host.Name == cl.name {
+		if bytes.Equal(host.Config, selfContent) {
+			return false, nil
 		}
-		if callback := cl.Exchanged; callback != nil {
-			callback(name)
+		updated, ok := cl.allocated(selfContent, host.Config)
+		if !ok {
+			log.Println("ignoring self host file changed by boot server: only allocated address is accepted")
+			return false, nil
 		}
+		log.Println("boot server updated self host file (allocated address)")
+		return true, cl.config.AddHost(host.Name, updated)
+	}
+	if cl.Authority != nil {
+		if err := cl.Authority.Verify(host.Admission, host.Name, host.Config, time.Now()); err != nil {
+			log.Println("rejected host", host.Name, ":", err)
+			return false, nil
+		}
+	}
+	if cl.SSD != nil {
+		if cl.SSD.Removed(host.Name) {
+			return false, nil
+		}
+		if name, removed := cl.SSD.RemovedKey(KeyFingerprint(host.Config)); removed {
+			log.Println("ignoring node", host.Name, "with key of removed node", name)
+			return false, nil
+		}
+	}
+	if cl.KeepExisting && !versioned {
+		if _, err := cl.config.Host(host.Name); err == nil {
+			return false, nil
+		}
+	}
+	pinned, _ := cl.config.Host(host.Name)
+	if cl.SSD == nil || !versioned {
+		if key := KeyFingerprint(pinned); key != "" && key != KeyFingerprint(host.Config) {
+			log.Println("rejected host", host.Name, ": unsigned record changes pinned key")
+			return false, nil
+		}
+		return true, cl.config.AddHost(host.Name, host.Config)
+	}
+	entity := discovery.Entity{
+		Name:      host.Name,
+		Version:   host.Version,
+		Signature: host.Signature,
+		Admission: host.Admission,
+		Catalog:   host.Catalog,
+	}
+	if discovery.Ahead(entity.Version) {
+		log.Println("rejected record of", host.Name, ": version from the future", entity.Version)
+		return false, nil
+	}
+	if err := discovery.VerifyRecord(entity, host.Config, pinned, false); err != nil {
+		log.Println("rejected record:", err)
+		return false, nil
+	}
+	if err := discovery.VerifyCatalog(entity, host.Config, pinned); err != nil && !errors.Is(err, discovery.ErrUnsigned) {
+		log.Println("dropped services of", host.Name, ":", err)
+		entity.Catalog = nil
+	}
+	var err error
+	imported := cl.SSD.ReplaceIfNewer(entity, func() bool {
+		err = cl.config.AddHost(host.Name, host.Config)
+		return err == nil
+	})
+	return imported, err
+}
+
+// allocated applies address allocated by boot server to own host file. Returns false if allocation was not requested
+// or something else changed (key, routes, addresses).
+func (cl *Client) allocated(selfContent, content []byte) ([]byte, bool) {
+	subnet := ipam.HostSubnet(content)
+	if !cl.Allocate || subnet == nil {
+		return nil, false
+	}
+	var node config.Node
+	if err := config.Unmarshal(selfContent, &node); err != nil {
+		return nil, false
+	}
+	var updated []byte
+	if len(node.Subnet) == 0 {
+		updated = append([]byte("Subnet = "+subnet.String()+"\n"), selfContent...)
+	} else {
+		updated = ipam.ReplaceSubnet(selfContent, strings.TrimSpace(node.Subnet[0]), subnet.String())
+	}
+	return updated, bytes.Equal(updated, content)
+}
+
+// admitted checks admission issued for self node and passes it to the hook.
+func (cl *Client) admitted(content []byte, admission *authority.Admission) {
+	if cl.Admission != nil && bytes.Equal(cl.Admission.Signature, admission.Signature) {
+		return
+	}
+	if cl.Authority != nil {
+		if err := cl.Authority.Verify(admission, cl.name, content, time.Now()); err != nil {
+			log.Println("ignoring admission from boot server:", err)
+			return
+		}
+	}
+	cl.Admission = admission
+	if callback := cl.Admitted; callback != nil {
+		callback(admission)
 	}
-	return nil
 }
 
 func (cl *Client) readName() (string, error) {
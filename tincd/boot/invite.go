@@ -0,0 +1,215 @@
+package boot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	InviteScheme     = "tinc-boot://"
+	inviteVersion    = 1
+	inviteVersionKey = 2 // with fingerprint of boot node key
+	checksumSize     = 4
+)
+
+// Invite is a compact representation of everything needed to join the network:
+// boot URLs, token, optional fingerprint of boot server TLS certificate and optional fingerprint of boot node
+// key (SHA-256) which authenticates boot server over plain HTTP.
+//
+// Encoded form: tinc-boot://<base58(version | payload | checksum)>
+type Invite struct {
+	URLs        []string
+	Token       Token
+	Fingerprint []byte
+	Key         []byte
+}
+
+// String representation of invite. Same as Encode.
+func (inv Invite) String() string {
+	return inv.Encode()
+}
+
+// Encode invite as tinc-boot:// link.
+func (inv Invite) Encode() string {
+	var buf bytes.Buffer
+	if len(inv.Key) > 0 {
+		buf.WriteByte(inviteVersionKey)
+	} else {
+		buf.WriteByte(inviteVersion) // readable by old versions
+	}
+	writeChunk(&buf, []byte(inv.Token))
+	writeChunk(&buf, inv.Fingerprint)
+	writeUvarint(&buf, uint64(len(inv.URLs)))
+	for _, u := range inv.URLs {
+		writeChunk(&buf, []byte(u))
+	}
+	if len(inv.Key) > 0 {
+		writeChunk(&buf, inv.Key)
+	}
+	sum := checksum(buf.Bytes())
+	buf.Write(sum[:])
+	return InviteScheme + base58Encode(buf.Bytes())
+}
+
+// FingerprintHex returns fingerprint as hex string or empty string.
+func (inv Invite) FingerprintHex() string {
+	return hex.EncodeToString(inv.Fingerprint)
+}
+
+// KeyHex returns fingerprint of boot node key as hex string or empty string.
+func (inv Invite) KeyHex() string {
+	return hex.EncodeToString(inv.Key)
+}
+
+// JoinURLs returns boot URLs. If fingerprint defined, it will be attached to HTTPS URLs as #sha256=<hex>.
+// If key defined, it will be attached to HTTP URLs as #key=<hex>.
+func (inv Invite) JoinURLs() []string {
+	var ans = make([]string, 0, len(inv.URLs))
+	for _, u := range inv.URLs {
+		if len(inv.Fingerprint) > 0 && strings.HasPrefix(u, "https://") && !strings.Contains(u, "#") {
+			u += "#" + fingerprintPrefix + inv.FingerprintHex()
+		}
+		if len(inv.Key) > 0 && strings.HasPrefix(u, "http://") && !strings.Contains(u, "#") {
+			u += "#" + keyPrefix + inv.KeyHex()
+		}
+		ans = append(ans, u)
+	}
+	return ans
+}
+
+// ParseInvite decodes and validates invite link. Scheme prefix is optional.
+func ParseInvite(text string) (*Invite, error) {
+	text = strings.TrimPrefix(strings.TrimSpace(text), InviteScheme)
+	data, err := base58Decode(text)
+	if err != nil {
+		return nil, fmt.Errorf("decode invite: %w", err)
+	}
+	if len(data) < 1+checksumSize {
+		return nil, fmt.Errorf("invite is too short")
+	}
+	payload, sum := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	expected := checksum(payload)
+	if !bytes.Equal(sum, expected[:]) {
+		return nil, fmt.Errorf("invite checksum mismatch")
+	}
+	version := payload[0]
+	if version != inviteVersion && version != inviteVersionKey {
+		return nil, fmt.Errorf("unsupported invite version %d", version)
+	}
+	reader := bytes.NewReader(payload[1:])
+
+	var inv Invite
+	token, err := readChunk(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read token: %w", err)
+	}
+	inv.Token = Token(token)
+
+	inv.Fingerprint, err = readChunk(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read fingerprint: %w", err)
+	}
+	if len(inv.Fingerprint) == 0 {
+		inv.Fingerprint = nil
+	}
+
+	num, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read URLs count: %w", err)
+	}
+	for i := uint64(0); i < num; i++ {
+		u, err := readChunk(reader)
+		if err != nil {
+			return nil, fmt.Errorf("read URL #%d: %w", i, err)
+		}
+		inv.URLs = append(inv.URLs, string(u))
+	}
+	if version == inviteVersionKey {
+		inv.Key, err = readChunk(reader)
+		if err != nil {
+			return nil, fmt.Errorf("read key fingerprint: %w", err)
+		}
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("trailing data in invite")
+	}
+	return &inv, nil
+}
+
+func checksum(data []byte) [checksumSize]byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	var ans [checksumSize]byte
+	copy(ans[:], second[:])
+	return ans
+}
+
+func writeUvarint(buf *bytes.Buffer, value uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], value)
+	buf.Write(tmp[:n])
+}
+
+func writeChunk(buf *bytes.Buffer, data []byte) {
+	writeUvarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+func readChunk(reader *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(reader.Len()) {
+		return nil, fmt.Errorf("chunk is too big")
+	}
+	data := make([]byte, size)
+	_, err = reader.Read(data)
+	return data, err
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var radix = big.NewInt(58)
+
+func base58Encode(data []byte) string {
+	num := new(big.Int).SetBytes(data)
+	mod := new(big.Int)
+	var out []byte
+	for num.Sign() > 0 {
+		num.DivMod(num, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58Decode(text string) ([]byte, error) {
+	num := new(big.Int)
+	for _, r := range text {
+		idx := strings.IndexRune(base58Alphabet, r)
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid symbol %q", r)
+		}
+		num.Mul(num, radix)
+		num.Add(num, big.NewInt(int64(idx)))
+	}
+	var zeros int
+	for zeros < len(text) && text[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), num.Bytes()...), nil
+}
@@ -0,0 +1,39 @@
+package boot_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+)
+
+func TestInvite_EncodeParse(t *testing.T) {
+	invite := boot.Invite{
+		URLs:        []string{"http://10.0.0.1:8655", "https://example.com:8655"},
+		Token:       "hello world",
+		Fingerprint: []byte{0, 1, 2, 3},
+	}
+	encoded := invite.Encode()
+	assert.True(t, len(encoded) > len(boot.InviteScheme))
+
+	parsed, err := boot.ParseInvite(encoded)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, invite, *parsed)
+	assert.Equal(t, []string{"http://10.0.0.1:8655", "https://example.com:8655#sha256=00010203"}, parsed.JoinURLs())
+
+	invite.Key = []byte{4, 5, 6, 7}
+	parsed, err = boot.ParseInvite(invite.Encode())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, invite, *parsed)
+	assert.Equal(t, []string{"http://10.0.0.1:8655#key=04050607", "https://example.com:8655#sha256=00010203"}, parsed.JoinURLs())
+
+	broken := []byte(encoded)
+	broken[len(broken)-1] ^= 1
+	_, err = boot.ParseInvite(string(broken))
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package boot
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/reddec/tinc-boot/types"
+)
+
+// Legacy bootnode protocol (tinc-boot gen <-> tinc-boot bootnode):
+//
+//	POST /<hex nonce>/<name>
+//
+// Payload is host file sealed by token with node name as associated data. Reply is bootnode own host file
+// sealed with the same nonce and bootnode name (X-Node header) as associated data.
+
+// legacyPath extracts nonce and node name from legacy request path. Path may contain prefix.
+func legacyPath(path string) ([]byte, string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 {
+		return nil, "", false
+	}
+	hexNonce, name := parts[len(parts)-2], parts[len(parts)-1]
+	if len(hexNonce) != 2*chacha20poly1305.NonceSizeX {
+		return nil, "", false
+	}
+	nonce, err := hex.DecodeString(hexNonce)
+	if err != nil {
+		return nil, "", false
+	}
+	return nonce, name, true
+}
+
+func (srv *Server) serveLegacy(writer http.ResponseWriter, request *http.Request, source string, nonce []byte, name string) {
+	const maxPayload = 8192
+	payload, err := io.ReadAll(io.LimitReader(request.Body, maxPayload))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	crypter, err := srv.token.AEAD()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	content, err := crypter.Open(nil, nonce, payload, []byte(name))
+	if err != nil {
+		srv.reject(source, err)
+		http.Error(writer, "forbidden", http.StatusForbidden)
+		return
+	}
+	if srv.Limiter != nil {
+		srv.Limiter.Succeeded(source)
+	}
+
+	env := Envelope{
+		Name:   name,
+		Config: content,
+	}
+	record := AuditRecord{
+		Source:      source,
+		Name:        name,
+		Fingerprint: KeyFingerprint(content),
+	}
+	if types.CleanString(name) != name {
+		srv.fail(writer, record, OutcomeInvalid, fmt.Errorf("invalid node name"), http.StatusForbidden)
+		return
+	}
+	if !srv.register(writer, &env, record) {
+		return
+	}
+
+	main, err := srv.config.Main()
+	if err != nil {
+		srv.fail(writer, record, OutcomeFailed, err, http.StatusInternalServerError)
+		return
+	}
+	self, err := srv.config.Host(main.Name)
+	if err != nil {
+		srv.fail(writer, record, OutcomeFailed, err, http.StatusInternalServerError)
+		return
+	}
+	record.Outcome = OutcomeJoined
+	srv.audit(record)
+	log.Println("node", name, "joined by legacy protocol")
+
+	encrypted := crypter.Seal(nil, nonce, self, []byte(main.Name))
+	writer.Header().Set("X-Node", main.Name)
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(encrypted)))
+	writer.WriteHeader(http.StatusOK)
+	_, _ = writer.Write(encrypted)
+	if callback := srv.Joined; callback != nil {
+		callback(env)
+	}
+}
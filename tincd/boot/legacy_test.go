@@ -0,0 +1,52 @@
+package boot_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+)
+
+func TestServer_Legacy(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := testNode(t, "alpha", "10.0.0.1/32")
+	srv := httptest.NewServer(boot.NewServer(serverConfig, token))
+	defer srv.Close()
+
+	crypter, err := token.AEAD()
+	require.NoError(t, err)
+	var nonce [chacha20poly1305.NonceSizeX]byte
+	_, err = rand.Read(nonce[:])
+	require.NoError(t, err)
+
+	hostFile := []byte("Subnet = 10.0.0.2/32\n")
+	payload := crypter.Seal(nil, nonce[:], hostFile, []byte("beta"))
+
+	res, err := http.Post(srv.URL+"/"+hex.EncodeToString(nonce[:])+"/beta", "application/octet-stream", bytes.NewReader(payload))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "alpha", res.Header.Get("X-Node"))
+
+	encrypted, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	self, err := crypter.Open(nil, nonce[:], encrypted, []byte("alpha"))
+	require.NoError(t, err)
+	assert.Equal(t, mustHost(t, serverConfig, "alpha"), self)
+	assert.Equal(t, hostFile, mustHost(t, serverConfig, "beta"))
+
+	// wrong associated data
+	res, err = http.Post(srv.URL+"/"+hex.EncodeToString(nonce[:])+"/gamma", "application/octet-stream", bytes.NewReader(payload))
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
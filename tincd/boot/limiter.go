@@ -0,0 +1,114 @@
+package boot
+
+import (
+	"sync"
+	"time"
+)
+
+// NewLimiter creates per-source token-bucket limiter. Each source gets burst tokens which are restored
+// with rate per second. Source is banned for banTime after maxFailures failures in a row.
+func NewLimiter(rate float64, burst int, maxFailures int, banTime time.Duration) *Limiter {
+	return &Limiter{
+		rate:        rate,
+		burst:       float64(burst),
+		maxFailures: maxFailures,
+		banTime:     banTime,
+		sources:     make(map[string]*bucket),
+	}
+}
+
+// Limiter of requests by source. Go-routine safe.
+type Limiter struct {
+	rate        float64
+	burst       float64
+	maxFailures int
+	banTime     time.Duration
+	lock        sync.Mutex
+	sources     map[string]*bucket
+	lastCleanup time.Time
+}
+
+type bucket struct {
+	tokens      float64
+	updated     time.Time
+	failures    int
+	bannedUntil time.Time
+}
+
+// Banned checks that source is temporary banned.
+func (lim *Limiter) Banned(source string) bool {
+	lim.lock.Lock()
+	defer lim.lock.Unlock()
+	b, ok := lim.sources[source]
+	return ok && time.Now().Before(b.bannedUntil)
+}
+
+// Allow request from source and consume one token.
+func (lim *Limiter) Allow(source string) bool {
+	now := time.Now()
+	lim.lock.Lock()
+	defer lim.lock.Unlock()
+	lim.cleanup(now)
+	b := lim.get(source, now)
+	if now.Before(b.bannedUntil) {
+		return false
+	}
+	b.tokens += now.Sub(b.updated).Seconds() * lim.rate
+	if b.tokens > lim.burst {
+		b.tokens = lim.burst
+	}
+	b.updated = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Failed marks failed attempt (ex: decryption failure) from source. Returns true if source just got banned.
+func (lim *Limiter) Failed(source string) bool {
+	now := time.Now()
+	lim.lock.Lock()
+	defer lim.lock.Unlock()
+	b := lim.get(source, now)
+	b.failures++
+	if lim.maxFailures <= 0 || b.failures < lim.maxFailures {
+		return false
+	}
+	b.failures = 0
+	b.bannedUntil = now.Add(lim.banTime)
+	return true
+}
+
+// Succeeded resets failures counter for source.
+func (lim *Limiter) Succeeded(source string) {
+	lim.lock.Lock()
+	defer lim.lock.Unlock()
+	if b, ok := lim.sources[source]; ok {
+		b.failures = 0
+	}
+}
+
+func (lim *Limiter) get(source string, now time.Time) *bucket {
+	b, ok := lim.sources[source]
+	if !ok {
+		b = &bucket{tokens: lim.burst, updated: now}
+		lim.sources[source] = b
+	}
+	return b
+}
+
+// cleanup removes fully restored and not banned sources from time to time.
+func (lim *Limiter) cleanup(now time.Time) {
+	const cleanupInterval = time.Minute
+	if now.Sub(lim.lastCleanup) < cleanupInterval {
+		return
+	}
+	lim.lastCleanup = now
+	for source, b := range lim.sources {
+		restored := b.tokens+now.Sub(b.updated).Seconds()*lim.rate >= lim.burst
+		if restored && b.failures == 0 && now.After(b.bannedUntil) {
+			delete(lim.sources, source)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package boot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+)
+
+func TestLimiter(t *testing.T) {
+	lim := boot.NewLimiter(0, 2, 2, time.Hour)
+
+	assert.True(t, lim.Allow("a"))
+	assert.True(t, lim.Allow("a"))
+	assert.False(t, lim.Allow("a"), "burst exhausted")
+	assert.True(t, lim.Allow("b"), "sources are independent")
+
+	assert.False(t, lim.Failed("b"))
+	assert.True(t, lim.Failed("b"), "banned after second failure")
+	assert.True(t, lim.Banned("b"))
+	assert.False(t, lim.Allow("b"))
+}
@@ -0,0 +1,93 @@
+package boot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+// ProtocolV2 of boot exchange: structured metadata in request and reply.
+// Servers without v2 support ignore new envelope fields and reply with plain map of host files.
+const ProtocolV2 = 2
+
+// AgentVersion of tinc-boot reported during exchange.
+var AgentVersion = "dev"
+
+// Network-level settings shared by boot server.
+type Network struct {
+	CIDR      string `json:",omitempty"`
+	Mode      string `json:",omitempty"`
+	Cipher    string `json:",omitempty"`
+	Authority string `json:",omitempty"` // public key of network authority (see authority.PublicKey)
+	Admin     string `json:",omitempty"` // public key of network administrator (removals and re-admissions)
+}
+
+// ReadNetwork settings from JSON file. Missing file means empty settings.
+func ReadNetwork(file string) (Network, error) {
+	var network Network
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return network, nil
+	} else if err != nil {
+		return network, err
+	}
+	return network, json.Unmarshal(data, &network)
+}
+
+// SaveNetwork settings as JSON file.
+func SaveNetwork(file string, network Network) error {
+	data, err := json.MarshalIndent(network, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// Host file with discovery version.
+type Host struct {
+	Name      string
+	Version   int64
+	Config    []byte
+	Signature []byte               `json:",omitempty"` // signature of owner node (see discovery.Sign)
+	Admission *authority.Admission `json:",omitempty"` // admission certificate (authority mode)
+	Catalog   *discovery.Catalog   `json:",omitempty"` // services of node
+}
+
+// Reply of boot server for v2 protocol.
+type Reply struct {
+	Protocol int
+	Name     string // boot server node name
+	Agent    string // boot server tinc-boot version
+	Network  Network
+	Hosts    []Host
+
+	Admission *authority.Admission `json:",omitempty"` // admission issued for joined node
+}
+
+// decodeReply parses v2 reply or falls back to legacy map of host files (all versions are 0).
+func decodeReply(data []byte) (*Reply, error) {
+	var reply Reply
+	if err := json.Unmarshal(data, &reply); err == nil && reply.Protocol >= ProtocolV2 {
+		return &reply, nil
+	}
+	var archive map[string][]byte
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return nil, fmt.Errorf("decode archive: %w", err)
+	}
+	for name, content := range archive {
+		reply.Hosts = append(reply.Hosts, Host{
+			Name:   name,
+			Config: content,
+		})
+	}
+	sort.Slice(reply.Hosts, func(i, j int) bool {
+		return reply.Hosts[i].Name < reply.Hosts[j].Name
+	})
+	reply.Protocol = 1
+	return &reply, nil
+}
@@ -0,0 +1,139 @@
+package boot_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/reddec/tinc-boot/tincd/ipam"
+)
+
+func testNode(t *testing.T, name string, subnet string) *daemon.Config {
+	dir, err := ioutil.TempDir("", "tinc-boot-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "hosts"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tinc.conf"), []byte("Name = "+name+"\n"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "hosts", name), []byte("Subnet = "+subnet+"\n"), 0755))
+	return daemon.Default(dir)
+}
+
+func TestClient_ExchangeV2(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := testNode(t, "alpha", "10.0.0.1/32")
+	serverSSD := discovery.NewSSD("")
+	serverSSD.Replace(discovery.Entity{Name: "alpha", Version: 7})
+
+	pool, err := ipam.New("10.0.0.0/24", serverConfig)
+	require.NoError(t, err)
+
+	handler := boot.NewServer(serverConfig, token)
+	handler.SSD = serverSSD
+	handler.Pool = pool
+	handler.Network = boot.Network{CIDR: "10.0.0.0/24", Mode: "router"}
+	var joined boot.Envelope
+	handler.Joined = func(info boot.Envelope) {
+		joined = info
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// conflicting address should be re-allocated by server
+	clientConfig := testNode(t, "beta", "10.0.0.1/32")
+	clientSSD := discovery.NewSSD("")
+	var network boot.Network
+	client := boot.NewClient(srv.URL, clientConfig, token)
+	client.SSD = clientSSD
+	client.Version = 3
+	client.Allocate = true
+	client.Role = "member"
+	client.Settings = func(server string, settings boot.Network) {
+		assert.Equal(t, "alpha", server)
+		network = settings
+	}
+	require.NoError(t, client.Exchange(context.Background()))
+
+	assert.Equal(t, "beta", joined.Name)
+	assert.Equal(t, int64(3), joined.Version)
+	assert.Equal(t, "member", joined.Role)
+	assert.Equal(t, "10.0.0.0/24", network.CIDR)
+
+	info, ok := clientSSD.Get("alpha")
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), info.Version)
+
+	subnets := ipam.HostSubnets(mustHost(t, clientConfig, "beta"))
+	require.Len(t, subnets, 1)
+	assert.NotEqual(t, "10.0.0.1/32", subnets[0].String())
+	assert.Equal(t, mustHost(t, clientConfig, "beta"), mustHost(t, serverConfig, "beta"))
+}
+
+func mustHost(t *testing.T, config *daemon.Config, name string) []byte {
+	data, err := config.Host(name)
+	require.NoError(t, err)
+	return data
+}
+
+func TestClient_SelfChanges(t *testing.T) {
+	const token = boot.Token("secret")
+	clientConfig := keyedNode(t, "beta", "10.0.0.1/32")
+	original := mustHost(t, clientConfig, "beta")
+	var self []byte // returned by boot server as own host file
+	srv := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		data, err := json.Marshal(boot.Reply{
+			Protocol: boot.ProtocolV2,
+			Name:     "alpha",
+			Hosts:    []boot.Host{{Name: "beta", Config: self}},
+		})
+		require.NoError(t, err)
+		_, _ = writer.Write(token.Encrypt(data))
+	}))
+	defer srv.Close()
+
+	// boot server can't replace own key
+	self = mustHost(t, keyedNode(t, "beta", "10.0.0.7/32"), "beta")
+	client := boot.NewClient(srv.URL, clientConfig, token)
+	client.Allocate = true
+	require.NoError(t, client.Exchange(context.Background()))
+	assert.Equal(t, original, mustHost(t, clientConfig, "beta"))
+
+	// allocated address is not accepted without request
+	self = ipam.ReplaceSubnet(original, "10.0.0.1/32", "10.0.0.7/32")
+	client.Allocate = false
+	require.NoError(t, client.Exchange(context.Background()))
+	assert.Equal(t, original, mustHost(t, clientConfig, "beta"))
+
+	client.Allocate = true
+	require.NoError(t, client.Exchange(context.Background()))
+	assert.Equal(t, self, mustHost(t, clientConfig, "beta"))
+}
+
+func TestClient_PinnedServerKey(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := keyedNode(t, "alpha", "10.0.0.1/32")
+	srv := httptest.NewServer(boot.NewServer(serverConfig, token))
+	defer srv.Close()
+
+	clientConfig := testNode(t, "beta", "10.0.0.2/32")
+	wrong := boot.KeyFingerprint(mustHost(t, keyedNode(t, "alpha", "10.0.0.1/32"), "alpha"))
+	assert.Error(t, boot.NewClient(srv.URL+"#key="+wrong, clientConfig, token).Exchange(context.Background()), "server with another key")
+	_, err := clientConfig.Host("alpha")
+	assert.Error(t, err, "nothing imported from unverified server")
+
+	right := boot.KeyFingerprint(mustHost(t, serverConfig, "alpha"))
+	require.NoError(t, boot.NewClient(srv.URL+"#key="+right, clientConfig, token).Exchange(context.Background()))
+	assert.Equal(t, mustHost(t, serverConfig, "alpha"), mustHost(t, clientConfig, "alpha"))
+}
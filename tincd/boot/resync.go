@@ -0,0 +1,191 @@
+package boot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/daemon"
+)
+
+// NewResync creates periodic re-exchange with boot servers. List of boot URLs persisted in the file.
+func NewResync(file string, config *daemon.Config, token Token) *Resync {
+	return &Resync{
+		file:   file,
+		config: config,
+		token:  token,
+		peers:  make(map[string]bool),
+	}
+}
+
+// Resync keeps node in sync with boot servers after the first join: hosts which are not known locally
+// are imported periodically and each time when node has no reachable peers for a long time.
+type Resync struct {
+	Self      string               // name of own node: own subnets are not peers
+	Interval  time.Duration        // interval between periodic re-exchange (0 - disabled)
+	Isolation time.Duration        // re-exchange if there are no reachable peers during this time (0 - disabled)
+	Prepare   func(client *Client) // hook to configure clients before exchange
+	Exchanged func(url, name string)
+	Complete  func()
+
+	file     string
+	config   *daemon.Config
+	token    Token
+	lock     sync.Mutex
+	urls     []string
+	peers    map[string]bool // active subnets of other nodes
+	isolated time.Time       // time since when there is no reachable peers
+}
+
+// Read persisted list of boot URLs. Missed file is not an error.
+func (rs *Resync) Read() error {
+	data, err := ioutil.ReadFile(rs.file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read boot URLs: %w", err)
+	}
+	var urls []string
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return fmt.Errorf("decode boot URLs: %w", err)
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.urls = urls
+	return nil
+}
+
+// Add boot URLs (duplicates ignored) and persist list.
+func (rs *Resync) Add(urls ...string) error {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	var changed bool
+	for _, url := range urls {
+		if !contains(rs.urls, url) {
+			rs.urls = append(rs.urls, url)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(rs.urls, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(rs.file, data, 0600)
+}
+
+// URLs of known boot servers.
+func (rs *Resync) URLs() []string {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	var cp = make([]string, len(rs.urls))
+	copy(cp, rs.urls)
+	return cp
+}
+
+// SubnetAdded event handler for tracking reachable peers.
+func (rs *Resync) SubnetAdded(payload daemon.EventSubnetAdded) {
+	if payload.Peer.Node == rs.Self {
+		return
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.peers[payload.Peer.Subnet] = true
+}
+
+// SubnetRemoved event handler for tracking reachable peers.
+func (rs *Resync) SubnetRemoved(payload daemon.EventSubnetRemoved) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	delete(rs.peers, payload.Peer.Subnet)
+	if len(rs.peers) == 0 {
+		rs.isolated = time.Now()
+	}
+}
+
+// Run re-exchange loop till context done.
+func (rs *Resync) Run(ctx context.Context) {
+	rs.lock.Lock()
+	rs.isolated = time.Now()
+	rs.lock.Unlock()
+
+	var periodic <-chan time.Time
+	if rs.Interval > 0 {
+		ticker := time.NewTicker(rs.Interval)
+		defer ticker.Stop()
+		periodic = ticker.C
+	}
+	var check <-chan time.Time
+	if rs.Isolation > 0 {
+		ticker := time.NewTicker(rs.Isolation / 4)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-periodic:
+			rs.sync(ctx)
+		case <-check:
+			if rs.isolatedFor() >= rs.Isolation {
+				log.Println("no reachable peers for", rs.Isolation, "- re-exchanging with boot servers")
+				rs.sync(ctx)
+				rs.lock.Lock()
+				rs.isolated = time.Now() // wait for next full isolation period
+				rs.lock.Unlock()
+			}
+		}
+	}
+}
+
+func (rs *Resync) isolatedFor() time.Duration {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if len(rs.peers) > 0 {
+		return 0
+	}
+	return time.Since(rs.isolated)
+}
+
+func (rs *Resync) sync(ctx context.Context) {
+	var synced bool
+	for _, url := range rs.URLs() {
+		client := NewClient(url, rs.config, rs.token)
+		if prepare := rs.Prepare; prepare != nil {
+			prepare(client)
+		}
+		client.KeepExisting = true
+		if callback := rs.Exchanged; callback != nil {
+			u := url
+			client.Exchanged = func(name string) {
+				callback(u, name)
+			}
+		}
+		if err := client.Exchange(ctx); err != nil {
+			log.Println("failed re-exchange with", url, ":", err)
+			continue
+		}
+		synced = true
+	}
+	if callback := rs.Complete; synced && callback != nil {
+		callback()
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package boot_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingServer serves boot server and counts exchanges.
+func countingServer(t *testing.T, handler http.Handler) (*httptest.Server, *int32) {
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &count
+}
+
+func subnetAdded(node, subnet string) daemon.EventSubnetAdded {
+	var event daemon.EventSubnetAdded
+	event.Peer.Node = node
+	event.Peer.Subnet = subnet
+	return event
+}
+
+func subnetRemoved(node, subnet string) daemon.EventSubnetRemoved {
+	var event daemon.EventSubnetRemoved
+	event.Peer.Node = node
+	event.Peer.Subnet = subnet
+	return event
+}
+
+func runResync(t *testing.T, rs *boot.Resync) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rs.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestResync_URLs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinc-boot-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "boot.json")
+	config := testNode(t, "beta", "10.0.0.2/32")
+
+	rs := boot.NewResync(file, config, "secret")
+	require.NoError(t, rs.Read(), "missed file is not an error")
+	require.NoError(t, rs.Add("http://a", "http://b"))
+	require.NoError(t, rs.Add("http://b"))
+
+	restored := boot.NewResync(file, config, "secret")
+	require.NoError(t, restored.Read())
+	assert.Equal(t, []string{"http://a", "http://b"}, restored.URLs())
+}
+
+func TestResync_Isolation(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := testNode(t, "alpha", "10.0.0.1/32")
+	srv, count := countingServer(t, boot.NewServer(serverConfig, token))
+
+	clientConfig := testNode(t, "beta", "10.0.0.2/32")
+	rs := boot.NewResync(filepath.Join(clientConfig.ConfigDir, "boot.json"), clientConfig, token)
+	rs.Self = "beta"
+	rs.Isolation = 200 * time.Millisecond
+	require.NoError(t, rs.Add(srv.URL))
+	var completed int32
+	rs.Complete = func() {
+		atomic.AddInt32(&completed, 1)
+	}
+
+	// own subnet is not a reachable peer, another node - is
+	rs.SubnetAdded(subnetAdded("beta", "10.0.0.2/32"))
+	rs.SubnetAdded(subnetAdded("alpha", "10.0.0.1/32"))
+	runResync(t, rs)
+	time.Sleep(2 * rs.Isolation)
+	assert.Zero(t, atomic.LoadInt32(count), "node with reachable peers is not isolated")
+
+	// node which joined boot server later should be imported after isolation
+	require.NoError(t, serverConfig.AddHost("gamma", []byte("Subnet = 10.0.0.3/32\n")))
+	rs.SubnetRemoved(subnetRemoved("alpha", "10.0.0.1/32"))
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&completed) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []byte("Subnet = 10.0.0.3/32\n"), mustHost(t, clientConfig, "gamma"))
+	assert.Equal(t, mustHost(t, clientConfig, "beta"), mustHost(t, serverConfig, "beta"))
+}
+
+func TestResync_Periodic(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := testNode(t, "alpha", "10.0.0.1/32")
+	srv, count := countingServer(t, boot.NewServer(serverConfig, token))
+
+	clientConfig := testNode(t, "beta", "10.0.0.2/32")
+	rs := boot.NewResync(filepath.Join(clientConfig.ConfigDir, "boot.json"), clientConfig, token)
+	rs.Self = "beta"
+	rs.Interval = 50 * time.Millisecond
+	require.NoError(t, rs.Add(srv.URL))
+	rs.SubnetAdded(subnetAdded("alpha", "10.0.0.1/32"))
+	runResync(t, rs)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(count) >= 3
+	}, 5*time.Second, 10*time.Millisecond, "exchange should be repeated each interval even with reachable peers")
+}
+
+func TestResync_OnlyNewer(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := testNode(t, "alpha", "10.0.0.1/32")
+	serverSSD := discovery.NewSSD("")
+	serverSSD.Replace(discovery.Entity{Name: "alpha", Version: 1})
+	handler := boot.NewServer(serverConfig, token)
+	handler.SSD = serverSSD
+	srv, _ := countingServer(t, handler)
+
+	clientConfig := testNode(t, "beta", "10.0.0.2/32")
+	clientSSD := discovery.NewSSD("")
+	require.NoError(t, clientConfig.AddHost("gamma", []byte("Subnet = 10.0.0.3/32\n")))
+	clientSSD.Replace(discovery.Entity{Name: "gamma", Version: 5})
+
+	rs := boot.NewResync(filepath.Join(clientConfig.ConfigDir, "boot.json"), clientConfig, token)
+	rs.Self = "beta"
+	rs.Interval = 50 * time.Millisecond
+	rs.Prepare = func(client *boot.Client) {
+		client.SSD = clientSSD
+	}
+	var exchanged int32
+	rs.Complete = func() {
+		atomic.AddInt32(&exchanged, 1)
+	}
+	require.NoError(t, rs.Add(srv.URL))
+
+	// server knows older record than local - local host file is kept
+	require.NoError(t, serverConfig.AddHost("gamma", []byte("Subnet = 10.0.0.4/32\n")))
+	serverSSD.Replace(discovery.Entity{Name: "gamma", Version: 3})
+	runResync(t, rs)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&exchanged) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []byte("Subnet = 10.0.0.3/32\n"), mustHost(t, clientConfig, "gamma"))
+
+	// newer record replaces local one
+	serverSSD.Replace(discovery.Entity{Name: "gamma", Version: 7})
+	require.Eventually(t, func() bool {
+		info, _ := clientSSD.Get("gamma")
+		return info.Version == 7
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []byte("Subnet = 10.0.0.4/32\n"), mustHost(t, clientConfig, "gamma"))
+}
@@ -2,11 +2,20 @@ package boot
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/config"
 	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/reddec/tinc-boot/tincd/ipam"
 	"github.com/reddec/tinc-boot/types"
 )
 
@@ -18,7 +27,18 @@ func NewServer(config *daemon.Config, token Token) *Server {
 }
 
 type Server struct {
-	Joined func(info Envelope) // hook to handle arrived join request, executed after response
+	Joined  func(info Envelope)           // hook to handle arrived join request, executed after response
+	Pool    *ipam.Pool                    // optional address manager. If set, conflicting subnets are rejected or re-allocated
+	Limiter *Limiter                      // optional per-source rate limiter
+	Audit   *AuditLog                     // optional audit log of join attempts
+	SSD     *discovery.SSD                // optional discovery metadata to report hosts versions (v2)
+	Network Network                       // network-level settings reported to v2 clients
+	Changed func(entity discovery.Entity) // hook for nodes removed or re-admitted by admin request (requires SSD)
+
+	Authority    authority.PublicKey   // if set - joining nodes should present valid admission
+	Issuer       *authority.Authority  // if set - admissions are issued (and renewed) for joining nodes
+	AdmissionTTL time.Duration         // validity of issued admissions
+	Admins       []authority.PublicKey // keys of network administrators which sign removals and re-admissions
 
 	config *daemon.Config
 	token  Token
@@ -30,6 +50,22 @@ func (srv *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 	// output - JSON map of known hosts
 
 	defer request.Body.Close()
+	source := sourceIP(request)
+	if srv.Limiter != nil && !srv.Limiter.Allow(source) {
+		http.Error(writer, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	if request.URL.Path == AdminPath {
+		srv.serveAdmin(writer, request, source)
+		return
+	}
+
+	if nonce, name, ok := legacyPath(request.URL.Path); ok {
+		srv.serveLegacy(writer, request, source, nonce, name)
+		return
+	}
+
 	payload, err := io.ReadAll(io.LimitReader(request.Body, maxPayload))
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
@@ -41,32 +77,42 @@ func (srv *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 	err = env.Open(srv.token, payload)
 
 	if err != nil {
+		srv.reject(source, err)
 		http.Error(writer, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	if srv.Limiter != nil {
+		srv.Limiter.Succeeded(source)
+	}
+
+	record := AuditRecord{
+		Source:      source,
+		Name:        env.Name,
+		Fingerprint: KeyFingerprint(env.Config),
+	}
 
 	if types.CleanString(env.Name) != env.Name {
-		http.Error(writer, "invalid node name", http.StatusUnprocessableEntity)
+		srv.fail(writer, record, OutcomeInvalid, fmt.Errorf("invalid node name"), http.StatusUnprocessableEntity)
 		return
 	}
 
-	err = srv.config.AddHost(env.Name, env.Config)
-	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	if !srv.register(writer, &env, record) {
 		return
 	}
 
 	hosts, err := srv.config.Hosts()
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		srv.fail(writer, record, OutcomeFailed, err, http.StatusInternalServerError)
 		return
 	}
 
-	plainResponse, err := json.Marshal(hosts)
+	plainResponse, err := srv.reply(env, hosts)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		srv.fail(writer, record, OutcomeFailed, err, http.StatusInternalServerError)
 		return
 	}
+	record.Outcome = OutcomeJoined
+	srv.audit(record)
 
 	encryptedResponse := srv.token.Encrypt(plainResponse)
 	writer.Header().Set("Content-Length", strconv.Itoa(len(encryptedResponse)))
@@ -77,9 +123,245 @@ func (srv *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 	}
 }
 
+// register joining node: check (and allocate if requested) address and save host file.
+// Returns false if request failed and response already sent.
+func (srv *Server) register(writer http.ResponseWriter, env *Envelope, record AuditRecord) bool {
+	if srv.SSD != nil {
+		if srv.SSD.Removed(env.Name) {
+			srv.fail(writer, record, OutcomeRefused, fmt.Errorf("node removed from the network"), http.StatusGone)
+			return false
+		}
+		if name, removed := srv.SSD.RemovedKey(KeyFingerprint(env.Config)); removed {
+			srv.fail(writer, record, OutcomeRefused, fmt.Errorf("key of removed node %s", name), http.StatusGone)
+			return false
+		}
+	}
+	if discovery.Ahead(env.Version) {
+		srv.fail(writer, record, OutcomeInvalid, fmt.Errorf("version %d from the future", env.Version), http.StatusUnprocessableEntity)
+		return false
+	}
+	// known node can't be taken over by another key: token is shared by all nodes
+	pinned, err := srv.config.Host(env.Name)
+	if err != nil {
+		pinned = nil
+	}
+	if known := KeyFingerprint(pinned); known != "" && known != record.Fingerprint {
+		srv.fail(writer, record, OutcomeConflict, fmt.Errorf("node %s is registered with another key", env.Name), http.StatusConflict)
+		return false
+	}
+	if len(env.Signature) > 0 {
+		err := discovery.Verify(discovery.Entity{
+			Name:      env.Name,
+			Version:   env.Version,
+			Signature: env.Signature,
+		}, env.Config, pinned)
+		if err != nil {
+			srv.fail(writer, record, OutcomeInvalid, err, http.StatusUnprocessableEntity)
+			return false
+		}
+	}
+	if env.Catalog != nil && len(env.Catalog.Signature) > 0 {
+		err := discovery.VerifyCatalog(discovery.Entity{
+			Name:    env.Name,
+			Version: env.Version,
+			Catalog: env.Catalog,
+		}, env.Config, pinned)
+		if err != nil {
+			srv.fail(writer, record, OutcomeInvalid, err, http.StatusUnprocessableEntity)
+			return false
+		}
+	}
+	if srv.Pool != nil {
+		content, status, err := srv.allocate(*env)
+		if err != nil {
+			srv.fail(writer, record, OutcomeConflict, err, status)
+			return false
+		}
+		env.Config = content
+		defer srv.Pool.Release(env.Name)
+	}
+
+	if err := srv.admit(env); err != nil {
+		srv.fail(writer, record, OutcomeRefused, err, http.StatusForbidden)
+		return false
+	}
+
+	err = srv.config.AddHost(env.Name, env.Config)
+	if err != nil {
+		srv.fail(writer, record, OutcomeFailed, err, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// admit joining node in authority mode: issue new admission (renew if less than half of TTL left) or check presented one.
+func (srv *Server) admit(env *Envelope) error {
+	if srv.Issuer != nil {
+		now := time.Now()
+		public := srv.Issuer.Public()
+		var subnets []string
+		if own := ipam.HostSubnet(env.Config); own != nil {
+			subnets = append(subnets, own.String()) // checked (or allocated) by pool
+		}
+		if public.Verify(env.Admission, env.Name, env.Config, now) == nil {
+			if env.Admission.ExpiresIn(now) > srv.AdmissionTTL/2 {
+				return nil // valid and not close to expiration
+			}
+			subnets = append(subnets, env.Admission.Subnets...) // renew granted routes (issued offline)
+		}
+		admission, err := srv.Issuer.IssueSubnets(env.Name, env.Config, subnets, srv.AdmissionTTL)
+		if err != nil {
+			return fmt.Errorf("issue admission: %w", err)
+		}
+		if err := public.Verify(admission, env.Name, env.Config, now); err != nil {
+			return fmt.Errorf("%w: routes should be admitted by authority offline", err)
+		}
+		env.Admission = admission
+		return nil
+	}
+	if srv.Authority != nil {
+		return srv.Authority.Verify(env.Admission, env.Name, env.Config, time.Now())
+	}
+	return nil
+}
+
+// reject request with failed decryption and ban source after repeated failures.
+func (srv *Server) reject(source string, err error) {
+	srv.audit(AuditRecord{Source: source, Outcome: OutcomeRejected, Reason: err.Error()})
+	if srv.Limiter != nil && srv.Limiter.Failed(source) {
+		log.Println("source", source, "banned after repeated failures")
+		srv.audit(AuditRecord{Source: source, Outcome: OutcomeBanned})
+	}
+}
+
+// reply with plain map of host files for legacy clients or with structured v2 reply.
+func (srv *Server) reply(env Envelope, hosts map[string][]byte) ([]byte, error) {
+	if env.Protocol < ProtocolV2 {
+		return json.Marshal(hosts)
+	}
+	main, err := srv.config.Main()
+	if err != nil {
+		return nil, fmt.Errorf("read main config: %w", err)
+	}
+	network := srv.Network
+	if network.Mode == "" {
+		network.Mode = main.Mode
+	}
+	if network.Cipher == "" {
+		network.Cipher = main.Cipher
+	}
+	var reply = Reply{
+		Protocol: ProtocolV2,
+		Name:     main.Name,
+		Agent:    AgentVersion,
+		Network:  network,
+		Hosts:    make([]Host, 0, len(hosts)),
+
+		Admission: env.Admission,
+	}
+	for name, content := range hosts {
+		var info discovery.Entity
+		if srv.SSD != nil {
+			info, _ = srv.SSD.Get(name)
+		}
+		reply.Hosts = append(reply.Hosts, Host{
+			Name:      name,
+			Version:   info.Version,
+			Config:    content,
+			Signature: info.Signature,
+			Admission: info.Admission,
+			Catalog:   info.Catalog,
+		})
+	}
+	return json.Marshal(reply)
+}
+
+func (srv *Server) fail(writer http.ResponseWriter, record AuditRecord, outcome string, err error, status int) {
+	record.Outcome = outcome
+	record.Reason = err.Error()
+	srv.audit(record)
+	http.Error(writer, err.Error(), status)
+}
+
+func (srv *Server) audit(record AuditRecord) {
+	if srv.Audit == nil {
+		return
+	}
+	if err := srv.Audit.Write(record); err != nil {
+		log.Println("failed write audit log:", err)
+	}
+}
+
+func sourceIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// allocate checks subnet of joining node and returns host file content with non-conflicting subnet.
+// Without Allocate flag conflicts are not resolved and reported as an error.
+func (srv *Server) allocate(env Envelope) ([]byte, int, error) {
+	var node config.Node
+	if err := config.Unmarshal(env.Config, &node); err != nil {
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("parse host file: %w", err)
+	}
+	for _, route := range node.Routes() {
+		if _, err := ipam.ParseSubnet(route); err != nil || srv.Pool.Covers(route) {
+			continue // not IP (ex: MAC) or covers whole network (ex: default route of exit node)
+		}
+		conflicts, err := srv.Pool.Conflicts(env.Name, route)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if len(conflicts) > 0 {
+			return nil, http.StatusConflict, fmt.Errorf("route %s conflicts with %s", route, strings.Join(conflicts, ", "))
+		}
+	}
+	var subnet string
+	if len(node.Subnet) > 0 {
+		subnet = strings.TrimSpace(node.Subnet[0]) // own address, routes are kept as-is
+	}
+	if subnet != "" {
+		conflicts, err := srv.Pool.Conflicts(env.Name, subnet)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if len(conflicts) == 0 && (!env.Allocate || srv.Pool.Contains(subnet)) {
+			return env.Config, http.StatusOK, nil
+		}
+		if !env.Allocate {
+			return nil, http.StatusConflict, fmt.Errorf("subnet %s conflicts with %s", subnet, strings.Join(conflicts, ", "))
+		}
+	}
+	if !env.Allocate {
+		return env.Config, http.StatusOK, nil
+	}
+	ip, err := srv.Pool.Allocate(env.Name)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("allocate address: %w", err)
+	}
+	log.Println("allocated address", ip, "for", env.Name)
+	if subnet == "" {
+		return append([]byte("Subnet = "+ip.String()+"/32\n"), env.Config...), http.StatusOK, nil
+	}
+	return ipam.ReplaceSubnet(env.Config, subnet, ip.String()+"/32"), http.StatusOK, nil
+}
+
 type Envelope struct {
-	Name   string
-	Config []byte
+	Name     string
+	Config   []byte
+	Allocate bool     `json:",omitempty"` // ask server to allocate non-conflicting address (server may ignore it)
+	Protocol int      `json:",omitempty"` // protocol version, structured reply expected for 2+
+	Version  int64    `json:",omitempty"` // discovery version of host file
+	Agent    string   `json:",omitempty"` // tinc-boot version
+	Role     string   `json:",omitempty"` // requested role of node
+	Tags     []string `json:",omitempty"`
+
+	Signature []byte               `json:",omitempty"` // signature of host file by node key (see discovery.Sign)
+	Admission *authority.Admission `json:",omitempty"` // current admission for renewal or issued offline (authority mode)
+	Catalog   *discovery.Catalog   `json:",omitempty"` // signed services of node
 }
 
 func (env *Envelope) Seal(t Token) ([]byte, error) {
@@ -0,0 +1,53 @@
+package boot_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+	"github.com/reddec/tinc-boot/tincd/ipam"
+)
+
+func TestServer_PinnedKey(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := testNode(t, "alpha", "10.0.0.1/32")
+	srv := httptest.NewServer(boot.NewServer(serverConfig, token))
+	defer srv.Close()
+
+	owner := keyedNode(t, "beta", "10.0.0.2/32")
+	require.NoError(t, boot.NewClient(srv.URL, owner, token).Exchange(context.Background()))
+	registered, err := serverConfig.Host("beta")
+	require.NoError(t, err)
+
+	intruder := keyedNode(t, "beta", "10.0.0.2/32")
+	assert.Error(t, boot.NewClient(srv.URL, intruder, token).Exchange(context.Background()), "known name should not be taken by another key")
+	content, err := serverConfig.Host("beta")
+	require.NoError(t, err)
+	assert.Equal(t, registered, content)
+
+	assert.NoError(t, boot.NewClient(srv.URL, owner, token).Exchange(context.Background()), "owner should be able to re-join")
+}
+
+func TestServer_RouteConflicts(t *testing.T) {
+	const token = boot.Token("secret")
+	serverConfig := testNode(t, "alpha", "10.0.0.1/32")
+	pool, err := ipam.New("10.0.0.0/24", serverConfig)
+	require.NoError(t, err)
+	handler := boot.NewServer(serverConfig, token)
+	handler.Pool = pool
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	clientConfig := testNode(t, "beta", "10.0.0.2/32")
+	require.NoError(t, clientConfig.AddHost("beta", []byte("Subnet = 10.0.0.2/32\nSubnet = 10.0.0.1/32#1\n")))
+	assert.Error(t, boot.NewClient(srv.URL, clientConfig, token).Exchange(context.Background()), "route should not hijack address of other node")
+	_, err = serverConfig.Host("beta")
+	assert.Error(t, err)
+
+	require.NoError(t, clientConfig.AddHost("beta", []byte("Subnet = 10.0.0.2/32\nSubnet = 10.20.0.0/24\nSubnet = 0.0.0.0/0#10\n")))
+	assert.NoError(t, boot.NewClient(srv.URL, clientConfig, token).Exchange(context.Background()), "site-to-site and exit routes are allowed")
+}
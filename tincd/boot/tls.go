@@ -0,0 +1,176 @@
+package boot
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	fingerprintPrefix = "sha256="
+	keyPrefix         = "key="
+)
+
+// TLSOptions for boot client.
+type TLSOptions struct {
+	CA   string // optional path to PEM bundle of trusted CAs (system pool used if not set)
+	Cert string // optional path to client certificate
+	Key  string // optional path to client private key
+}
+
+// Config for TLS connection. If fingerprint (hex SHA-256 of server certificate) defined, chain verification is
+// replaced by pinning.
+func (opts TLSOptions) Config(fingerprint string) (*tls.Config, error) {
+	var cfg tls.Config
+	if opts.CA != "" {
+		data, err := ioutil.ReadFile(opts.CA)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s", opts.CA)
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.Cert != "" || opts.Key != "" {
+		cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if fingerprint != "" {
+		expected, err := hex.DecodeString(fingerprint)
+		if err != nil || len(expected) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint %s", fingerprint)
+		}
+		cfg.InsecureSkipVerify = true // verified by pinning
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("no server certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if subtle.ConstantTimeCompare(sum[:], expected) != 1 {
+				return fmt.Errorf("server certificate fingerprint mismatch")
+			}
+			return nil
+		}
+	}
+	return &cfg, nil
+}
+
+// HTTPClient with configured TLS.
+func (opts TLSOptions) HTTPClient(fingerprint string) (*http.Client, error) {
+	if opts == (TLSOptions{}) && fingerprint == "" {
+		return http.DefaultClient, nil
+	}
+	cfg, err := opts.Config(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	return &http.Client{Transport: transport}, nil
+}
+
+// SplitFingerprint separates URL and pinned fingerprint defined as fragment (https://host:port#sha256=<hex>).
+func SplitFingerprint(url string) (string, string) {
+	return splitFragment(url, fingerprintPrefix)
+}
+
+// SplitKey separates URL and pinned fingerprint of boot node key defined as fragment (http://host:port#key=<hex>).
+func SplitKey(url string) (string, string) {
+	return splitFragment(url, keyPrefix)
+}
+
+func splitFragment(url string, prefix string) (string, string) {
+	idx := strings.Index(url, "#")
+	if idx < 0 {
+		return url, ""
+	}
+	fragment := url[idx+1:]
+	if !strings.HasPrefix(fragment, prefix) {
+		return url[:idx], ""
+	}
+	return url[:idx], strings.ToLower(fragment[len(prefix):])
+}
+
+// CertificateFingerprint returns SHA-256 of leaf certificate.
+func CertificateFingerprint(certFile, keyFile string) ([]byte, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load key pair: %w", err)
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return sum[:], nil
+}
+
+// SelfSigned generates and saves self-signed certificate and key if any of files not exists.
+// Hosts (IPs or domains) are added to certificate as alternative names.
+func SelfSigned(certFile, keyFile string, hosts []string) error {
+	if fileExists(certFile) && fileExists(keyFile) {
+		return nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("generate serial: %w", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "tinc-boot"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("create certificate: %w", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("marshal key: %w", err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return fmt.Errorf("save key: %w", err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return fmt.Errorf("save certificate: %w", err)
+	}
+	return nil
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
@@ -0,0 +1,42 @@
+package boot_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/reddec/tinc-boot/tincd/boot"
+)
+
+func TestTLSOptions_Pinning(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	url, fingerprint := boot.SplitFingerprint(srv.URL + "#sha256=" + hex.EncodeToString(sum[:]))
+	assert.Equal(t, srv.URL, url)
+
+	client, err := boot.TLSOptions{}.HTTPClient(fingerprint)
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := client.Get(url)
+	if assert.NoError(t, err) {
+		_ = res.Body.Close()
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	}
+
+	sum[0] ^= 0xFF
+	client, err = boot.TLSOptions{}.HTTPClient(hex.EncodeToString(sum[:]))
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = client.Get(url)
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package boot
 
 import (
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
@@ -11,9 +12,14 @@ import (
 
 type Token string
 
-func (t Token) Encrypt(data []byte) []byte {
+// AEAD cipher (XChaCha20-Poly1305) with key derived from token.
+func (t Token) AEAD() (cipher.AEAD, error) {
 	tokenData := sha256.Sum256([]byte(t)) // normalize to 32 bytes
-	crypter, err := chacha20poly1305.NewX(tokenData[:])
+	return chacha20poly1305.NewX(tokenData[:])
+}
+
+func (t Token) Encrypt(data []byte) []byte {
+	crypter, err := t.AEAD()
 	if err != nil {
 		panic(err)
 	}
@@ -33,8 +39,7 @@ func (t Token) Encrypt(data []byte) []byte {
 }
 
 func (t Token) Decrypt(data []byte) ([]byte, error) {
-	tokenData := sha256.Sum256([]byte(t))
-	crypter, err := chacha20poly1305.NewX(tokenData[:])
+	crypter, err := t.AEAD()
 	if err != nil {
 		return nil, err
 	}
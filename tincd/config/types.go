@@ -1,9 +1,19 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+)
+
+// Modes of tinc daemon (Mode in tinc.conf).
+const (
+	ModeRouter = "router" // IP subnets, tun device (default)
+	ModeSwitch = "switch" // layer 2 by learned MAC addresses, tap device
 )
 
 type Main struct {
@@ -11,16 +21,34 @@ type Main struct {
 	Port           uint16
 	LocalDiscovery bool
 	Interface      string
+	Mode           string
+	Cipher         string
 	ConnectTo      []string
 }
 
 type Node struct {
-	Subnet    string
+	Subnet    []string // the first one is address of node, others are routes advertised by node
 	Address   []string
 	Port      uint16
 	PublicKey string `tinc:"RSA PUBLIC KEY"`
 }
 
+// IP of node from the first subnet (without prefix length and weight).
+func (node Node) IP() string {
+	if len(node.Subnet) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(strings.Split(strings.Split(node.Subnet[0], "#")[0], "/")[0])
+}
+
+// Routes advertised by node: all subnets except own address.
+func (node Node) Routes() []string {
+	if len(node.Subnet) <= 1 {
+		return nil
+	}
+	return node.Subnet[1:]
+}
+
 func SaveFile(file string, content interface{}) error {
 	f, err := os.Create(file)
 	if err != nil {
@@ -58,3 +86,17 @@ func ReadNodeConfig(configDir string) (*Main, *Node, error) {
 	}
 	return &main, &node, nil
 }
+
+// KeyFingerprint of RSA public key in host file (hex SHA-256 of DER). Empty if no key.
+func KeyFingerprint(hostContent []byte) string {
+	var node Node
+	if err := Unmarshal(hostContent, &node); err != nil || node.PublicKey == "" {
+		return ""
+	}
+	block, _ := pem.Decode([]byte(node.PublicKey))
+	if block == nil {
+		return ""
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:])
+}
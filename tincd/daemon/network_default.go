@@ -0,0 +1,29 @@
+//+build !linux
+
+package daemon
+
+import "fmt"
+
+func setAddress(interfaceName string, address string) error {
+	return fmt.Errorf("not implemented on the platform")
+}
+
+func removeAddress(interfaceName string, address string) error {
+	return fmt.Errorf("not implemented on the platform")
+}
+
+func setMaster(interfaceName string, bridge string) error {
+	return fmt.Errorf("not implemented on the platform")
+}
+
+func enableInterface(interfaceName string) error {
+	return fmt.Errorf("not implemented on the platform")
+}
+
+func setRouting(interfaceName string, subnet string) error {
+	return fmt.Errorf("not implemented on the platform")
+}
+
+func removeRouting(interfaceName string, subnet string) error {
+	return fmt.Errorf("not implemented on the platform")
+}
@@ -2,8 +2,16 @@ package daemon
 
 import "os/exec"
 
-func setAddress(interfaceName string, ip string) error {
-	return exec.Command("ip", "addr", "add", ip+"/32", "dev", interfaceName).Run()
+func setAddress(interfaceName string, address string) error {
+	return exec.Command("ip", "addr", "add", address, "dev", interfaceName).Run()
+}
+
+func removeAddress(interfaceName string, address string) error {
+	return exec.Command("ip", "addr", "del", address, "dev", interfaceName).Run()
+}
+
+func setMaster(interfaceName string, bridge string) error {
+	return exec.Command("ip", "link", "set", "dev", interfaceName, "master", bridge).Run()
 }
 
 func enableInterface(interfaceName string) error {
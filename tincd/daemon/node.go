@@ -7,6 +7,8 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
@@ -36,7 +38,13 @@ type Config struct {
 	Args            []string // additional tincd arguments
 	PidFile         string
 	ConfigDir       string
-	RestartInterval time.Duration // interval between restart
+	RestartInterval time.Duration          // interval between restart
+	ConnectFilter   func(name string) bool // if set - only accepted hosts are added to ConnectTo by IndexHosts
+	HostChanged     func(name string)      // hook called after host file added, removed or archived (lock released)
+	Bridge          string                 // switch mode: Linux bridge for tap device (addresses are assigned to the bridge)
+	Network         string                 // switch mode: overlay network CIDR, defines prefix length of own address
+	DHCPClient      []string               // switch mode: DHCP client command (link name is appended), empty - static only
+	IP6             string                 // router mode: own IPv6 address in the mesh (optional)
 
 	configLock sync.RWMutex
 	events     Events // base events emitter that will be propagated to spawned daemons
@@ -52,6 +60,11 @@ func (dm *Config) HostsDir() string {
 	return filepath.Join(dm.ConfigDir, "hosts")
 }
 
+// Location of archived hosts definitions files (see ArchiveHost).
+func (dm *Config) ArchiveDir() string {
+	return filepath.Join(dm.ConfigDir, "hosts.archive")
+}
+
 // Configured daemon or not.
 func (dm *Config) Configured() bool {
 	main, node, err := config.ReadNodeConfig(dm.ConfigDir)
@@ -61,13 +74,34 @@ func (dm *Config) Configured() bool {
 	if main.Interface == "" {
 		return false
 	}
-	ip := strings.TrimSpace(strings.Split(node.Subnet, "/")[0])
+	ip := node.IP()
 	if ip == "" {
 		return false
 	}
 	return true
 }
 
+// Running tincd of the config by pid file (process is alive).
+func (dm *Config) Running() bool {
+	data, err := ioutil.ReadFile(dm.PidFile)
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return false
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
 // Main config of self node.
 func (dm *Config) Main() (config.Main, error) {
 	var main config.Main
@@ -85,7 +119,7 @@ func (dm *Config) Spawn(ctx context.Context) (*Daemon, error) {
 	if main.Interface == "" {
 		return nil, fmt.Errorf("device name not defined in main config")
 	}
-	ip := strings.TrimSpace(strings.Split(node.Subnet, "/")[0])
+	ip := node.IP()
 	if ip == "" {
 		return nil, fmt.Errorf("subnet not defined in node config")
 	}
@@ -102,6 +136,11 @@ func (dm *Config) Spawn(ctx context.Context) (*Daemon, error) {
 		status:       StatusInit,
 		ip:           ip,
 		deviceName:   main.Interface,
+		link:         main.Interface,
+		switchMode:   strings.EqualFold(main.Mode, config.ModeSwitch),
+	}
+	if d.switchMode && dm.Bridge != "" {
+		d.link = dm.Bridge
 	}
 	d.events.SubnetAdded.handlers = append(d.events.SubnetAdded.handlers, dm.events.SubnetAdded.handlers...)
 	d.events.SubnetRemoved.handlers = append(d.events.SubnetRemoved.handlers, dm.events.SubnetRemoved.handlers...)
@@ -165,14 +204,19 @@ func (dm *Config) Hosts() (map[string][]byte, error) {
 }
 
 // AddHost saves content to hosts directory and adds ConnectTo directive. Go-routing safe.
-func (dm *Config) AddHost(name string, content []byte) error {
+func (dm *Config) AddHost(name string, content []byte) (err error) {
+	defer func() {
+		if err == nil {
+			dm.hostChanged(name)
+		}
+	}()
 	dm.configLock.Lock()
 	defer dm.configLock.Unlock()
 	if name != types.CleanString(name) {
 		return fmt.Errorf("malformed host name %s", name)
 	}
 	filename := filepath.Join(dm.HostsDir(), name)
-	err := ioutil.WriteFile(filename, content, 0755)
+	err = ioutil.WriteFile(filename, content, 0755)
 	if err != nil {
 		return fmt.Errorf("save host file: %w", err)
 	}
@@ -195,6 +239,90 @@ func (dm *Config) AddHost(name string, content []byte) error {
 	return nil
 }
 
+// RemoveHost deletes host file and ConnectTo directive. Self node can't be removed. Go-routine safe.
+func (dm *Config) RemoveHost(name string) (err error) {
+	defer func() {
+		if err == nil {
+			dm.hostChanged(name)
+		}
+	}()
+	dm.configLock.Lock()
+	defer dm.configLock.Unlock()
+	if name != types.CleanString(name) {
+		return fmt.Errorf("malformed host name %s", name)
+	}
+	main, err := dm.Main()
+	if err != nil {
+		return fmt.Errorf("read main config: %w", err)
+	}
+	if main.Name == name {
+		return fmt.Errorf("self node can't be removed")
+	}
+	err = os.Remove(filepath.Join(dm.HostsDir(), name))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove host file: %w", err)
+	}
+	var connectTo = main.ConnectTo[:0]
+	for _, node := range main.ConnectTo {
+		if node != name {
+			connectTo = append(connectTo, node)
+		}
+	}
+	main.ConnectTo = connectTo
+	err = config.SaveFile(filepath.Join(dm.ConfigDir, "tinc.conf"), &main)
+	if err != nil {
+		return fmt.Errorf("save main config; %w", err)
+	}
+	return nil
+}
+
+// ArchiveHost moves host file to archive directory and deletes ConnectTo directive. Self node can't be archived.
+// Go-routine safe.
+func (dm *Config) ArchiveHost(name string) (err error) {
+	defer func() {
+		if err == nil {
+			dm.hostChanged(name)
+		}
+	}()
+	dm.configLock.Lock()
+	defer dm.configLock.Unlock()
+	if name != types.CleanString(name) {
+		return fmt.Errorf("malformed host name %s", name)
+	}
+	main, err := dm.Main()
+	if err != nil {
+		return fmt.Errorf("read main config: %w", err)
+	}
+	if main.Name == name {
+		return fmt.Errorf("self node can't be archived")
+	}
+	if err := os.MkdirAll(dm.ArchiveDir(), 0755); err != nil {
+		return fmt.Errorf("create archive dir: %w", err)
+	}
+	err = os.Rename(filepath.Join(dm.HostsDir(), name), filepath.Join(dm.ArchiveDir(), name))
+	if err != nil {
+		return fmt.Errorf("move host file: %w", err)
+	}
+	var connectTo = main.ConnectTo[:0]
+	for _, node := range main.ConnectTo {
+		if node != name {
+			connectTo = append(connectTo, node)
+		}
+	}
+	main.ConnectTo = connectTo
+	err = config.SaveFile(filepath.Join(dm.ConfigDir, "tinc.conf"), &main)
+	if err != nil {
+		return fmt.Errorf("save main config; %w", err)
+	}
+	return nil
+}
+
+func (dm *Config) hostChanged(name string) {
+	if hook := dm.HostChanged; hook != nil {
+		hook(name)
+	}
+}
+
 // Host content. Go-routing safe.
 func (dm *Config) Host(name string) ([]byte, error) {
 	dm.configLock.RLock()
@@ -205,6 +333,22 @@ func (dm *Config) Host(name string) ([]byte, error) {
 	return ioutil.ReadFile(filepath.Join(dm.HostsDir(), name))
 }
 
+// UpdateMain reads main config, applies changes and saves it back. Go-routine safe.
+func (dm *Config) UpdateMain(update func(main *config.Main)) error {
+	dm.configLock.Lock()
+	defer dm.configLock.Unlock()
+	main, err := dm.Main()
+	if err != nil {
+		return fmt.Errorf("read main config: %w", err)
+	}
+	update(&main)
+	err = config.SaveFile(filepath.Join(dm.ConfigDir, "tinc.conf"), &main)
+	if err != nil {
+		return fmt.Errorf("save main config; %w", err)
+	}
+	return nil
+}
+
 // Index hosts and add them to ConnectTo. Not safe from multiple go-routines.
 func (dm *Config) IndexHosts() error {
 	names, err := dm.HostNames()
@@ -216,7 +360,12 @@ func (dm *Config) IndexHosts() error {
 		return fmt.Errorf("read main config: %w", err)
 	}
 
-	main.ConnectTo = names
+	main.ConnectTo = names[:0]
+	for _, name := range names {
+		if dm.ConnectFilter == nil || dm.ConnectFilter(name) {
+			main.ConnectTo = append(main.ConnectTo, name)
+		}
+	}
 
 	err = config.SaveFile(filepath.Join(dm.ConfigDir, "tinc.conf"), &main)
 	if err != nil {
@@ -252,6 +401,9 @@ type Daemon struct {
 	main         *config.Main
 	ip           string
 	deviceName   string
+	link         string // interface with own address: device or bridge in switch mode
+	switchMode   bool
+	dhcp         *exec.Cmd
 	cancel       func()
 	status       Status
 	done         chan struct{}
@@ -342,12 +494,13 @@ func (dm *Daemon) run(ctx context.Context) error {
 		dm.scanner(reader)
 	}()
 
+	defer dm.teardownNetwork()
 	defer wg.Wait()
 	defer writer.Close()
 
 	defer dm.events.Stopped.emit(Configuration{
 		IP:        dm.ip,
-		Interface: dm.deviceName,
+		Interface: dm.link,
 		Self:      *dm.self,
 		Main:      *dm.main,
 	})
@@ -387,24 +540,32 @@ func (dm *Daemon) run(ctx context.Context) error {
 
 func (dm *Daemon) scanner(stream io.Reader) {
 	reader := bufio.NewScanner(stream)
-	routes := make(map[string]bool)
+	routes := make(map[string]map[string]bool) // subnet -> advertising nodes (several gateways for failover)
 	for reader.Scan() {
 		line := reader.Text()
 		if event := IsSubnetAdded(line); event != nil {
-			if !routes[event.Peer.Subnet] {
-				if err := setRouting(dm.deviceName, event.Peer.Subnet); err != nil {
-					log.Println("failed setup route to", event.Peer.Node, ":", err)
+			nodes := routes[event.Peer.Subnet]
+			if !nodes[event.Peer.Node] {
+				if len(nodes) == 0 {
+					if err := dm.addRoute(event.Peer.Subnet); err != nil {
+						log.Println("failed setup route to", event.Peer.Node, ":", err)
+					}
+					nodes = make(map[string]bool)
+					routes[event.Peer.Subnet] = nodes
 				}
+				nodes[event.Peer.Node] = true
 				dm.events.SubnetAdded.emit(*event)
-				routes[event.Peer.Subnet] = true
 			}
 		} else if event := IsSubnetRemoved(line); event != nil {
-			if routes[event.Peer.Subnet] {
-				if err := removeRouting(dm.deviceName, event.Peer.Subnet); err != nil {
-					log.Println("failed remove route to", event.Peer.Node, ":", err)
+			if nodes := routes[event.Peer.Subnet]; nodes[event.Peer.Node] {
+				delete(nodes, event.Peer.Node)
+				if len(nodes) == 0 {
+					if err := dm.removeRoute(event.Peer.Subnet); err != nil {
+						log.Println("failed remove route to", event.Peer.Node, ":", err)
+					}
+					delete(routes, event.Peer.Subnet)
 				}
 				dm.events.SubnetRemoved.emit(*event)
-				delete(routes, event.Peer.Subnet)
 			}
 		} else if event := IsReady(line); event != nil {
 			dm.events.Ready.emit()
@@ -413,7 +574,7 @@ func (dm *Daemon) scanner(stream io.Reader) {
 			} else {
 				dm.events.Configured.emit(Configuration{
 					IP:        dm.ip,
-					Interface: dm.deviceName,
+					Interface: dm.link,
 					Self:      *dm.self,
 					Main:      *dm.main,
 				})
@@ -426,16 +587,112 @@ func (dm *Daemon) scanner(stream io.Reader) {
 	}
 }
 
+// addRoute of subnet to interface. See routed for skipped subnets.
+func (dm *Daemon) addRoute(subnet string) error {
+	if !dm.routed(subnet) {
+		return nil
+	}
+	return setRouting(dm.deviceName, withoutWeight(subnet))
+}
+
+func (dm *Daemon) removeRoute(subnet string) error {
+	if !dm.routed(subnet) {
+		return nil
+	}
+	return removeRouting(dm.deviceName, withoutWeight(subnet))
+}
+
+// routed subnets have kernel route to interface. Default routes of exit nodes are skipped: clients opt-in by
+// policy routing. MAC subnets are never routed. In switch mode nothing is routed: peers are reachable
+// through the link and tincd forwards frames by MAC.
+func (dm *Daemon) routed(subnet string) bool {
+	return !dm.switchMode && !isDefaultRoute(subnet) && !isMAC(subnet)
+}
+
+func isDefaultRoute(subnet string) bool {
+	return strings.HasSuffix(withoutWeight(subnet), "/0")
+}
+
+// withoutWeight returns subnet without tinc weight suffix (10.20.0.0/24#10).
+func withoutWeight(subnet string) string {
+	return strings.TrimSpace(strings.SplitN(subnet, "#", 2)[0])
+}
+
+func isMAC(subnet string) bool {
+	_, err := net.ParseMAC(strings.TrimSpace(subnet))
+	return err == nil
+}
+
 func (dm *Daemon) setupNetwork() error {
-	if err := setAddress(dm.deviceName, dm.ip); err != nil {
-		return fmt.Errorf("set address: %w", err)
+	if !dm.switchMode {
+		if err := setAddress(dm.deviceName, dm.ip+"/32"); err != nil {
+			return fmt.Errorf("set address: %w", err)
+		}
+		if dm.config.IP6 != "" {
+			if err := setAddress(dm.deviceName, dm.config.IP6+"/128"); err != nil {
+				return fmt.Errorf("set IPv6 address: %w", err)
+			}
+		}
+		if err := enableInterface(dm.deviceName); err != nil {
+			return fmt.Errorf("bring interface up: %w", err)
+		}
+		return nil
+	}
+	address, err := dm.address()
+	if err != nil {
+		return err
+	}
+	if dm.config.Bridge != "" {
+		if err := setMaster(dm.deviceName, dm.config.Bridge); err != nil {
+			return fmt.Errorf("attach to bridge %s: %w", dm.config.Bridge, err)
+		}
 	}
 	if err := enableInterface(dm.deviceName); err != nil {
 		return fmt.Errorf("bring interface up: %w", err)
 	}
+	// own address is kept with DHCP: discovery and API are bound to it
+	if err := setAddress(dm.link, address); err != nil {
+		return fmt.Errorf("set address: %w", err)
+	}
+	if len(dm.config.DHCPClient) > 0 {
+		client := exec.Command(dm.config.DHCPClient[0], append(dm.config.DHCPClient[1:], dm.link)...)
+		client.Stdout = os.Stderr
+		client.Stderr = os.Stderr
+		if err := client.Start(); err != nil {
+			return fmt.Errorf("start DHCP client: %w", err)
+		}
+		dm.dhcp = client
+	}
 	return nil
 }
 
+// address of node with prefix length of overlay network (switch mode).
+func (dm *Daemon) address() (string, error) {
+	_, network, err := net.ParseCIDR(dm.config.Network)
+	if err != nil {
+		return "", fmt.Errorf("network CIDR required in switch mode: %w", err)
+	}
+	ones, _ := network.Mask.Size()
+	return dm.ip + "/" + strconv.Itoa(ones), nil
+}
+
+// teardownNetwork reverts changes which outlive the device: DHCP client and address of bridge.
+func (dm *Daemon) teardownNetwork() {
+	if dm.dhcp != nil {
+		_ = dm.dhcp.Process.Signal(syscall.SIGTERM)
+		_ = dm.dhcp.Wait()
+		dm.dhcp = nil
+	}
+	if dm.link == dm.deviceName {
+		return
+	}
+	if address, err := dm.address(); err == nil {
+		if err := removeAddress(dm.link, address); err != nil {
+			log.Println("daemon", dm.name, "remove address from", dm.link, ":", err)
+		}
+	}
+}
+
 // event:"Configured"
 // event:"Stopped"
 type Configuration struct {
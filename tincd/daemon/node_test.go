@@ -0,0 +1,42 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon_routed(t *testing.T) {
+	cases := []struct {
+		subnet     string
+		switchMode bool
+		mac        bool
+		routed     bool
+	}{
+		{subnet: "10.0.0.2/32", routed: true},
+		{subnet: "10.20.0.0/24#10", routed: true},
+		{subnet: "fd00::2/128", routed: true},
+		{subnet: "0.0.0.0/0", routed: false},
+		{subnet: "::/0#10", routed: false},
+		{subnet: "6e:6a:5e:26:39:d2", mac: true, routed: false},
+		{subnet: "10.0.0.2/32", switchMode: true, routed: false},
+		{subnet: "6e:6a:5e:26:39:d2", switchMode: true, mac: true, routed: false},
+	}
+	for _, c := range cases {
+		dm := &Daemon{switchMode: c.switchMode}
+		assert.Equal(t, c.mac, isMAC(c.subnet), c.subnet)
+		assert.Equal(t, c.routed, dm.routed(c.subnet), "%s (switch: %v)", c.subnet, c.switchMode)
+	}
+}
+
+func TestDaemon_address(t *testing.T) {
+	dm := &Daemon{ip: "10.0.0.2", config: &Config{Network: "10.0.0.0/16"}}
+	address, err := dm.address()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2/16", address)
+
+	dm.config.Network = ""
+	_, err = dm.address()
+	assert.Error(t, err, "network CIDR required in switch mode")
+}
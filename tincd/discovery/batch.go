@@ -0,0 +1,107 @@
+package discovery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errNoBatch = errors.New("batch not supported")
+
+// Record is entity with content of host file (empty for tombstones).
+type Record struct {
+	Entity
+	Content []byte `json:",omitempty"`
+}
+
+// BatchRequest for several host files by one request.
+type BatchRequest struct {
+	Names []string
+}
+
+func (srv *server) getBatch(writer http.ResponseWriter, request *http.Request) {
+	const maxPayload = 1024 * 1024
+	defer request.Body.Close()
+	if request.Method != http.MethodPost {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req BatchRequest
+	if err := json.NewDecoder(io.LimitReader(request.Body, maxPayload)).Decode(&req); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var records = make([]Record, 0, len(req.Names))
+	for _, name := range req.Names {
+		info, ok := srv.ssd.Get(name)
+		if !ok {
+			continue
+		}
+		var record = Record{Entity: info}
+		if !info.Removed {
+			content, err := srv.config.Host(name)
+			if err != nil && !info.Admitted {
+				continue
+			}
+			record.Content = content
+		}
+		records = append(records, record)
+	}
+	writeJSON(writer, request, records)
+}
+
+// writeJSON response compressed by gzip if client accepts it.
+func writeJSON(writer http.ResponseWriter, request *http.Request, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	if !strings.Contains(request.Header.Get("Accept-Encoding"), "gzip") {
+		writer.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(writer).Encode(value)
+		return
+	}
+	writer.Header().Set("Content-Encoding", "gzip")
+	writer.WriteHeader(http.StatusOK)
+	zip := gzip.NewWriter(writer)
+	_ = json.NewEncoder(zip).Encode(value)
+	_ = zip.Close()
+}
+
+// fetchBatch of host files by names. Returns errNoBatch for old peers.
+func (rq *requester) fetchBatch(global context.Context, names []string) ([]Record, error) {
+	const timeout = 30 * time.Second
+	data, err := json.Marshal(BatchRequest{Names: names})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(global, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+rq.address+"/hosts/batch", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req) // compressed response is decoded by transport
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusMethodNotAllowed {
+		return nil, errNoBatch
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("returned unexpected status code %d", res.StatusCode)
+	}
+	var records []Record
+	if err := json.NewDecoder(res.Body).Decode(&records); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return records, nil
+}
@@ -1,8 +1,11 @@
 package discovery
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,9 +14,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/reddec/tinc-boot/tincd/authority"
 	"github.com/reddec/tinc-boot/tincd/daemon"
 )
 
+var errNotFound = errors.New("not found")
+
 func NewClient(ssd *SSD, config *daemon.Config, interval time.Duration) *Client {
 	return &Client{
 		ssd:      ssd,
@@ -23,11 +29,18 @@ func NewClient(ssd *SSD, config *daemon.Config, interval time.Duration) *Client
 }
 
 type Client struct {
-	ssd        *SSD
-	config     *daemon.Config
-	requesters map[string]*requester
-	lock       sync.Mutex
-	interval   time.Duration
+	Removed          func(name string)     // hook called after host removed by tombstone
+	RequireSignature bool                  // reject unsigned records (invalid signatures are always rejected)
+	Authority        authority.PublicKey   // if set - only hosts with valid admission are accepted
+	Admins           []authority.PublicKey // keys of network administrators: removals and re-admissions should be signed
+	Self             string                // own node name: own record is never imported from peers
+	Outdated         func()                // hook called if peers have newer own record than local one
+	Reached          func(address string)  // hook called after successful request to peer
+	ssd              *SSD
+	config           *daemon.Config
+	requesters       map[string]*requester
+	lock             sync.Mutex
+	interval         time.Duration
 }
 
 func (cl *Client) Watch(ctx context.Context, address string) bool {
@@ -44,17 +57,108 @@ func (cl *Client) Watch(ctx context.Context, address string) bool {
 	child, cancel := context.WithCancel(ctx)
 
 	rq := &requester{
+		merger:  cl.merger(),
 		address: address,
 		cancel:  cancel,
 		done:    make(chan struct{}),
-		ssd:     cl.ssd,
-		config:  cl.config,
+		wake:    make(chan struct{}, 1),
 	}
 	cl.requesters[address] = rq
 	go rq.runLoop(child, cl.interval)
 	return true
 }
 
+// merger of records with current settings of client.
+func (cl *Client) merger() *merger {
+	return &merger{
+		self:      cl.Self,
+		outdated:  cl.Outdated,
+		ssd:       cl.ssd,
+		store:     cl.config,
+		removed:   cl.Removed,
+		strict:    cl.RequireSignature,
+		authority: cl.Authority,
+		admins:    cl.Admins,
+		reached:   cl.Reached,
+	}
+}
+
+// Poke requester of peer to gather information right now (peer announced new versions).
+func (cl *Client) Poke(address string) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	if rq, ok := cl.requesters[address]; ok {
+		select {
+		case rq.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Announce local changes (new versions of entities) to all watched peers by /notify till context canceled.
+// Announces are sent not often than once per second.
+func (cl *Client) Announce(ctx context.Context) {
+	const debounce = time.Second
+	known := make(map[string]int64)
+	for _, entity := range cl.ssd.Header() {
+		known[entity.Name] = entity.Version
+	}
+	digest := cl.ssd.Digest()
+	for {
+		digest = cl.ssd.Wait(ctx, digest)
+		if ctx.Err() != nil {
+			return
+		}
+		var changed []Entity
+		for _, entity := range cl.ssd.Header() {
+			if known[entity.Name] != entity.Version {
+				known[entity.Name] = entity.Version
+				changed = append(changed, entity)
+			}
+		}
+		if len(changed) > 0 {
+			cl.notifyAll(ctx, changed)
+		}
+		select {
+		case <-time.After(debounce):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (cl *Client) notifyAll(ctx context.Context, entities []Entity) {
+	const timeout = 5 * time.Second
+	data, err := json.Marshal(entities)
+	if err != nil {
+		log.Println("failed encode announce:", err)
+		return
+	}
+	cl.lock.Lock()
+	var addresses = make([]string, 0, len(cl.requesters))
+	for address := range cl.requesters {
+		addresses = append(addresses, address)
+	}
+	cl.lock.Unlock()
+
+	for _, address := range addresses {
+		go func(address string) {
+			tctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			req, err := http.NewRequestWithContext(tctx, http.MethodPost, "http://"+address+"/notify", bytes.NewReader(data))
+			if err != nil {
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return // peer will get changes by polling anyway
+			}
+			_ = res.Body.Close()
+		}(address)
+	}
+}
+
 func (cl *Client) Forget(address string) {
 	cl.lock.Lock()
 	req, ok := cl.requesters[address]
@@ -81,65 +185,127 @@ func (cl *Client) Close() {
 }
 
 type requester struct {
+	*merger
 	address string
 	cancel  func()
 	done    chan struct{}
-	ssd     *SSD
-	config  *daemon.Config
+	wake    chan struct{} // peer announced changes
+	etag    string        // digest of the last completely applied headers
+	noBatch bool          // peer doesn't support batch requests (old version)
 }
 
+// runLoop gathers information by long-polling. Peers without long-polling support (old versions) and
+// failed requests are retried after interval or by announce from peer.
 func (rq *requester) runLoop(ctx context.Context, interval time.Duration) {
-	ticker := time.NewTicker(interval)
 	defer close(rq.done)
-	defer ticker.Stop()
+	var digest string
 	for {
-		err := rq.gatherInfo(ctx)
+		next, err := rq.gatherInfo(ctx, digest)
 		if err != nil {
 			log.Println("failed save meta data:", err)
 		}
+		digest = next
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && digest != "" {
+			continue
+		}
 
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-time.After(interval):
+		case <-rq.wake:
 		}
 	}
 }
 
-func (rq *requester) gatherInfo(ctx context.Context) error {
-	entities, err := rq.fetchHeaders(ctx)
+// gatherInfo fetches changed headers and content of newer hosts. Returns digest of peer state for the next
+// long-polling request or empty string if polling is not supported or not all hosts fetched.
+func (rq *requester) gatherInfo(ctx context.Context, since string) (string, error) {
+	entities, digest, err := rq.fetchHeaders(ctx, since)
 	if err != nil {
-		return fmt.Errorf("fetch headers: %w", err)
+		return "", fmt.Errorf("fetch headers: %w", err)
+	}
+	rq.seen(rq.address)
+	var candidates []Entity
+	for _, entity := range entities {
+		if rq.ssd.CanBeMerged(entity) {
+			candidates = append(candidates, entity)
+		}
 	}
+	records, complete := rq.fetchRecords(ctx, candidates)
 	var changed = false
+	for _, record := range records {
+		if rq.merge(rq.address, record.Entity, record.Content) {
+			changed = true
+		}
+	}
+	if complete {
+		rq.etag = digest
+	} else {
+		digest = "" // retry later
+	}
+	if !changed {
+		return digest, nil
+	}
+	err = rq.ssd.Save()
+	if err != nil {
+		return "", fmt.Errorf("save meta data: %w", err)
+	}
+	return digest, nil
+}
+
+// fetchRecords of changed entities by one batch request or one by one for old peers.
+// Returns false if some records are not fetched.
+func (rq *requester) fetchRecords(ctx context.Context, entities []Entity) ([]Record, bool) {
+	var names []string
+	var records = make([]Record, 0, len(entities))
+	for _, entity := range entities {
+		if entity.Removed {
+			records = append(records, Record{Entity: entity})
+		} else {
+			names = append(names, entity.Name)
+		}
+	}
+	if len(names) == 0 {
+		return records, true
+	}
+	if !rq.noBatch {
+		batch, err := rq.fetchBatch(ctx, names)
+		if err == nil {
+			return append(records, batch...), len(batch) == len(names)
+		}
+		if !errors.Is(err, errNoBatch) {
+			log.Println("failed get batch of", len(names), "hosts from", rq.address, ":", err)
+			return records, false
+		}
+		rq.noBatch = true
+	}
+	var complete = true
 	for _, entity := range entities {
-		if !rq.ssd.CanBeMerged(entity) {
+		if entity.Removed {
 			continue
 		}
-
 		content, info, err := rq.fetchContent(ctx, entity)
+		if errors.Is(err, errNotFound) && entity.Admitted {
+			records = append(records, Record{Entity: entity}) // re-admitted node not joined yet
+			continue
+		}
 		if err != nil {
 			log.Println("failed get content for", entity.Name, ":", err)
+			complete = false
 			continue
 		}
-		log.Println("discovered node", info.Name, "version", info.Version, "from", rq.address)
-		changed = changed || rq.ssd.ReplaceIfNewer(*info, func() bool {
-			err = rq.config.AddHost(info.Name, content)
-			if err != nil {
-				log.Println("failed save file", info.Name, ":", err)
-				return false
-			}
-			return true
-		})
-	}
-	if !changed {
-		return nil
-	}
-	err = rq.ssd.Save()
-	if err != nil {
-		return fmt.Errorf("save meta data: %w", err)
+		info.Admitted = entity.Admitted
+		info.Decided = entity.Decided
+		info.Decision = entity.Decision
+		info.Admission = entity.Admission
+		info.Catalog = entity.Catalog
+		records = append(records, Record{Entity: *info, Content: content})
 	}
-	return nil
+	return records, complete
 }
 
 func (rq *requester) fetchContent(global context.Context, entity Entity) ([]byte, *Entity, error) {
@@ -159,6 +325,9 @@ func (rq *requester) fetchContent(global context.Context, entity Entity) ([]byte
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil, errNotFound
+	}
 	if res.StatusCode != 200 {
 		return nil, nil, fmt.Errorf("returned unexpected status code %d", res.StatusCode)
 	}
@@ -181,34 +350,53 @@ func (rq *requester) fetchContent(global context.Context, entity Entity) ([]byte
 		Name:    name,
 		Version: version,
 	}
+	if signature := res.Header.Get("X-Signature"); signature != "" {
+		newEntity.Signature, err = base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode signature: %w", err)
+		}
+	}
 
 	return content, &newEntity, nil
 }
 
-func (rq *requester) fetchHeaders(global context.Context) ([]Entity, error) {
+func (rq *requester) fetchHeaders(global context.Context, since string) ([]Entity, string, error) {
 	const timeout = 10 * time.Second
-	ctx, cancel := context.WithTimeout(global, timeout)
+	url := "http://" + rq.address + "/hosts"
+	wait := timeout
+	if since != "" {
+		url += "?since=" + since
+		wait += PollTimeout
+	}
+	ctx, cancel := context.WithTimeout(global, wait)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+rq.address+"/hosts", nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
+		return nil, "", fmt.Errorf("create request: %w", err)
+	}
+	if rq.etag != "" {
+		req.Header.Set("If-None-Match", `"`+rq.etag+`"`)
 	}
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("execute request: %w", err)
+		return nil, "", fmt.Errorf("execute request: %w", err)
 	}
 	defer res.Body.Close()
 
+	digest := res.Header.Get("X-Digest") // not set by old versions
+	if res.StatusCode == http.StatusNotModified {
+		return nil, digest, nil
+	}
 	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("returned unexpected status code %d", res.StatusCode)
+		return nil, "", fmt.Errorf("returned unexpected status code %d", res.StatusCode)
 	}
 
 	var item []Entity
 	err = json.NewDecoder(res.Body).Decode(&item)
 	if err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
+		return nil, "", fmt.Errorf("decode response: %w", err)
 	}
-	return item, nil
+	return item, digest, nil
 }
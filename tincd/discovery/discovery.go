@@ -6,27 +6,48 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/ipam"
 )
 
 const Port = "18655"
 
 func New(ssd *SSD, config *daemon.Config, interval time.Duration) *Discovery {
-	return &Discovery{
-		client:        NewClient(ssd, config, interval),
-		serverHandler: NewServer(ssd, config),
+	client := NewClient(ssd, config, interval)
+	ds := &Discovery{
+		client:        client,
+		gossip:        NewGossip(ssd, config, 0),
+		serverHandler: newServer(ssd, config, client.Poke),
 	}
+	ds.client.Removed = ds.hostRemoved
+	ds.client.Reached = ds.reached
+	ds.gossip.Fallback = ds.watchLegacy
+	return ds
 }
 
 type Discovery struct {
+	Removed       func(name string) // hook called after host removed by tombstone from other node
+	Fanout        int               // gossip mode: sync with this number of random peers per interval (0 - watch every peer)
+	Registries    []Registry        // external registries to publish own record and pull others
+	RegistrySync  time.Duration     // interval of sync with registries
 	client        *Client
+	gossip        *Gossip
 	serverHandler http.Handler
-	httpServer    struct {
+	nodes         struct {
+		lock   sync.Mutex
+		byAddr map[string]string // discovery address -> node name (from subnet events)
+	}
+	httpServer struct {
 		server *http.Server
 		done   chan struct{}
 	}
+	background struct {
+		cancel func()
+		done   sync.WaitGroup
+	}
 }
 
 func (ds *Discovery) Configured(payload daemon.Configuration) {
@@ -44,6 +65,30 @@ func (ds *Discovery) Configured(payload daemon.Configuration) {
 			log.Println("discovery server stopped:", err)
 		}
 	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ds.background.cancel = cancel
+	ds.background.done.Add(1)
+	go func() {
+		defer ds.background.done.Done()
+		ds.client.Announce(ctx)
+	}()
+	for _, registry := range ds.Registries {
+		ds.background.done.Add(1)
+		go func(registry Registry) {
+			defer ds.background.done.Done()
+			ds.client.Sync(ctx, registry, ds.RegistrySync)
+		}(registry)
+	}
+	if ds.Fanout > 0 {
+		ds.gossip.fanout = ds.Fanout
+		ds.gossip.merger = ds.client.merger()
+		ds.background.done.Add(1)
+		go func() {
+			defer ds.background.done.Done()
+			ds.gossip.Run(ctx, ds.client.interval)
+		}()
+	}
 }
 
 func (ds *Discovery) Stopped(payload daemon.Configuration) {
@@ -51,17 +96,86 @@ func (ds *Discovery) Stopped(payload daemon.Configuration) {
 		_ = ds.httpServer.server.Close()
 		<-ds.httpServer.done
 	}
+	if ds.background.cancel != nil {
+		ds.background.cancel()
+		ds.background.done.Wait()
+	}
 }
 
 func (ds *Discovery) SubnetAdded(payload daemon.EventSubnetAdded) {
-	if ds.client.Watch(context.Background(), strings.Split(payload.Peer.Subnet, "/")[0]+":"+Port) {
+	if isRoute(payload.Peer.Subnet) {
+		return
+	}
+	address := strings.Split(payload.Peer.Subnet, "/")[0] + ":" + Port
+	ds.nodes.lock.Lock()
+	if ds.nodes.byAddr == nil {
+		ds.nodes.byAddr = make(map[string]string)
+	}
+	ds.nodes.byAddr[address] = payload.Peer.Node
+	ds.nodes.lock.Unlock()
+	ds.client.ssd.Touch(payload.Peer.Node, time.Now())
+	if ds.Fanout > 0 {
+		if ds.gossip.Add(address) {
+			log.Println("gossip peer", payload.Peer.Subnet)
+		}
+		return
+	}
+	if ds.client.Watch(context.Background(), address) {
 		log.Println("watching subnet", payload.Peer.Subnet)
 	}
 }
 
 func (ds *Discovery) SubnetRemoved(payload daemon.EventSubnetRemoved) {
+	if isRoute(payload.Peer.Subnet) {
+		return
+	}
 	log.Println("forgetting subnet", payload.Peer.Subnet)
-	ds.client.Forget(strings.Split(payload.Peer.Subnet, "/")[0] + ":" + Port)
+	address := strings.Split(payload.Peer.Subnet, "/")[0] + ":" + Port
+	ds.nodes.lock.Lock()
+	delete(ds.nodes.byAddr, address)
+	ds.nodes.lock.Unlock()
+	if ds.Fanout > 0 {
+		ds.gossip.Remove(address)
+	}
+	ds.client.Forget(address)
+}
+
+// watchLegacy peer without gossip support in gossip mode.
+func (ds *Discovery) watchLegacy(address string) {
+	if ds.client.Watch(context.Background(), address) {
+		log.Println("watching old peer", address)
+	}
+}
+
+// Client of discovery for settings. Should be configured before start.
+func (ds *Discovery) Client() *Client {
+	return ds.client
+}
+
+// reached peer by discovery request: node is alive.
+func (ds *Discovery) reached(address string) {
+	ds.nodes.lock.Lock()
+	name, ok := ds.nodes.byAddr[address]
+	ds.nodes.lock.Unlock()
+	if ok {
+		ds.client.ssd.Touch(name, time.Now())
+	}
+}
+
+// isRoute checks that subnet is a network advertised by gateway, not address of node.
+func isRoute(subnet string) bool {
+	ipNet, err := ipam.ParseSubnet(subnet)
+	if err != nil {
+		return true
+	}
+	ones, bits := ipNet.Mask.Size()
+	return ones != bits
+}
+
+func (ds *Discovery) hostRemoved(name string) {
+	if callback := ds.Removed; callback != nil {
+		callback(name)
+	}
 }
 
 func (ds *Discovery) Ready(payload daemon.EventReady) {
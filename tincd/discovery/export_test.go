@@ -0,0 +1,8 @@
+package discovery
+
+import "github.com/reddec/tinc-boot/tincd/authority"
+
+// SetAdmins of gossip merger. In production merger is shared with client (see Discovery.Configured).
+func (g *Gossip) SetAdmins(admins ...authority.PublicKey) {
+	g.merger.admins = admins
+}
@@ -0,0 +1,217 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// GossipBuckets is number of digest buckets exchanged by anti-entropy (see SSD.Buckets).
+const GossipBuckets = 256
+
+// ErrNoGossip returned by transport for old peers without anti-entropy support.
+var ErrNoGossip = errors.New("gossip not supported")
+
+// GossipRequest of anti-entropy: state of requester.
+type GossipRequest struct {
+	Digest  string   // digest of whole SSD: nothing to exchange if same
+	Buckets []string // digests of buckets
+}
+
+// GossipReply with entities of differing buckets.
+type GossipReply struct {
+	Hosts []Record
+}
+
+// GossipTransport sends anti-entropy request to peer by address.
+type GossipTransport func(ctx context.Context, address string, request GossipRequest) (*GossipReply, error)
+
+// NewGossip creates anti-entropy gossip: each round node pulls differing entries from fan-out number of random
+// live peers instead of watching every peer.
+func NewGossip(ssd *SSD, store HostStore, fanout int) *Gossip {
+	return &Gossip{
+		Transport: HTTPGossip,
+		merger:    &merger{ssd: ssd, store: store},
+		fanout:    fanout,
+		peers:     make(map[string]bool),
+		legacy:    make(map[string]bool),
+	}
+}
+
+type Gossip struct {
+	Transport GossipTransport
+	Fallback  func(address string) // hook called for old peer without anti-entropy support (ex: watch it)
+	merger    *merger
+	fanout    int
+	lock      sync.Mutex
+	peers     map[string]bool
+	legacy    map[string]bool // live peers without anti-entropy support
+}
+
+// Add live peer. Returns false if peer already known.
+func (g *Gossip) Add(address string) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.peers[address] || g.legacy[address] {
+		return false
+	}
+	g.peers[address] = true
+	return true
+}
+
+// Remove peer from live peers.
+func (g *Gossip) Remove(address string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.peers, address)
+	delete(g.legacy, address)
+}
+
+// Run rounds with interval till context canceled. SSD saved after each round with changes.
+func (g *Gossip) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, merged := g.Round(ctx); merged > 0 {
+			if err := g.merger.ssd.Save(); err != nil {
+				log.Println("failed save meta data:", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Round of anti-entropy with random peers. Returns number of requests and merged entities.
+func (g *Gossip) Round(ctx context.Context) (requests int, merged int) {
+	peers := g.pick()
+	if len(peers) == 0 {
+		return 0, 0
+	}
+	request := GossipRequest{
+		Digest:  g.merger.ssd.Digest(),
+		Buckets: g.merger.ssd.Buckets(GossipBuckets),
+	}
+	for _, address := range peers {
+		requests++
+		reply, err := g.Transport(ctx, address, request)
+		if errors.Is(err, ErrNoGossip) {
+			g.fallback(address)
+			continue
+		}
+		if err != nil {
+			log.Println("gossip with", address, "failed:", err)
+			continue
+		}
+		g.merger.seen(address)
+		for _, host := range reply.Hosts {
+			if g.merger.merge(address, host.Entity, host.Content) {
+				merged++
+			}
+		}
+	}
+	return requests, merged
+}
+
+// Reply to anti-entropy request: entities of differing buckets with content.
+func (g *Gossip) Reply(request GossipRequest) *GossipReply {
+	return gossipReply(g.merger.ssd, g.merger.store, request)
+}
+
+// fallback to watching of old peer: it will not be picked for anti-entropy till removed.
+func (g *Gossip) fallback(address string) {
+	g.lock.Lock()
+	delete(g.peers, address)
+	g.legacy[address] = true
+	g.lock.Unlock()
+	log.Println("peer", address, "doesn't support gossip")
+	if callback := g.Fallback; callback != nil {
+		callback(address)
+	}
+}
+
+// pick up to fan-out random live peers.
+func (g *Gossip) pick() []string {
+	g.lock.Lock()
+	var peers = make([]string, 0, len(g.peers))
+	for address := range g.peers {
+		peers = append(peers, address)
+	}
+	g.lock.Unlock()
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	if len(peers) > g.fanout {
+		peers = peers[:g.fanout]
+	}
+	return peers
+}
+
+func gossipReply(ssd *SSD, store HostStore, request GossipRequest) *GossipReply {
+	var reply GossipReply
+	if request.Digest == ssd.Digest() {
+		return &reply
+	}
+	buckets := ssd.Buckets(GossipBuckets)
+	differs := func(name string) bool {
+		idx := bucketOf(name, GossipBuckets)
+		return len(request.Buckets) != len(buckets) || request.Buckets[idx] != buckets[idx]
+	}
+	for _, entity := range ssd.Header() {
+		if !differs(entity.Name) {
+			continue
+		}
+		var host = Record{Entity: entity}
+		if !entity.Removed {
+			content, err := store.Host(entity.Name)
+			if err != nil && !entity.Admitted {
+				continue
+			}
+			host.Content = content
+		}
+		reply.Hosts = append(reply.Hosts, host)
+	}
+	return &reply
+}
+
+// HTTPGossip sends anti-entropy request to discovery server of peer.
+func HTTPGossip(ctx context.Context, address string, request GossipRequest) (*GossipReply, error) {
+	const timeout = 10 * time.Second
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(tctx, http.MethodPost, "http://"+address+"/gossip", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusMethodNotAllowed {
+		return nil, ErrNoGossip
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("returned unexpected status code %d", res.StatusCode)
+	}
+	var reply GossipReply
+	if err := json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &reply, nil
+}
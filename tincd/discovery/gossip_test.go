@@ -0,0 +1,209 @@
+package discovery_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+type memStore struct {
+	lock  sync.Mutex
+	hosts map[string][]byte
+}
+
+func (ms *memStore) Host(name string) ([]byte, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	content, ok := ms.hosts[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return content, nil
+}
+
+func (ms *memStore) AddHost(name string, content []byte) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.hosts[name] = content
+	return nil
+}
+
+func (ms *memStore) RemoveHost(name string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delete(ms.hosts, name)
+	return nil
+}
+
+// simulation of gossip network with in-process peers: every node knows only itself and sees all peers alive.
+type simulation struct {
+	nodes    map[string]*simNode
+	admin    *authority.Authority
+	requests int64
+}
+
+type simNode struct {
+	ssd    *discovery.SSD
+	gossip *discovery.Gossip
+}
+
+func newSimulation(n, fanout int) *simulation {
+	admin, err := authority.Generate()
+	if err != nil {
+		panic(err)
+	}
+	sim := &simulation{nodes: make(map[string]*simNode, n), admin: admin}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("node%d", i)
+		store := &memStore{hosts: map[string][]byte{
+			name: []byte(fmt.Sprintf("Subnet = 10.%d.%d.%d/32\n", i>>16, (i>>8)&0xff, i&0xff)),
+		}}
+		ssd := discovery.NewSSD("")
+		ssd.Replace(discovery.Entity{Name: name, Version: 1})
+		node := &simNode{ssd: ssd, gossip: discovery.NewGossip(ssd, store, fanout)}
+		node.gossip.Transport = sim.transport
+		node.gossip.SetAdmins(admin.Public())
+		sim.nodes[name] = node
+	}
+	for name, node := range sim.nodes {
+		for peer := range sim.nodes {
+			if peer != name {
+				node.gossip.Add(peer)
+			}
+		}
+	}
+	return sim
+}
+
+func (sim *simulation) transport(_ context.Context, address string, request discovery.GossipRequest) (*discovery.GossipReply, error) {
+	atomic.AddInt64(&sim.requests, 1)
+	return sim.nodes[address].gossip.Reply(request), nil
+}
+
+func (sim *simulation) converged() bool {
+	var digest *string
+	for _, node := range sim.nodes {
+		d := node.ssd.Digest()
+		if digest == nil {
+			digest = &d
+		} else if *digest != d {
+			return false
+		}
+	}
+	return true
+}
+
+// run rounds (all nodes in parallel) till convergence. Returns number of rounds.
+func (sim *simulation) run(maxRounds int) int {
+	for round := 1; round <= maxRounds; round++ {
+		var wg sync.WaitGroup
+		for _, node := range sim.nodes {
+			wg.Add(1)
+			go func(node *simNode) {
+				defer wg.Done()
+				node.gossip.Round(context.Background())
+			}(node)
+		}
+		wg.Wait()
+		if sim.converged() {
+			return round
+		}
+	}
+	return -1
+}
+
+func TestGossip_Converge(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	sim := newSimulation(30, 3)
+	rounds := sim.run(30)
+	assert.True(t, rounds > 0, "network should converge")
+	assert.Equal(t, int64(rounds*30*3), sim.requests)
+
+	// tombstone signed by administrator is propagated
+	tombstone := sim.nodes["node0"].ssd.Tombstone("node1", "")
+	discovery.SignDecision(sim.admin, &tombstone)
+	sim.nodes["node0"].ssd.Replace(tombstone)
+	assert.True(t, sim.run(30) > 0)
+	for name, node := range sim.nodes {
+		assert.True(t, node.ssd.Removed("node1"), name)
+	}
+
+	// unsigned tombstone or re-admission from any peer is rejected
+	sim.nodes["node2"].ssd.Remove("node3", "")
+	sim.nodes["node2"].ssd.Replace(discovery.Entity{Name: "node1", Version: 100, Admitted: true})
+	sim.run(5)
+	for name, node := range sim.nodes {
+		if name != "node2" {
+			assert.False(t, node.ssd.Removed("node3"), name)
+			assert.True(t, node.ssd.Removed("node1"), name)
+		}
+	}
+}
+
+func TestGossip_Fallback(t *testing.T) {
+	old := httptest.NewServer(http.NotFoundHandler()) // peer without /gossip
+	defer old.Close()
+	address := strings.TrimPrefix(old.URL, "http://")
+
+	ssd := discovery.NewSSD("")
+	gossip := discovery.NewGossip(ssd, &memStore{hosts: map[string][]byte{}}, 3)
+	var watched []string
+	gossip.Fallback = func(address string) {
+		watched = append(watched, address)
+	}
+	assert.True(t, gossip.Add(address))
+	requests, _ := gossip.Round(context.Background())
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, []string{address}, watched, "old peer should be watched")
+
+	assert.False(t, gossip.Add(address), "old peer is not gossip peer till removed")
+	requests, _ = gossip.Round(context.Background())
+	assert.Zero(t, requests)
+
+	gossip.Remove(address)
+	assert.True(t, gossip.Add(address), "peer may be upgraded after restart")
+}
+
+// BenchmarkGossip reports rounds (convergence time in discovery intervals) and requests till convergence of
+// network where every node knows only itself. Watching every peer costs n*(n-1) requests per interval.
+func BenchmarkGossip(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	const fanout = 3
+	for _, n := range []int{50, 200, 1000} {
+		b.Run(fmt.Sprintf("nodes=%d", n), func(b *testing.B) {
+			var rounds, requests int64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				sim := newSimulation(n, fanout)
+				b.StartTimer()
+				r := sim.run(100)
+				if r < 0 {
+					b.Fatal("not converged")
+				}
+				rounds += int64(r)
+				requests += sim.requests
+			}
+			b.ReportMetric(float64(rounds)/float64(b.N), "rounds")
+			b.ReportMetric(float64(requests)/float64(b.N), "requests")
+			b.ReportMetric(float64(requests)/float64(b.N)/float64(n), "requests/node")
+			b.ReportMetric(float64(n*(n-1)), "watch-all-requests/interval")
+		})
+	}
+}
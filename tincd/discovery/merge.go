@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/config"
+)
+
+// HostStore of host files (implemented by daemon.Config).
+type HostStore interface {
+	Host(name string) ([]byte, error)
+	AddHost(name string, content []byte) error
+	RemoveHost(name string) error
+}
+
+// merger applies records discovered from peers to SSD and host files.
+type merger struct {
+	self      string
+	outdated  func()
+	ssd       *SSD
+	store     HostStore
+	removed   func(name string)
+	strict    bool
+	authority authority.PublicKey
+	admins    []authority.PublicKey
+	reached   func(address string)
+}
+
+// seen peer alive by successful request.
+func (m *merger) seen(address string) {
+	if m.reached != nil {
+		m.reached(address)
+	}
+}
+
+// merge record from source if it's newer than known. Content is nil for tombstones and re-admitted nodes
+// which are not joined yet. Returns true if record merged.
+func (m *merger) merge(source string, entity Entity, content []byte) bool {
+	if Ahead(entity.Version) {
+		log.Println("rejected record of", entity.Name, "from", source, ": version from the future", entity.Version)
+		return false
+	}
+	if !m.ssd.CanBeMerged(entity) {
+		return false
+	}
+	if entity.Name == m.self {
+		// peers know newer own record (restored backup or lost state): own record should be re-published
+		log.Println("peer", source, "has newer own record version", entity.Version)
+		m.ssd.Observe(entity.Version)
+		if m.outdated != nil {
+			m.outdated()
+		}
+		return false
+	}
+	if entity.Removed || entity.Admitted {
+		// decisions are never accepted without signature of administrator: any peer could remove any node
+		if err := VerifyDecision(entity, m.admins); err != nil {
+			log.Println("rejected decision about", entity.Name, "from", source, ":", err)
+			return false
+		}
+	}
+	if entity.Removed {
+		return m.remove(source, entity)
+	}
+	if content == nil {
+		return entity.Admitted && m.ssd.ReplaceIfNewer(entity, nil)
+	}
+	if err := m.verify(entity, content); err != nil {
+		log.Println("rejected record of", entity.Name, "from", source, ":", err)
+		return false
+	}
+	if name, removed := m.ssd.RemovedKey(config.KeyFingerprint(content)); removed {
+		log.Println("ignoring node", entity.Name, "with key of removed node", name)
+		return false
+	}
+	if m.authority != nil {
+		if err := m.authority.Verify(entity.Admission, entity.Name, content, time.Now()); err != nil {
+			log.Println("rejected record of", entity.Name, "from", source, ":", err)
+			return false
+		}
+	}
+	log.Println("discovered node", entity.Name, "version", entity.Version, "from", source)
+	return m.ssd.ReplaceIfNewer(entity, func() bool {
+		err := m.store.AddHost(entity.Name, content)
+		if err != nil {
+			log.Println("failed save file", entity.Name, ":", err)
+			return false
+		}
+		return true
+	})
+}
+
+// verify signature of record against pinned key (known host file) or key in the record.
+// Unsigned records are accepted in non-strict mode on the first contact for compatibility with old nodes.
+func (m *merger) verify(info Entity, content []byte) error {
+	pinned, err := m.store.Host(info.Name)
+	if err != nil {
+		pinned = nil
+	}
+	if err := VerifyRecord(info, content, pinned, m.strict); err != nil {
+		return err
+	}
+	if err := VerifyCatalog(info, content, pinned); err != nil && (m.strict || !errors.Is(err, ErrUnsigned)) {
+		return err
+	}
+	return nil
+}
+
+// remove host by tombstone.
+func (m *merger) remove(source string, tombstone Entity) bool {
+	removed := m.ssd.ReplaceIfNewer(tombstone, func() bool {
+		err := m.store.RemoveHost(tombstone.Name)
+		if err != nil {
+			log.Println("failed remove host", tombstone.Name, ":", err)
+			return false
+		}
+		return true
+	})
+	if !removed {
+		return false
+	}
+	log.Println("node", tombstone.Name, "removed (tombstone from", source+")")
+	if m.removed != nil {
+		m.removed(tombstone.Name)
+	}
+	return true
+}
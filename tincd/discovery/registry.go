@@ -0,0 +1,191 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/reddec/tinc-boot/types"
+)
+
+// Registry of host records outside of the mesh (shared directory, S3 bucket, DNS zone). Each node publishes own
+// signed record and pulls records of others, so nodes can find each other without reachable peers.
+// Registry is not trusted: records are merged by the same rules as records from peers.
+type Registry interface {
+	fmt.Stringer
+	// Publish own record (replaces previous one).
+	Publish(ctx context.Context, record Record) error
+	// Records of all nodes.
+	Records(ctx context.Context) ([]Record, error)
+}
+
+// ParseRegistry from URL:
+//
+//	file:///mnt/share/vpn                                           - shared directory (also plain path)
+//	s3://[key:secret@]bucket/prefix?endpoint=http://host:9000&region=us-east-1 - S3 compatible bucket
+//	dns://ns.example.com:53/vpn.example.com                         - DNS TXT zone with dynamic updates
+//
+// S3 credentials from environment (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY) are used if not set in URL.
+func ParseRegistry(location string) (Registry, error) {
+	if !strings.Contains(location, "://") {
+		return &DirRegistry{Dir: location}, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parse registry URL: %w", err)
+	}
+	switch u.Scheme {
+	case "file":
+		return &DirRegistry{Dir: u.Path}, nil
+	case "s3":
+		reg := &S3Registry{
+			Endpoint:  u.Query().Get("endpoint"),
+			Region:    u.Query().Get("region"),
+			Bucket:    u.Host,
+			Prefix:    strings.TrimPrefix(u.Path, "/"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}
+		if u.User != nil {
+			reg.AccessKey = u.User.Username()
+			reg.SecretKey, _ = u.User.Password()
+		}
+		if reg.Bucket == "" {
+			return nil, fmt.Errorf("bucket not set in %s", location)
+		}
+		return reg, nil
+	case "dns":
+		reg := &DNSRegistry{
+			Server: u.Host,
+			Zone:   strings.Trim(u.Path, "/"),
+		}
+		if reg.Server == "" || reg.Zone == "" {
+			return nil, fmt.Errorf("server and zone should be set in %s", location)
+		}
+		if !strings.Contains(reg.Server, ":") {
+			reg.Server += ":53"
+		}
+		return reg, nil
+	default:
+		return nil, fmt.Errorf("unknown registry type %s", u.Scheme)
+	}
+}
+
+// Sync own record with registry till context canceled: own record is published after each change of version
+// and records of other nodes are pulled and merged every interval.
+func (cl *Client) Sync(ctx context.Context, registry Registry, interval time.Duration) {
+	var published int64
+	merger := cl.merger()
+	merger.strict = true // anyone with write access to storage could publish: records are never accepted unsigned
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if self, ok := cl.ssd.Get(cl.Self); ok && self.Version != published {
+			content, err := cl.config.Host(cl.Self)
+			if err == nil {
+				err = registry.Publish(ctx, Record{Entity: self, Content: content})
+			}
+			if err != nil {
+				log.Println("failed publish self record to", registry, ":", err)
+			} else {
+				published = self.Version
+			}
+		}
+		records, err := registry.Records(ctx)
+		if err != nil {
+			log.Println("failed get records from", registry, ":", err)
+		}
+		var changed bool
+		for _, record := range records {
+			if merger.merge(registry.String(), record.Entity, record.Content) {
+				changed = true
+			}
+		}
+		if changed {
+			if err := cl.ssd.Save(); err != nil {
+				log.Println("failed save meta data:", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DirRegistry keeps records as JSON files (<name>.json) in shared directory (NFS, synced folder).
+type DirRegistry struct {
+	Dir string
+}
+
+func (dr *DirRegistry) String() string {
+	return "file://" + dr.Dir
+}
+
+func (dr *DirRegistry) Publish(_ context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dr.Dir, 0755); err != nil {
+		return fmt.Errorf("create registry dir: %w", err)
+	}
+	// replace atomically: other nodes may read the file at the same time
+	f, err := ioutil.TempFile(dr.Dir, "."+record.Name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dr.Dir, record.Name+".json"))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("save record: %w", err)
+	}
+	return nil
+}
+
+func (dr *DirRegistry) Records(context.Context) ([]Record, error) {
+	files, err := filepath.Glob(filepath.Join(dr.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var ans = make([]Record, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read record: %w", err)
+		}
+		record, err := decodeRecord(data)
+		if err != nil {
+			log.Println("skipping record", file, ":", err)
+			continue
+		}
+		ans = append(ans, record)
+	}
+	return ans, nil
+}
+
+// decodeRecord from JSON and check that name is valid.
+func decodeRecord(data []byte) (Record, error) {
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, err
+	}
+	if record.Name == "" || types.CleanString(record.Name) != record.Name {
+		return record, fmt.Errorf("malformed host name %s", record.Name)
+	}
+	return record, nil
+}
@@ -0,0 +1,200 @@
+package discovery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSRegistry keeps records in TXT records of DNS zone:
+//
+//	_tinc-boot.<zone>         TXT "<name>"                  - index, one record per node
+//	<name>._tinc-boot.<zone>  TXT "<base64 of JSON record>" - record split to 255 bytes strings
+//
+// Records are published by dynamic updates (RFC 2136): server should allow updates from nodes (ex: by VPN subnet).
+// All requests are sent over TCP because records are bigger than UDP packet.
+type DNSRegistry struct {
+	Server string        // authoritative server address (host:port)
+	Zone   string        // zone name, ex: vpn.example.com
+	TTL    time.Duration // TTL of published records, default 1 minute
+}
+
+const dnsRegistryLabel = "_tinc-boot"
+
+func (dr *DNSRegistry) String() string {
+	return "dns://" + dr.Server + "/" + dr.Zone
+}
+
+func (dr *DNSRegistry) Publish(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	index, err := dr.name(dnsRegistryLabel)
+	if err != nil {
+		return err
+	}
+	recordName, err := dr.name(record.Name + "." + dnsRegistryLabel)
+	if err != nil {
+		return err
+	}
+	zone, err := dr.name("")
+	if err != nil {
+		return err
+	}
+	ttl := uint32(time.Minute / time.Second)
+	if dr.TTL > 0 {
+		ttl = uint32(dr.TTL / time.Second)
+	}
+	update := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(time.Now().UnixNano()), OpCode: dnsOpUpdate},
+		// zone section
+		Questions: []dnsmessage.Question{{Name: zone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}},
+		// update section
+		Authorities: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: index, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.TXTResource{TXT: []string{record.Name}},
+		}, {
+			// delete previous record
+			Header: dnsmessage.ResourceHeader{Name: recordName, Class: dnsmessage.ClassANY},
+			Body:   &dnsmessage.TXTResource{},
+		}, {
+			Header: dnsmessage.ResourceHeader{Name: recordName, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.TXTResource{TXT: splitText(base64.StdEncoding.EncodeToString(data), 255)},
+		}},
+	}
+	_, err = dr.exchange(ctx, update)
+	return err
+}
+
+func (dr *DNSRegistry) Records(ctx context.Context) ([]Record, error) {
+	index, err := dr.txt(ctx, dnsRegistryLabel)
+	if err != nil {
+		return nil, fmt.Errorf("get index: %w", err)
+	}
+	var ans = make([]Record, 0, len(index))
+	for _, txt := range index {
+		name := strings.Join(txt, "")
+		values, err := dr.txt(ctx, name+"."+dnsRegistryLabel)
+		if err != nil {
+			return nil, fmt.Errorf("get record %s: %w", name, err)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Join(values[0], ""))
+		if err != nil {
+			log.Println("skipping record", name, ":", err)
+			continue
+		}
+		record, err := decodeRecord(data)
+		if err != nil {
+			log.Println("skipping record", name, ":", err)
+			continue
+		}
+		ans = append(ans, record)
+	}
+	return ans, nil
+}
+
+// txt records (each one is list of strings) of sub-domain in zone. Non-existent domain has no records.
+func (dr *DNSRegistry) txt(ctx context.Context, sub string) ([][]string, error) {
+	name, err := dr.name(sub)
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(time.Now().UnixNano())},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}},
+	}
+	reply, err := dr.exchange(ctx, query)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ans [][]string
+	for _, answer := range reply.Answers {
+		if txt, ok := answer.Body.(*dnsmessage.TXTResource); ok && strings.EqualFold(answer.Header.Name.String(), name.String()) {
+			ans = append(ans, txt.TXT)
+		}
+	}
+	return ans, nil
+}
+
+func (dr *DNSRegistry) name(sub string) (dnsmessage.Name, error) {
+	fqdn := strings.TrimSuffix(dr.Zone, ".") + "."
+	if sub != "" {
+		fqdn = sub + "." + fqdn
+	}
+	return dnsmessage.NewName(fqdn)
+}
+
+// exchange message with server over TCP. Returns errNotFound for NXDOMAIN.
+func (dr *DNSRegistry) exchange(ctx context.Context, msg dnsmessage.Message) (*dnsmessage.Message, error) {
+	const timeout = 10 * time.Second
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("pack message: %w", err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(tctx, "tcp", dr.Server)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := tctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(packed)))
+	if _, err := conn.Write(append(size[:], packed...)); err != nil {
+		return nil, fmt.Errorf("send message: %w", err)
+	}
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, fmt.Errorf("read reply size: %w", err)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, fmt.Errorf("read reply: %w", err)
+	}
+	var reply dnsmessage.Message
+	if err := reply.Unpack(data); err != nil {
+		return nil, fmt.Errorf("unpack reply: %w", err)
+	}
+	if reply.ID != msg.ID {
+		return nil, fmt.Errorf("reply for another message")
+	}
+	switch reply.RCode {
+	case dnsmessage.RCodeSuccess:
+		return &reply, nil
+	case dnsmessage.RCodeNameError:
+		return nil, errNotFound
+	default:
+		return nil, fmt.Errorf("server returned %v", reply.RCode)
+	}
+}
+
+// dnsOpUpdate is op-code of dynamic update (RFC 2136).
+const dnsOpUpdate dnsmessage.OpCode = 5
+
+func splitText(text string, size int) []string {
+	var ans []string
+	for len(text) > size {
+		ans = append(ans, text[:size])
+		text = text[size:]
+	}
+	return append(ans, text)
+}
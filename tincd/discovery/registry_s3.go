@@ -0,0 +1,206 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Registry keeps records as objects <prefix><name>.json in S3 compatible bucket (AWS, MinIO, ...).
+// Requests are path-style and signed by AWS signature V4 if access key set.
+type S3Registry struct {
+	Endpoint  string // base URL, default is AWS endpoint of region
+	Region    string // default us-east-1
+	Bucket    string
+	Prefix    string // objects prefix, ex: vpn/
+	AccessKey string
+	SecretKey string
+	Client    *http.Client // default http.DefaultClient
+}
+
+func (sr *S3Registry) String() string {
+	return "s3://" + sr.Bucket + "/" + sr.Prefix
+}
+
+func (sr *S3Registry) Publish(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	res, err := sr.do(ctx, http.MethodPut, sr.Prefix+record.Name+".json", nil, data)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	return nil
+}
+
+func (sr *S3Registry) Records(ctx context.Context) ([]Record, error) {
+	keys, err := sr.list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list objects: %w", err)
+	}
+	var ans = make([]Record, 0, len(keys))
+	for _, key := range keys {
+		res, err := sr.do(ctx, http.MethodGet, key, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("get %s: %w", key, err)
+		}
+		data, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", key, err)
+		}
+		record, err := decodeRecord(data)
+		if err != nil {
+			log.Println("skipping record", key, ":", err)
+			continue
+		}
+		ans = append(ans, record)
+	}
+	return ans, nil
+}
+
+// list keys of records (ListObjectsV2 with pagination).
+func (sr *S3Registry) list(ctx context.Context) ([]string, error) {
+	var keys []string
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {sr.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		res, err := sr.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode list: %w", err)
+		}
+		for _, item := range page.Contents {
+			if strings.HasSuffix(item.Key, ".json") && !strings.Contains(item.Key[len(sr.Prefix):], "/") {
+				keys = append(keys, item.Key)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (sr *S3Registry) region() string {
+	if sr.Region == "" {
+		return "us-east-1"
+	}
+	return sr.Region
+}
+
+func (sr *S3Registry) endpoint() string {
+	if sr.Endpoint != "" {
+		return strings.TrimSuffix(sr.Endpoint, "/")
+	}
+	return "https://s3." + sr.region() + ".amazonaws.com"
+}
+
+// do signed request to object (or bucket if key is empty). Response with non 2xx status is an error.
+func (sr *S3Registry) do(ctx context.Context, method string, key string, query url.Values, payload []byte) (*http.Response, error) {
+	const timeout = 30 * time.Second
+	u, err := url.Parse(sr.endpoint() + "/" + sr.Bucket + "/" + key)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	req, err := http.NewRequestWithContext(tctx, method, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if sr.AccessKey != "" {
+		sr.sign(req, payload, time.Now())
+	}
+	client := sr.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	if res.StatusCode/100 != 2 {
+		_ = res.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("returned unexpected status code %d", res.StatusCode)
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// sign request by AWS signature V4.
+func (sr *S3Registry) sign(req *http.Request, payload []byte, now time.Time) {
+	const algorithm = "AWS4-HMAC-SHA256"
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + req.Header.Get("X-Amz-Content-Sha256"),
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	scope := date + "/" + sr.region() + "/s3/aws4_request"
+	toSign := algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + sr.SecretKey)
+	for _, part := range []string{date, sr.region(), "s3", "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		_, _ = mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", algorithm+" Credential="+sr.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(key))
+}
+
+// cancelBody releases request context after body closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (cb *cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+	return err
+}
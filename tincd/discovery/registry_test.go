@@ -0,0 +1,250 @@
+package discovery_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+func TestDirRegistry(t *testing.T) {
+	testRegistry(t, &discovery.DirRegistry{Dir: filepath.Join(t.TempDir(), "registry")})
+}
+
+func TestS3Registry(t *testing.T) {
+	srv := httptest.NewServer(newFakeS3())
+	defer srv.Close()
+	testRegistry(t, &discovery.S3Registry{
+		Endpoint:  srv.URL,
+		Bucket:    "vpn",
+		Prefix:    "hosts/",
+		AccessKey: "key",
+		SecretKey: "secret",
+	})
+}
+
+func TestDNSRegistry(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go newFakeDNS().serve(listener)
+	testRegistry(t, &discovery.DNSRegistry{Server: listener.Addr().String(), Zone: "vpn.example.com"})
+}
+
+// testRegistry: two nodes publish own records to registry and import records of each other by newer version.
+func testRegistry(t *testing.T, registry discovery.Registry) {
+	type node struct {
+		ssd    *discovery.SSD
+		client *discovery.Client
+		sign   func(version int64) discovery.Entity
+	}
+	newNode := func(name, subnet string) node {
+		dc := testConfig(t, name)
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+		// big enough to be split in DNS
+		content := append([]byte(strings.Repeat("# placeholder\n", 40)), hostFile(t, key, subnet)...)
+		require.NoError(t, dc.AddHost(name, content))
+		sign := func(version int64) discovery.Entity {
+			signature, err := discovery.Sign(key, name, version, content)
+			require.NoError(t, err)
+			return discovery.Entity{Name: name, Version: version, Signature: signature}
+		}
+		ssd := discovery.NewSSD(filepath.Join(t.TempDir(), "discovery.json"))
+		ssd.Replace(sign(1))
+		client := discovery.NewClient(ssd, dc, time.Second)
+		client.Self = name
+		return node{ssd: ssd, client: client, sign: sign}
+	}
+	alpha := newNode("alpha", "10.0.0.1/32")
+	beta := newNode("beta", "10.0.0.2/32")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	for _, n := range []node{alpha, beta} {
+		wg.Add(1)
+		go func(n node) {
+			defer wg.Done()
+			n.client.Sync(ctx, registry, 10*time.Millisecond)
+		}(n)
+	}
+
+	known := func(n node, name string, version int64) func() bool {
+		return func() bool {
+			entity, ok := n.ssd.Get(name)
+			return ok && entity.Version == version
+		}
+	}
+	assert.Eventually(t, known(alpha, "beta", 1), 5*time.Second, 10*time.Millisecond, registry.String())
+	assert.Eventually(t, known(beta, "alpha", 1), 5*time.Second, 10*time.Millisecond, registry.String())
+
+	// new version replaces old one
+	alpha.ssd.Replace(alpha.sign(2))
+	assert.Eventually(t, known(beta, "alpha", 2), 5*time.Second, 10*time.Millisecond, registry.String())
+
+	// registry is not trusted: unsigned records and decisions are never imported
+	require.NoError(t, registry.Publish(context.Background(), discovery.Record{
+		Entity:  discovery.Entity{Name: "gamma", Version: 1},
+		Content: []byte("Subnet = 10.0.0.3/32\n"),
+	}))
+	require.NoError(t, registry.Publish(context.Background(), discovery.Record{
+		Entity: discovery.Entity{Name: "alpha", Version: 3, Removed: true},
+	}))
+	records, err := registry.Records(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+	time.Sleep(100 * time.Millisecond) // several sync intervals
+	assert.False(t, known(beta, "gamma", 1)())
+	assert.True(t, known(beta, "alpha", 2)())
+}
+
+// fakeS3 is in-process S3 stand-in: path-style PUT, GET and ListObjectsV2 for signed requests.
+type fakeS3 struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte)}
+}
+
+func (fs *fakeS3) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !strings.HasPrefix(request.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		http.Error(writer, "access denied", http.StatusForbidden)
+		return
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	bucket, key := request.URL.Path[1:], ""
+	if idx := strings.Index(bucket, "/"); idx >= 0 {
+		bucket, key = bucket[:idx], bucket[idx+1:]
+	}
+	switch {
+	case request.Method == http.MethodPut:
+		data, _ := io.ReadAll(request.Body)
+		fs.objects[bucket+"/"+key] = data
+	case key == "" && request.URL.Query().Get("list-type") == "2":
+		var result struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []struct {
+				Key string
+			}
+		}
+		prefix := bucket + "/" + request.URL.Query().Get("prefix")
+		var keys []string
+		for name := range fs.objects {
+			if strings.HasPrefix(name, prefix) {
+				keys = append(keys, strings.TrimPrefix(name, bucket+"/"))
+			}
+		}
+		sort.Strings(keys)
+		for _, name := range keys {
+			result.Contents = append(result.Contents, struct{ Key string }{Key: name})
+		}
+		_ = xml.NewEncoder(writer).Encode(result)
+	default:
+		data, ok := fs.objects[bucket+"/"+key]
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+		_, _ = writer.Write(data)
+	}
+}
+
+// fakeDNS is in-process authoritative DNS server over TCP with TXT records and dynamic updates.
+type fakeDNS struct {
+	lock    sync.Mutex
+	records map[string][][]string
+}
+
+func newFakeDNS() *fakeDNS {
+	return &fakeDNS{records: make(map[string][][]string)}
+}
+
+func (fd *fakeDNS) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go fd.handle(conn)
+	}
+}
+
+func (fd *fakeDNS) handle(conn net.Conn) {
+	defer conn.Close()
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return
+	}
+	data := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(data); err != nil {
+		return
+	}
+	reply := dnsmessage.Message{Header: dnsmessage.Header{ID: msg.ID, Response: true, OpCode: msg.OpCode}}
+	fd.lock.Lock()
+	if msg.OpCode == 5 {
+		for _, update := range msg.Authorities {
+			name := strings.ToLower(update.Header.Name.String())
+			txt := update.Body.(*dnsmessage.TXTResource).TXT
+			if update.Header.Class == dnsmessage.ClassANY {
+				delete(fd.records, name)
+			} else if !fd.has(name, txt) {
+				fd.records[name] = append(fd.records[name], txt)
+			}
+		}
+	} else {
+		question := msg.Questions[0]
+		reply.Questions = msg.Questions
+		values, ok := fd.records[strings.ToLower(question.Name.String())]
+		if !ok {
+			reply.RCode = dnsmessage.RCodeNameError
+		}
+		for _, txt := range values {
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.TXTResource{TXT: txt},
+			})
+		}
+	}
+	fd.lock.Unlock()
+	packed, err := reply.Pack()
+	if err != nil {
+		return
+	}
+	binary.BigEndian.PutUint16(size[:], uint16(len(packed)))
+	_, _ = conn.Write(append(size[:], packed...))
+}
+
+func (fd *fakeDNS) has(name string, txt []string) bool {
+	for _, existent := range fd.records[name] {
+		if strings.Join(existent, "") == strings.Join(txt, "") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/config"
+	"github.com/reddec/tinc-boot/tincd/daemon"
+)
+
+// Retention modes of dead nodes.
+const (
+	RetainConnect = "connect" // drop dead nodes from ConnectTo only, host files are kept
+	RetainArchive = "archive" // move host files of dead nodes to hosts.archive directory
+)
+
+// Retention policy of nodes which were not seen alive (see SSD.Touch) during the period.
+// Dead nodes are not removed from SSD: new version of record (node is back) revives them.
+type Retention struct {
+	Period  time.Duration // 0 - disabled
+	Mode    string        // RetainConnect or RetainArchive
+	Started time.Time     // start of process: nodes are not dead till Period after it (self node could be down longer)
+}
+
+// Dead nodes at the moment (except self node).
+func (r Retention) Dead(ssd *SSD, self string, now time.Time) []Entity {
+	if r.Period <= 0 || r.grace(now) {
+		return nil
+	}
+	return ssd.Stale(self, now.Add(-r.Period))
+}
+
+// grace period after start: last seen times before start are not trusted, nodes could be alive during outage of self.
+func (r Retention) grace(now time.Time) bool {
+	return r.Started.After(now.Add(-r.Period))
+}
+
+// Alive filter of nodes for ConnectTo (see daemon.Config.ConnectFilter). Unknown nodes are alive.
+func (r Retention) Alive(ssd *SSD, self string) func(name string) bool {
+	return func(name string) bool {
+		if r.Period <= 0 || name == self || r.grace(time.Now()) {
+			return true
+		}
+		entity, ok := ssd.Get(name)
+		return !ok || !entity.Seen.Before(time.Now().Add(-r.Period))
+	}
+}
+
+// Apply policy to dead nodes: drop them from ConnectTo (revived nodes are returned back) or archive host files.
+func (r Retention) Apply(dc *daemon.Config, dead []Entity) error {
+	if r.Mode == RetainArchive {
+		for _, entity := range dead {
+			if _, err := os.Stat(filepath.Join(dc.HostsDir(), entity.Name)); os.IsNotExist(err) {
+				continue // already archived
+			}
+			if err := dc.ArchiveHost(entity.Name); err != nil {
+				return fmt.Errorf("archive host %s: %w", entity.Name, err)
+			}
+			log.Println("archived host of dead node", entity.Name, "last seen", entity.Seen.Format(time.RFC3339))
+		}
+		return nil
+	}
+	names, err := dc.HostNames()
+	if err != nil {
+		return err
+	}
+	var isDead = make(map[string]bool, len(dead))
+	for _, entity := range dead {
+		isDead[entity.Name] = true
+	}
+	return dc.UpdateMain(func(main *config.Main) {
+		var connectTo = main.ConnectTo[:0]
+		var known = make(map[string]bool, len(main.ConnectTo))
+		for _, name := range main.ConnectTo {
+			known[name] = true
+			if !isDead[name] {
+				connectTo = append(connectTo, name)
+			}
+		}
+		for _, name := range names {
+			if !known[name] && !isDead[name] {
+				connectTo = append(connectTo, name) // revived
+			}
+		}
+		main.ConnectTo = connectTo
+	})
+}
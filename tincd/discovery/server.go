@@ -1,37 +1,109 @@
 package discovery
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/reddec/tinc-boot/tincd/daemon"
 	"github.com/reddec/tinc-boot/types"
 )
 
+// PollTimeout is maximum time of long-polling request (/hosts?since=<digest>) before Not Modified reply.
+const PollTimeout = 30 * time.Second
+
 func NewServer(ssd *SSD, config *daemon.Config) http.Handler {
+	return newServer(ssd, config, nil)
+}
+
+func newServer(ssd *SSD, config *daemon.Config, notified func(address string)) http.Handler {
 	srv := &server{
-		config: config,
-		ssd:    ssd,
+		config:   config,
+		ssd:      ssd,
+		notified: notified,
 	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("/hosts", srv.getHeaders)                                       // -> [{"Name": "", "Version": 1234}, ...]
+	mux.HandleFunc("/hosts", srv.getHeaders)                                       // -> [{"Name": "", "Version": 1234}, ...], /hosts?since=<digest> blocks till change
 	mux.Handle("/host/", http.StripPrefix("/host/", http.HandlerFunc(srv.getOne))) // /host/abc?after=1234
+	mux.HandleFunc("/notify", srv.notify)                                          // <- [{"Name": "", "Version": 1234}, ...]
+	mux.HandleFunc("/hosts/batch", srv.getBatch)                                   // <- {"Names": [...]} -> [{"Name": "", "Version": 1234, "Content": ""}, ...]
+	mux.HandleFunc("/gossip", srv.gossip)                                          // <- {"Digest": "", "Buckets": [...]} -> {"Hosts": [...]}
 
 	return mux
 }
 
 type server struct {
-	config *daemon.Config
-	ssd    *SSD
+	config   *daemon.Config
+	ssd      *SSD
+	notified func(address string) // hook for announced newer versions by peer with discovery address
 }
 
 func (srv *server) getHeaders(writer http.ResponseWriter, request *http.Request) {
 	defer request.Body.Close()
-	writer.Header().Set("Content-Type", "application/json")
-	writer.WriteHeader(http.StatusOK)
-	_ = srv.ssd.Marshal(writer)
+	digest := srv.ssd.Digest()
+	since, polling := request.URL.Query()["since"]
+	if polling {
+		ctx, cancel := context.WithTimeout(request.Context(), PollTimeout)
+		digest = srv.ssd.Wait(ctx, since[0])
+		cancel()
+	}
+	// digest may be older than content, so the next request returns content again
+	etag := `"` + digest + `"`
+	writer.Header().Set("X-Digest", digest)
+	writer.Header().Set("ETag", etag)
+	if request.Header.Get("If-None-Match") == etag || (polling && since[0] == digest) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(writer, request, srv.ssd.Header())
+}
+
+func (srv *server) notify(writer http.ResponseWriter, request *http.Request) {
+	const maxPayload = 1024 * 1024
+	defer request.Body.Close()
+	if request.Method != http.MethodPost {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var entities []Entity
+	if err := json.NewDecoder(io.LimitReader(request.Body, maxPayload)).Decode(&entities); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil || srv.notified == nil {
+		return
+	}
+	for _, entity := range entities {
+		if srv.ssd.CanBeMerged(entity) {
+			srv.notified(net.JoinHostPort(host, Port))
+			return
+		}
+	}
+}
+
+func (srv *server) gossip(writer http.ResponseWriter, request *http.Request) {
+	const maxPayload = 64 * 1024
+	defer request.Body.Close()
+	if request.Method != http.MethodPost {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req GossipRequest
+	if err := json.NewDecoder(io.LimitReader(request.Body, maxPayload)).Decode(&req); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(writer, request, gossipReply(srv.ssd, srv.config, req))
 }
 
 func (srv *server) getOne(writer http.ResponseWriter, request *http.Request) {
@@ -52,14 +124,25 @@ func (srv *server) getOne(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	if info.Removed {
+		http.Error(writer, "node removed", http.StatusGone)
+		return
+	}
+
 	content, err := srv.config.Host(name)
-	if err != nil {
+	if os.IsNotExist(err) {
+		http.NotFound(writer, request)
+		return
+	} else if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	writer.Header().Set("X-Name", info.Name)
 	writer.Header().Set("X-Version", strconv.FormatInt(info.Version, 10))
+	if len(info.Signature) > 0 {
+		writer.Header().Set("X-Signature", base64.StdEncoding.EncodeToString(info.Signature))
+	}
 	writer.Header().Set("Content-Length", strconv.Itoa(len(content)))
 	writer.WriteHeader(http.StatusOK)
 	_, _ = writer.Write(content)
@@ -0,0 +1,242 @@
+package discovery_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+func TestSSD_Wait(t *testing.T) {
+	ssd := discovery.NewSSD("")
+	assert.Equal(t, "", ssd.Digest())
+	ssd.Replace(discovery.Entity{Name: "alpha", Version: 1})
+	digest := ssd.Digest()
+	assert.NotEmpty(t, digest)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, digest, ssd.Wait(ctx, digest), "nothing changed")
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ssd.ReplaceIfNewer(discovery.Entity{Name: "alpha", Version: 2}, nil)
+	}()
+	next := ssd.Wait(context.Background(), digest)
+	assert.NotEqual(t, digest, next)
+	assert.Equal(t, next, ssd.Digest())
+}
+
+func TestServer_LongPolling(t *testing.T) {
+	ssd := discovery.NewSSD("")
+	ssd.Replace(discovery.Entity{Name: "alpha", Version: 1})
+	srv := httptest.NewServer(discovery.NewServer(ssd, nil))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/hosts")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	digest := res.Header.Get("X-Digest")
+	assert.Equal(t, ssd.Digest(), digest)
+
+	started := time.Now()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ssd.Replace(discovery.Entity{Name: "beta", Version: 1})
+	}()
+	res, err = http.Get(srv.URL + "/hosts?since=" + digest)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, time.Since(started) >= 100*time.Millisecond, "request should wait for changes")
+	assert.NotEqual(t, digest, res.Header.Get("X-Digest"))
+}
+
+func testConfig(t *testing.T, name string) *daemon.Config {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "hosts"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tinc.conf"), []byte("Name = "+name+"\n"), 0755))
+	return daemon.Default(dir)
+}
+
+func TestServer_Batch(t *testing.T) {
+	dc := testConfig(t, "alpha")
+	require.NoError(t, dc.AddHost("alpha", []byte("Subnet = 10.0.0.1/32\n")))
+	require.NoError(t, dc.AddHost("beta", []byte("Subnet = 10.0.0.2/32\n")))
+
+	ssd := discovery.NewSSD("")
+	ssd.Replace(discovery.Entity{Name: "alpha", Version: 1})
+	ssd.Replace(discovery.Entity{Name: "beta", Version: 2})
+	ssd.Remove("gamma", "")
+	srv := httptest.NewServer(discovery.NewServer(ssd, dc))
+	defer srv.Close()
+
+	// conditional headers
+	res, err := http.Get(srv.URL + "/hosts")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	etag := res.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/hosts", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", etag)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	// compressed batch
+	data, err := json.Marshal(discovery.BatchRequest{Names: []string{"alpha", "beta", "gamma", "unknown"}})
+	require.NoError(t, err)
+	res, err = http.Post(srv.URL+"/hosts/batch", "application/json", bytes.NewReader(data))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.True(t, res.Uncompressed, "response should be compressed")
+	var records []discovery.Record
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&records))
+	require.Len(t, records, 3)
+	assert.Equal(t, "Subnet = 10.0.0.2/32\n", string(records[1].Content))
+	assert.Equal(t, int64(2), records[1].Version)
+	assert.True(t, records[2].Removed)
+	assert.Empty(t, records[2].Content)
+}
+
+func TestClient_BatchFallback(t *testing.T) {
+	for _, batch := range []bool{true, false} {
+		serverConfig := testConfig(t, "alpha")
+		require.NoError(t, serverConfig.AddHost("alpha", []byte("Subnet = 10.0.0.1/32\n")))
+		serverSSD := discovery.NewSSD("")
+		serverSSD.Replace(discovery.Entity{Name: "alpha", Version: 1})
+		handler := discovery.NewServer(serverSSD, serverConfig)
+		srv := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if !batch && request.URL.Path == "/hosts/batch" {
+				http.NotFound(writer, request) // old peer
+				return
+			}
+			handler.ServeHTTP(writer, request)
+		}))
+
+		clientConfig := testConfig(t, "beta")
+		clientSSD := discovery.NewSSD(filepath.Join(t.TempDir(), "discovery.json"))
+		client := discovery.NewClient(clientSSD, clientConfig, time.Second)
+		client.Watch(context.Background(), strings.TrimPrefix(srv.URL, "http://"))
+		assert.Eventually(t, func() bool {
+			_, err := clientConfig.Host("alpha")
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond, "batch: %v", batch)
+		client.Close()
+		srv.Close()
+	}
+}
+
+func TestClient_Poke(t *testing.T) {
+	serverConfig := testConfig(t, "alpha")
+	require.NoError(t, serverConfig.AddHost("alpha", []byte("Subnet = 10.0.0.1/32\n")))
+	serverSSD := discovery.NewSSD("")
+	serverSSD.Replace(discovery.Entity{Name: "alpha", Version: 1})
+	handler := discovery.NewServer(serverSSD, serverConfig)
+	var requests, ready int32
+	srv := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&ready) == 0 {
+			http.Error(writer, "not ready", http.StatusServiceUnavailable) // retried after interval or by announce
+			return
+		}
+		handler.ServeHTTP(writer, request)
+	}))
+	defer srv.Close()
+
+	clientConfig := testConfig(t, "beta")
+	client := discovery.NewClient(discovery.NewSSD(filepath.Join(t.TempDir(), "discovery.json")), clientConfig, time.Hour)
+	defer client.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+	client.Watch(context.Background(), address)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&requests) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	atomic.StoreInt32(&ready, 1)
+	client.Poke(address)
+	assert.Eventually(t, func() bool {
+		_, err := clientConfig.Host("alpha")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "poke should wake requester before interval")
+}
+
+func TestClient_UnsignedPinned(t *testing.T) {
+	pinnedKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	forgedKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	serverConfig := testConfig(t, "gamma")
+	require.NoError(t, serverConfig.AddHost("alpha", hostFile(t, forgedKey, "10.0.0.1/32")))
+	require.NoError(t, serverConfig.AddHost("beta", hostFile(t, forgedKey, "10.0.0.2/32")))
+	serverSSD := discovery.NewSSD("")
+	serverSSD.Replace(discovery.Entity{Name: "alpha", Version: 2}) // signature stripped
+	serverSSD.Replace(discovery.Entity{Name: "beta", Version: 2})
+	srv := httptest.NewServer(discovery.NewServer(serverSSD, serverConfig))
+	defer srv.Close()
+
+	clientConfig := testConfig(t, "delta")
+	pinned := hostFile(t, pinnedKey, "10.0.0.1/32")
+	require.NoError(t, clientConfig.AddHost("alpha", pinned))
+	clientSSD := discovery.NewSSD(filepath.Join(t.TempDir(), "discovery.json"))
+	clientSSD.Replace(discovery.Entity{Name: "alpha", Version: 1})
+	client := discovery.NewClient(clientSSD, clientConfig, time.Hour)
+	defer client.Close()
+	client.Watch(context.Background(), strings.TrimPrefix(srv.URL, "http://"))
+
+	require.Eventually(t, func() bool {
+		_, err := clientConfig.Host("beta")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "unsigned records are accepted on the first contact")
+	content, err := clientConfig.Host("alpha")
+	require.NoError(t, err)
+	assert.Equal(t, pinned, content, "unsigned record should not replace pinned key")
+	info, _ := clientSSD.Get("alpha")
+	assert.Equal(t, int64(1), info.Version)
+}
+
+func TestClient_FutureVersion(t *testing.T) {
+	serverConfig := testConfig(t, "gamma")
+	require.NoError(t, serverConfig.AddHost("alpha", []byte("Subnet = 10.0.0.1/32\n")))
+	require.NoError(t, serverConfig.AddHost("beta", []byte("Subnet = 10.0.0.2/32\n")))
+	serverSSD := discovery.NewSSD("")
+	serverSSD.Replace(discovery.Entity{Name: "alpha", Version: math.MaxInt64})
+	serverSSD.Replace(discovery.Entity{Name: "beta", Version: 1})
+	srv := httptest.NewServer(discovery.NewServer(serverSSD, serverConfig))
+	defer srv.Close()
+
+	clientConfig := testConfig(t, "delta")
+	clientSSD := discovery.NewSSD(filepath.Join(t.TempDir(), "discovery.json"))
+	clientSSD.SetSelf("delta")
+	client := discovery.NewClient(clientSSD, clientConfig, time.Hour)
+	defer client.Close()
+	client.Watch(context.Background(), strings.TrimPrefix(srv.URL, "http://"))
+
+	require.Eventually(t, func() bool {
+		_, err := clientConfig.Host("beta")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	_, ok := clientSSD.Get("alpha")
+	assert.False(t, ok, "record with huge version should be rejected")
+	assert.False(t, discovery.Ahead(clientSSD.Next()))
+}
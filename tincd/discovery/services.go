@@ -0,0 +1,172 @@
+package discovery
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/reddec/tinc-boot/tincd/ipam"
+)
+
+// Service declared by node in services.yaml.
+type Service struct {
+	Name     string   `yaml:"name"`
+	Port     uint16   `yaml:"port"`
+	Protocol string   `yaml:"protocol,omitempty"` // tcp (default) or udp
+	Tags     []string `yaml:"tags,omitempty" json:",omitempty"`
+}
+
+// Catalog of services of node. Distributed with own record and versioned the same way.
+type Catalog struct {
+	Services  []Service
+	Signature []byte `json:",omitempty"` // signature of owner node over name, version and services (see SignCatalog)
+}
+
+// ReadServices from YAML file (list of services). Missing file means no services.
+func ReadServices(file string) ([]Service, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var services []Service
+	if err := yaml.UnmarshalStrict(data, &services); err != nil {
+		return nil, fmt.Errorf("parse services: %w", err)
+	}
+	for i, service := range services {
+		if service.Name == "" || service.Port == 0 {
+			return nil, fmt.Errorf("service #%d: name and port are required", i+1)
+		}
+		switch service.Protocol {
+		case "":
+			services[i].Protocol = "tcp"
+		case "tcp", "udp":
+		default:
+			return nil, fmt.Errorf("service %s: unknown protocol %s", service.Name, service.Protocol)
+		}
+	}
+	return services, nil
+}
+
+// SignCatalog of node (name and version of own record) by node private key.
+func SignCatalog(key *rsa.PrivateKey, name string, version int64, services []Service) (*Catalog, error) {
+	payload, err := json.Marshal(services)
+	if err != nil {
+		return nil, err
+	}
+	digest := recordDigest(catalogDomain, name, version, payload)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return &Catalog{Services: services, Signature: signature}, nil
+}
+
+// VerifyCatalog of entity by the same key as record (see Verify). Entity without catalog is valid.
+func VerifyCatalog(entity Entity, content []byte, pinned []byte) error {
+	if entity.Catalog == nil {
+		return nil
+	}
+	if len(entity.Catalog.Signature) == 0 {
+		return ErrUnsigned
+	}
+	payload, err := json.Marshal(entity.Catalog.Services)
+	if err != nil {
+		return err
+	}
+	digest := recordDigest(catalogDomain, entity.Name, entity.Version, payload)
+	return verifyDigest(entity.Name, digest, entity.Catalog.Signature, keySource(content, pinned))
+}
+
+// keySource returns host file with public key used for verification: pinned one has priority.
+func keySource(content, pinned []byte) []byte {
+	if _, err := HostPublicKey(pinned); err == nil {
+		return pinned
+	}
+	return content
+}
+
+// ServiceEntry of services catalog: service with node and overlay address.
+type ServiceEntry struct {
+	Node    string
+	Address string // overlay IP of node (the first subnet of host file)
+	Service
+}
+
+// ServiceFilter for catalog queries. Empty fields match everything.
+type ServiceFilter struct {
+	Name string
+	Tag  string
+	Node string
+}
+
+func (sf ServiceFilter) match(node string, service Service) bool {
+	if sf.Node != "" && sf.Node != node {
+		return false
+	}
+	if sf.Name != "" && sf.Name != service.Name {
+		return false
+	}
+	if sf.Tag == "" {
+		return true
+	}
+	for _, tag := range service.Tags {
+		if tag == sf.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Services of all known nodes matched by filter, sorted by service name and node.
+func Services(ssd *SSD, store HostStore, filter ServiceFilter) []ServiceEntry {
+	var ans []ServiceEntry
+	for _, entity := range ssd.Header() {
+		if entity.Removed || entity.Catalog == nil {
+			continue
+		}
+		var address string
+		if content, err := store.Host(entity.Name); err == nil {
+			if subnets := ipam.HostSubnets(content); len(subnets) > 0 {
+				address = subnets[0].IP.String()
+			}
+		}
+		for _, service := range entity.Catalog.Services {
+			if filter.match(entity.Name, service) {
+				ans = append(ans, ServiceEntry{Node: entity.Name, Address: address, Service: service})
+			}
+		}
+	}
+	sort.SliceStable(ans, func(i, j int) bool {
+		if ans[i].Name != ans[j].Name {
+			return ans[i].Name < ans[j].Name
+		}
+		return ans[i].Node < ans[j].Node
+	})
+	return ans
+}
+
+// ServicesHandler of local API: GET /services?name=&tag=&node= returns catalog entries.
+func ServicesHandler(ssd *SSD, store HostStore) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		entries := Services(ssd, store, ServiceFilter{
+			Name: query.Get("name"),
+			Tag:  query.Get("tag"),
+			Node: query.Get("node"),
+		})
+		if entries == nil {
+			entries = []ServiceEntry{}
+		}
+		writeJSON(writer, request, entries)
+	})
+}
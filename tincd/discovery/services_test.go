@@ -0,0 +1,93 @@
+package discovery_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+func TestReadServices(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "services.yaml")
+	services, err := discovery.ReadServices(file)
+	require.NoError(t, err)
+	assert.Empty(t, services, "missing file means no services")
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+- name: postgres
+  port: 5432
+  tags: [db]
+- name: syslog
+  port: 514
+  protocol: udp
+`), 0644))
+	services, err = discovery.ReadServices(file)
+	require.NoError(t, err)
+	require.Len(t, services, 2)
+	assert.Equal(t, "tcp", services[0].Protocol)
+	assert.Equal(t, []string{"db"}, services[0].Tags)
+	assert.Equal(t, "udp", services[1].Protocol)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("- name: web\n  port: 80\n  protocol: sctp\n"), 0644))
+	_, err = discovery.ReadServices(file)
+	assert.Error(t, err)
+}
+
+func TestServices(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	content := hostFile(t, key, "10.0.0.1/32")
+	dc := testConfig(t, "alpha")
+	require.NoError(t, dc.AddHost("alpha", content))
+
+	catalog, err := discovery.SignCatalog(key, "alpha", 2, []discovery.Service{
+		{Name: "web", Port: 80, Protocol: "tcp", Tags: []string{"public"}},
+		{Name: "postgres", Port: 5432, Protocol: "tcp", Tags: []string{"db"}},
+	})
+	require.NoError(t, err)
+	entity := discovery.Entity{Name: "alpha", Version: 2, Catalog: catalog}
+	assert.NoError(t, discovery.VerifyCatalog(entity, content, nil))
+
+	changed := entity
+	changed.Version = 3
+	assert.Error(t, discovery.VerifyCatalog(changed, content, nil), "version is signed")
+	forged := *catalog
+	forged.Services = append(forged.Services, discovery.Service{Name: "ssh", Port: 22, Protocol: "tcp"})
+	changed = entity
+	changed.Catalog = &forged
+	assert.Error(t, discovery.VerifyCatalog(changed, content, nil), "services are signed")
+
+	// signatures of catalog and host record are not interchangeable
+	payload, err := json.Marshal(catalog.Services)
+	require.NoError(t, err)
+	asHost := discovery.Entity{Name: "alpha", Version: 2, Signature: catalog.Signature}
+	assert.Error(t, discovery.Verify(asHost, payload, content), "catalog signature is not host signature")
+	hostSignature, err := discovery.Sign(key, "alpha", 2, payload)
+	require.NoError(t, err)
+	changed = entity
+	changed.Catalog = &discovery.Catalog{Services: catalog.Services, Signature: hostSignature}
+	assert.Error(t, discovery.VerifyCatalog(changed, content, nil), "host signature is not catalog signature")
+
+	ssd := discovery.NewSSD("")
+	ssd.Replace(entity)
+	ssd.Replace(discovery.Entity{Name: "beta", Version: 1})
+
+	all := discovery.Services(ssd, dc, discovery.ServiceFilter{})
+	require.Len(t, all, 2)
+	assert.Equal(t, "postgres", all[0].Name)
+	assert.Equal(t, "alpha", all[0].Node)
+	assert.Equal(t, "10.0.0.1", all[0].Address)
+
+	tagged := discovery.Services(ssd, dc, discovery.ServiceFilter{Tag: "public"})
+	require.Len(t, tagged, 1)
+	assert.Equal(t, "web", tagged[0].Name)
+
+	assert.Empty(t, discovery.Services(ssd, dc, discovery.ServiceFilter{Node: "beta"}))
+}
@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
+	"github.com/reddec/tinc-boot/tincd/config"
+)
+
+// ErrUnsigned record: no signature attached.
+var ErrUnsigned = errors.New("record not signed")
+
+// Signature domains: signature of one kind of record can't be presented as another one.
+const (
+	hostDomain    = "host"
+	catalogDomain = "catalog"
+)
+
+// Sign host record (name, version and content hash) by node private key.
+func Sign(key *rsa.PrivateKey, name string, version int64, content []byte) ([]byte, error) {
+	digest := recordDigest(hostDomain, name, version, content)
+	return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+}
+
+// Verify signature of host record. Public key from pinned (already known) host file has priority,
+// key inside content used for the first contact (pinned is empty or has no key).
+func Verify(entity Entity, content []byte, pinned []byte) error {
+	if len(entity.Signature) == 0 {
+		return ErrUnsigned
+	}
+	digest := recordDigest(hostDomain, entity.Name, entity.Version, content)
+	return verifyDigest(entity.Name, digest, entity.Signature, keySource(content, pinned))
+}
+
+// VerifyRecord signature of host record like Verify, but unsigned records are accepted (if not strict) only on the
+// first contact: once key of node is pinned, records without signature could be forged by any peer.
+func VerifyRecord(entity Entity, content []byte, pinned []byte, strict bool) error {
+	err := Verify(entity, content, pinned)
+	if !errors.Is(err, ErrUnsigned) || strict {
+		return err
+	}
+	if config.KeyFingerprint(pinned) != "" {
+		return fmt.Errorf("unsigned record of %s with pinned key: %w", entity.Name, err)
+	}
+	return nil
+}
+
+// SignDecision of network administrator: tombstone or re-admission is bound to name, version and removed key.
+func SignDecision(admin *authority.Authority, entity *Entity) {
+	entity.Decided = entity.Version
+	entity.Decision = admin.Sign(decisionPayload(*entity))
+}
+
+// VerifyDecision (removal or re-admission) carried by entity against keys of network administrators.
+func VerifyDecision(entity Entity, admins []authority.PublicKey) error {
+	if len(entity.Decision) == 0 {
+		return ErrUnsigned
+	}
+	if entity.Removed && entity.Decided != entity.Version || entity.Decided > entity.Version {
+		return fmt.Errorf("decision about %s signed for version %d", entity.Name, entity.Decided)
+	}
+	payload := decisionPayload(entity)
+	for _, key := range admins {
+		if key.Signed(payload, entity.Decision) {
+			return nil
+		}
+	}
+	return fmt.Errorf("decision about %s is not signed by administrator", entity.Name)
+}
+
+func decisionPayload(entity Entity) []byte {
+	action := "admit"
+	if entity.Removed {
+		action = "remove " + entity.Key
+	}
+	return []byte("decision\n" + entity.Name + "\n" + strconv.FormatInt(entity.Decided, 10) + "\n" + action)
+}
+
+// ReadPrivateKey of node (rsa_key.priv generated by tincd).
+func ReadPrivateKey(file string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// HostPublicKey parses RSA public key from host file.
+func HostPublicKey(content []byte) (*rsa.PublicKey, error) {
+	var node config.Node
+	if err := config.Unmarshal(content, &node); err != nil {
+		return nil, fmt.Errorf("parse host file: %w", err)
+	}
+	block, _ := pem.Decode([]byte(node.PublicKey))
+	if block == nil {
+		return nil, fmt.Errorf("no public key in host file")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// verifyDigest signed by key from host file.
+func verifyDigest(name string, digest [sha256.Size]byte, signature []byte, host []byte) error {
+	key, err := HostPublicKey(host)
+	if err != nil {
+		return err
+	}
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return fmt.Errorf("invalid signature of %s: %w", name, err)
+	}
+	return nil
+}
+
+func recordDigest(domain string, name string, version int64, content []byte) [sha256.Size]byte {
+	contentHash := sha256.Sum256(content)
+	return sha256.Sum256([]byte(domain + "\n" + name + "\n" + strconv.FormatInt(version, 10) + "\n" + hex.EncodeToString(contentHash[:])))
+}
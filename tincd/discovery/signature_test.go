@@ -0,0 +1,56 @@
+package discovery_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/config"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+func hostFile(t *testing.T, key *rsa.PrivateKey, subnet string) []byte {
+	pub := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	data, err := config.Marshal(config.Node{Subnet: []string{subnet}})
+	require.NoError(t, err)
+	return append(data, pub...) // as tincd -K appends key
+}
+
+func TestSignVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	content := hostFile(t, key, "10.0.0.1/32")
+	entity := discovery.Entity{Name: "alpha", Version: 3}
+	assert.Equal(t, discovery.ErrUnsigned, discovery.Verify(entity, content, nil))
+
+	entity.Signature, err = discovery.Sign(key, entity.Name, entity.Version, content)
+	require.NoError(t, err)
+	assert.NoError(t, discovery.Verify(entity, content, nil), "first contact")
+	assert.NoError(t, discovery.Verify(entity, content, content), "pinned")
+
+	changed := entity
+	changed.Version = 4
+	assert.Error(t, discovery.Verify(changed, content, nil), "version is signed")
+	assert.Error(t, discovery.Verify(entity, hostFile(t, key, "10.0.0.2/32"), nil), "content is signed")
+
+	// attacker replaces key and content: pinned key wins
+	forged := hostFile(t, other, "10.0.0.1/32")
+	entity.Signature, err = discovery.Sign(other, entity.Name, entity.Version, forged)
+	require.NoError(t, err)
+	assert.NoError(t, discovery.Verify(entity, forged, nil))
+	assert.Error(t, discovery.Verify(entity, forged, content))
+
+	// unsigned records only on the first contact
+	unsigned := discovery.Entity{Name: "alpha", Version: 5}
+	assert.NoError(t, discovery.VerifyRecord(unsigned, forged, nil, false))
+	assert.Error(t, discovery.VerifyRecord(unsigned, forged, nil, true), "strict")
+	assert.Error(t, discovery.VerifyRecord(unsigned, forged, content, false), "pinned")
+}
@@ -1,21 +1,50 @@
 package discovery
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/reddec/tinc-boot/tincd/authority"
 )
 
 // Single Source Distribution
 // - name
-// - version (must always grow)
+// - version (must always grow): hybrid logical clock of owner node (see Next)
+//
+// Removed nodes are kept as tombstones: they can't be imported again (by name or by key)
+// until explicit re-admission.
 
 type Entity struct {
-	Name    string
-	Version int64
+	Name     string
+	Version  int64
+	Removed  bool   `json:",omitempty"` // tombstone of removed node
+	Key      string `json:",omitempty"` // fingerprint of removed node key (tombstones only)
+	Admitted bool   `json:",omitempty"` // node re-admitted after removal
+	Decided  int64  `json:",omitempty"` // version of administrative decision (removal or re-admission), kept after join
+	Decision []byte `json:",omitempty"` // signature of network administrator over decision (see SignDecision)
+
+	Signature []byte               `json:",omitempty"` // signature of owner node over name, version and content hash
+	Admission *authority.Admission `json:",omitempty"` // admission certificate of node (authority mode)
+	Catalog   *Catalog             `json:",omitempty"` // services declared by node
+
+	Seen time.Time `json:"-"` // local only: last time node was seen alive (see SSD.Touch), not part of digest
+}
+
+// storedEntity in SSD file: entity with local last seen time which is never sent to peers.
+type storedEntity struct {
+	Entity
+	Seen time.Time
 }
 
 func NewSSD(filename string) *SSD {
@@ -27,44 +56,302 @@ func NewSSD(filename string) *SSD {
 type SSD struct {
 	lock     sync.RWMutex
 	entities map[string]Entity
+	changed  chan struct{} // closed and replaced on each change
+	clock    int64         // the highest known version of own record (see Next)
+	self     string        // name of own node (see SetSelf)
 	file     string
 	fileLock sync.Mutex
 }
 
-func (ssd *SSD) unsafeIsNewer(name string, desired int64) bool {
-	old, exists := ssd.entities[name]
-	return !exists || desired > old.Version
+// Digest of current state: names and versions of all entities. Empty SSD has empty digest.
+func (ssd *SSD) Digest() string {
+	ssd.lock.RLock()
+	defer ssd.lock.RUnlock()
+	return ssd.unsafeDigest()
+}
+
+// Wait till digest of SSD differs from provided one or context canceled. Returns current digest.
+func (ssd *SSD) Wait(ctx context.Context, digest string) string {
+	for {
+		ssd.lock.Lock()
+		current := ssd.unsafeDigest()
+		if current != digest {
+			ssd.lock.Unlock()
+			return current
+		}
+		if ssd.changed == nil {
+			ssd.changed = make(chan struct{})
+		}
+		changed := ssd.changed
+		ssd.lock.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return current
+		}
+	}
 }
 
-func (ssd *SSD) CanBeMerged(entity Entity) bool {
+// Buckets digest of SSD for anti-entropy: entities are distributed to n buckets by hash of name, each bucket
+// is represented by hash of sorted names and versions (empty string for empty bucket).
+// Entities in differing buckets of two nodes are differing entities.
+func (ssd *SSD) Buckets(n int) []string {
 	ssd.lock.RLock()
 	defer ssd.lock.RUnlock()
-	return ssd.unsafeIsNewer(entity.Name, entity.Version)
+	var names = make([][]string, n)
+	for name := range ssd.entities {
+		idx := bucketOf(name, n)
+		names[idx] = append(names[idx], name)
+	}
+	var ans = make([]string, n)
+	for idx, bucket := range names {
+		if len(bucket) == 0 {
+			continue
+		}
+		sort.Strings(bucket)
+		hash := sha256.New()
+		for _, name := range bucket {
+			_, _ = fmt.Fprintf(hash, "%s\n%d\n", name, ssd.entities[name].Version)
+		}
+		ans[idx] = hex.EncodeToString(hash.Sum(nil))[:16]
+	}
+	return ans
 }
 
-func (ssd *SSD) ReplaceIfNewer(entity Entity, block func() bool) bool {
+func bucketOf(name string, n int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	return int(hash.Sum32() % uint32(n))
+}
+
+func (ssd *SSD) unsafeDigest() string {
+	if len(ssd.entities) == 0 {
+		return ""
+	}
+	var names = make([]string, 0, len(ssd.entities))
+	for name := range ssd.entities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		_, _ = fmt.Fprintf(hash, "%s\n%d\n", name, ssd.entities[name].Version)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// MaxClockSkew of versions ahead of wall clock. Records from the further future are never accepted: clocks of
+// nodes would be stuck there.
+const MaxClockSkew = 24 * time.Hour
+
+// Ahead checks that version is too far ahead of wall clock (see MaxClockSkew).
+func Ahead(version int64) bool {
+	return version > time.Now().Add(MaxClockSkew).UnixNano()/int64(time.Millisecond)
+}
+
+// SetSelf name of own node: only versions of own record (stored or observed) move the clock.
+func (ssd *SSD) SetSelf(name string) {
 	ssd.lock.Lock()
 	defer ssd.lock.Unlock()
+	ssd.self = name
+	ssd.unsafeObserve(ssd.entities[name].Version)
+}
+
+// Next version for own record by hybrid logical clock: wall time in milliseconds or next after the highest known
+// version of own record if wall clock is behind (clock moved backward, restored backup). Versions are never repeated.
+func (ssd *SSD) Next() int64 {
+	ssd.lock.Lock()
+	defer ssd.lock.Unlock()
+	version := time.Now().UnixNano() / int64(time.Millisecond)
+	if version <= ssd.clock {
+		version = ssd.clock + 1
+	}
+	ssd.clock = version
+	return version
+}
+
+// Observe version from outside (ex: own record known by peers) to keep the clock ahead of it.
+func (ssd *SSD) Observe(version int64) {
+	ssd.lock.Lock()
+	defer ssd.lock.Unlock()
+	ssd.unsafeObserve(version)
+}
+
+func (ssd *SSD) unsafeObserve(version int64) {
+	if version > ssd.clock && !Ahead(version) {
+		ssd.clock = version
+	}
+}
+
+// unsafeStore entity. New version means that node was alive recently, so last seen time is reset.
+func (ssd *SSD) unsafeStore(entity Entity) {
 	if ssd.entities == nil {
 		ssd.entities = make(map[string]Entity)
 	}
-	if !ssd.unsafeIsNewer(entity.Name, entity.Version) {
+	entity.Seen = time.Now()
+	ssd.entities[entity.Name] = entity
+	if ssd.self != "" && entity.Name == ssd.self {
+		ssd.unsafeObserve(entity.Version)
+	}
+	ssd.unsafeNotify()
+}
+
+func (ssd *SSD) unsafeNotify() {
+	if ssd.changed != nil {
+		close(ssd.changed)
+		ssd.changed = nil
+	}
+}
+
+func (ssd *SSD) unsafeIsNewer(entity Entity) bool {
+	old, exists := ssd.entities[entity.Name]
+	if !exists {
+		return true
+	}
+	if old.Removed && !entity.Removed && !entity.Admitted {
+		return false // removed node can be returned only by re-admission
+	}
+	return entity.Version > old.Version
+}
+
+func (ssd *SSD) CanBeMerged(entity Entity) bool {
+	ssd.lock.RLock()
+	defer ssd.lock.RUnlock()
+	return ssd.unsafeIsNewer(entity)
+}
+
+func (ssd *SSD) ReplaceIfNewer(entity Entity, block func() bool) bool {
+	ssd.lock.Lock()
+	defer ssd.lock.Unlock()
+	if !ssd.unsafeIsNewer(entity) {
 		return false
 	}
 	if block != nil && !block() {
 		return false
 	}
-	ssd.entities[entity.Name] = entity
+	ssd.unsafeStore(entity)
 	return true
 }
 
 func (ssd *SSD) Replace(entity Entity) {
 	ssd.lock.Lock()
 	defer ssd.lock.Unlock()
-	if ssd.entities == nil {
-		ssd.entities = make(map[string]Entity)
+	ssd.unsafeStore(entity)
+}
+
+// Tombstone for removal of node with next version. Key is fingerprint of node key. Not stored: decision
+// should be signed by administrator (see SignDecision) and stored by ReplaceIfNewer.
+func (ssd *SSD) Tombstone(name string, key string) Entity {
+	ssd.lock.RLock()
+	defer ssd.lock.RUnlock()
+	return Entity{
+		Name:    name,
+		Version: ssd.entities[name].Version + 1,
+		Removed: true,
+		Key:     key,
 	}
-	ssd.entities[entity.Name] = entity
+}
+
+// Readmission of removed node with next version. Returns false if node is not removed. Not stored (see Tombstone).
+func (ssd *SSD) Readmission(name string) (Entity, bool) {
+	ssd.lock.RLock()
+	defer ssd.lock.RUnlock()
+	old, ok := ssd.entities[name]
+	if !ok || !old.Removed {
+		return old, false
+	}
+	return Entity{
+		Name:     name,
+		Version:  old.Version + 1,
+		Admitted: true,
+	}, true
+}
+
+// Remove node: tombstone with next version replaces entity.
+func (ssd *SSD) Remove(name string, key string) Entity {
+	tombstone := ssd.Tombstone(name, key)
+	ssd.Replace(tombstone)
+	return tombstone
+}
+
+// Admit removed node again. Returns false if node is not removed.
+func (ssd *SSD) Admit(name string) (Entity, bool) {
+	admitted, ok := ssd.Readmission(name)
+	if ok {
+		ssd.Replace(admitted)
+	}
+	return admitted, ok
+}
+
+// Joined returns entity for node which joined with provided version of host file. Re-admitted nodes keep
+// admission mark and version above admission, so tombstones are overridden everywhere.
+// Returns false if node is removed.
+func (ssd *SSD) Joined(name string, version int64) (Entity, bool) {
+	ssd.lock.RLock()
+	defer ssd.lock.RUnlock()
+	old, ok := ssd.entities[name]
+	if !ok {
+		return Entity{Name: name, Version: version}, true
+	}
+	if old.Removed {
+		return old, false
+	}
+	if old.Admitted && version <= old.Version {
+		version = old.Version + 1
+	}
+	return Entity{Name: name, Version: version, Admitted: old.Admitted, Decided: old.Decided, Decision: old.Decision}, true
+}
+
+// Touch node: mark it as seen alive at the moment. Unknown nodes are ignored.
+func (ssd *SSD) Touch(name string, at time.Time) {
+	ssd.lock.Lock()
+	defer ssd.lock.Unlock()
+	entity, ok := ssd.entities[name]
+	if !ok || !at.After(entity.Seen) {
+		return
+	}
+	entity.Seen = at
+	ssd.entities[name] = entity
+}
+
+// Stale nodes (except self and removed) which were not seen since the moment. Sorted by name.
+func (ssd *SSD) Stale(self string, since time.Time) []Entity {
+	var ans []Entity
+	for _, entity := range ssd.Header() {
+		if entity.Name != self && !entity.Removed && entity.Seen.Before(since) {
+			ans = append(ans, entity)
+		}
+	}
+	return ans
+}
+
+// Removed node or not.
+func (ssd *SSD) Removed(name string) bool {
+	ssd.lock.RLock()
+	defer ssd.lock.RUnlock()
+	return ssd.entities[name].Removed
+}
+
+// RemovedKey finds removed node by fingerprint of key. Empty key is never removed.
+func (ssd *SSD) RemovedKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	ssd.lock.RLock()
+	defer ssd.lock.RUnlock()
+	for _, entity := range ssd.entities {
+		if entity.Removed && entity.Key == key {
+			return entity.Name, true
+		}
+	}
+	return "", false
+}
+
+func (ssd *SSD) Get(name string) (Entity, bool) {
+	ssd.lock.RLock()
+	defer ssd.lock.RUnlock()
+	old, ok := ssd.entities[name]
+	return old, ok
 }
 
 func (ssd *SSD) GetAfter(name string, version int64) (Entity, bool) {
@@ -84,6 +371,9 @@ func (ssd *SSD) Header() []Entity {
 	for _, v := range ssd.entities {
 		ans = append(ans, v)
 	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].Name < ans[j].Name
+	})
 	return ans
 }
 
@@ -92,21 +382,35 @@ func (ssd *SSD) Marshal(writer io.Writer) error {
 	defer ssd.lock.RUnlock()
 
 	items := ssd.Header() // double RLock, but it's ok
+	var stored = make([]storedEntity, 0, len(items))
+	for _, item := range items {
+		stored = append(stored, storedEntity{Entity: item, Seen: item.Seen})
+	}
 
 	enc := json.NewEncoder(writer)
 	enc.SetIndent("", " ")
-	return enc.Encode(items)
+	return enc.Encode(stored)
 }
 
 func (ssd *SSD) Unmarshal(reader io.Reader) error {
-	var items []Entity
+	var items []storedEntity
 	err := json.NewDecoder(reader).Decode(&items)
 	if err != nil {
 		return err
 	}
 
 	var data = make(map[string]Entity)
-	for _, v := range items {
+	now := time.Now()
+	for _, item := range items {
+		v := item.Entity
+		v.Seen = item.Seen
+		if Ahead(v.Version) {
+			log.Println("dropped record of", v.Name, "with version from the future", v.Version)
+			continue
+		}
+		if v.Seen.IsZero() {
+			v.Seen = now // saved by old version: grace period
+		}
 		data[v.Name] = v
 	}
 
@@ -114,6 +418,10 @@ func (ssd *SSD) Unmarshal(reader io.Reader) error {
 	defer ssd.lock.Unlock()
 
 	ssd.entities = data
+	if ssd.self != "" {
+		ssd.unsafeObserve(data[ssd.self].Version)
+	}
+	ssd.unsafeNotify()
 	return nil
 }
 
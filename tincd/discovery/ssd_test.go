@@ -0,0 +1,153 @@
+package discovery_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/discovery"
+)
+
+func TestSSD_Remove(t *testing.T) {
+	ssd := discovery.NewSSD("")
+	ssd.Replace(discovery.Entity{Name: "alpha", Version: 5})
+
+	tombstone := ssd.Remove("alpha", "abcd")
+	assert.True(t, tombstone.Removed)
+	assert.Equal(t, int64(6), tombstone.Version)
+
+	assert.False(t, ssd.CanBeMerged(discovery.Entity{Name: "alpha", Version: 100}), "removed node should not be imported")
+	name, removed := ssd.RemovedKey("abcd")
+	assert.True(t, removed)
+	assert.Equal(t, "alpha", name)
+	_, ok := ssd.Joined("alpha", 100)
+	assert.False(t, ok)
+
+	admitted, ok := ssd.Admit("alpha")
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), admitted.Version)
+	assert.False(t, ssd.Removed("alpha"))
+
+	joined, ok := ssd.Joined("alpha", 2)
+	assert.True(t, ok)
+	assert.True(t, joined.Admitted)
+	assert.Equal(t, int64(8), joined.Version, "re-admitted node should override tombstone version")
+
+	// other nodes with tombstone accept re-admitted node
+	other := discovery.NewSSD("")
+	other.Remove("alpha", "abcd")
+	assert.True(t, other.ReplaceIfNewer(joined, nil))
+}
+
+func TestSSD_Next(t *testing.T) {
+	ssd := discovery.NewSSD("")
+	first := ssd.Next()
+	assert.True(t, first > 1000000000000, "wall clock in milliseconds")
+	assert.True(t, ssd.Next() > first, "versions are never repeated")
+
+	// own record from the future (restored backup, clock moved backward)
+	future := first + 1000000
+	ssd.Observe(future)
+	assert.Equal(t, future+1, ssd.Next())
+
+	// loaded state: only own record moves the clock
+	loaded := discovery.NewSSD("")
+	loaded.Replace(discovery.Entity{Name: "alpha", Version: future + 10})
+	loaded.Replace(discovery.Entity{Name: "beta", Version: future + 100})
+	loaded.SetSelf("alpha")
+	assert.Equal(t, future+11, loaded.Next())
+	loaded.Replace(discovery.Entity{Name: "gamma", Version: future + 200})
+	assert.Equal(t, future+12, loaded.Next())
+}
+
+func TestSSD_FutureVersion(t *testing.T) {
+	ssd := discovery.NewSSD("")
+	ssd.SetSelf("self")
+	ssd.Observe(math.MaxInt64)
+	ssd.Replace(discovery.Entity{Name: "self", Version: math.MaxInt64 - 1})
+	next := ssd.Next()
+	assert.True(t, next > 0 && !discovery.Ahead(next), "clock should not follow versions from the future")
+	assert.True(t, discovery.Ahead(math.MaxInt64))
+
+	saved, err := json.Marshal([]discovery.Entity{
+		{Name: "alpha", Version: 1},
+		{Name: "beta", Version: math.MaxInt64},
+	})
+	require.NoError(t, err)
+	loaded := discovery.NewSSD("")
+	require.NoError(t, loaded.Unmarshal(bytes.NewReader(saved)))
+	_, ok := loaded.Get("beta")
+	assert.False(t, ok, "record from the future should be dropped")
+	_, ok = loaded.Get("alpha")
+	assert.True(t, ok)
+}
+
+func TestSSD_Stale(t *testing.T) {
+	ssd := discovery.NewSSD("")
+	ssd.Replace(discovery.Entity{Name: "self", Version: 1})
+	ssd.Replace(discovery.Entity{Name: "alpha", Version: 1})
+	ssd.Replace(discovery.Entity{Name: "beta", Version: 1})
+	ssd.Remove("beta", "")
+	digest := ssd.Digest()
+
+	future := time.Now().Add(time.Hour)
+	stale := ssd.Stale("self", future)
+	assert.Len(t, stale, 1, "self and removed nodes are never stale")
+	assert.Equal(t, "alpha", stale[0].Name)
+
+	ssd.Touch("alpha", future.Add(time.Minute))
+	assert.Empty(t, ssd.Stale("self", future))
+	assert.Equal(t, digest, ssd.Digest(), "last seen is not part of digest")
+
+	retention := discovery.Retention{Period: time.Hour}
+	alive := retention.Alive(ssd, "self")
+	assert.True(t, alive("alpha"))
+	assert.True(t, alive("unknown"))
+	assert.Empty(t, retention.Dead(ssd, "self", time.Now()))
+	assert.Len(t, retention.Dead(ssd, "self", future.Add(2*time.Hour)), 1)
+}
+
+func TestSSD_Seen(t *testing.T) {
+	seen := time.Now().Add(time.Hour).Round(time.Second)
+	ssd := discovery.NewSSD("")
+	ssd.Replace(discovery.Entity{Name: "alpha", Version: 1})
+	ssd.Touch("alpha", seen)
+	entity, _ := ssd.Get("alpha")
+	wire, err := json.Marshal(entity)
+	require.NoError(t, err)
+	assert.NotContains(t, string(wire), "Seen", "last seen is local only")
+
+	var saved bytes.Buffer
+	require.NoError(t, ssd.Marshal(&saved))
+	loaded := discovery.NewSSD("")
+	require.NoError(t, loaded.Unmarshal(&saved))
+	entity, _ = loaded.Get("alpha")
+	assert.True(t, seen.Equal(entity.Seen), "last seen should survive restart")
+}
+
+func TestRetention_Outage(t *testing.T) {
+	now := time.Now()
+	seen := now.Add(-48 * time.Hour).Format(time.RFC3339Nano) // self was down for two days
+	saved := `[{"Name": "self", "Version": 1, "Seen": "` + seen + `"}, {"Name": "alpha", "Version": 1, "Seen": "` + seen + `"}]`
+	ssd := discovery.NewSSD("")
+	require.NoError(t, ssd.Unmarshal(strings.NewReader(saved)))
+
+	outdated := discovery.Retention{Period: time.Hour}
+	assert.False(t, outdated.Alive(ssd, "self")("alpha"))
+	assert.Len(t, outdated.Dead(ssd, "self", now), 1)
+
+	retention := discovery.Retention{Period: time.Hour, Started: now}
+	assert.True(t, retention.Alive(ssd, "self")("alpha"), "peers should be alive after own outage")
+	assert.Empty(t, retention.Dead(ssd, "self", now))
+	assert.Empty(t, retention.Dead(ssd, "self", now.Add(59*time.Minute)), "no pruning during grace period")
+	assert.Len(t, retention.Dead(ssd, "self", now.Add(61*time.Minute)), 1, "not seen after start")
+
+	ssd.Touch("alpha", now.Add(30*time.Minute))
+	assert.Empty(t, retention.Dead(ssd, "self", now.Add(61*time.Minute)))
+}
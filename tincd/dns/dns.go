@@ -0,0 +1,420 @@
+// Package dns implements DNS server of overlay network: nodes are resolved as <name>.<zone> (A/AAAA from
+// subnets of host files), services from catalogs as _<service>._<proto>[.<name>].<zone> (SRV), everything
+// else is forwarded to upstream resolvers.
+package dns
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/reddec/tinc-boot/tincd/ipam"
+)
+
+// Port of DNS server on overlay IP.
+const Port = "53"
+
+// MaxUDPSize of reply without EDNS (RFC 1035). Larger replies are truncated and should be retried over TCP.
+const MaxUDPSize = 512
+
+// ResolvConf is location of system resolvers used as upstream by default.
+const ResolvConf = "/etc/resolv.conf"
+
+// New DNS server of zone (ex: office.vpn) by host files of config and services catalogs from SSD.
+func New(zone string, ssd *discovery.SSD, config *daemon.Config) *Server {
+	return &Server{
+		TTL:    60,
+		zone:   strings.ToLower(strings.Trim(zone, ".")) + ".",
+		ssd:    ssd,
+		config: config,
+	}
+}
+
+// Server of overlay zone. Started on overlay IP once daemon configured and stopped with daemon.
+type Server struct {
+	Upstream []string // resolvers (host:port) for names outside of zone. If not set - nameservers from ResolvConf
+	TTL      uint32   // TTL of answers in zone
+	zone     string
+	ssd      *discovery.SSD
+	config   *daemon.Config
+	running  struct {
+		lock     sync.Mutex
+		udp      net.PacketConn
+		tcp      net.Listener
+		upstream []string
+		done     sync.WaitGroup
+	}
+}
+
+// Zone of server as fully qualified name (with trailing dot).
+func (srv *Server) Zone() string {
+	return srv.zone
+}
+
+func (srv *Server) Configured(payload daemon.Configuration) {
+	address := net.JoinHostPort(payload.IP, Port)
+	upstream := srv.Upstream
+	if len(upstream) == 0 {
+		system, err := SystemResolvers(ResolvConf)
+		if err != nil {
+			log.Println("failed read system resolvers:", err)
+		}
+		for _, resolver := range system {
+			if host, _, _ := net.SplitHostPort(resolver); host != payload.IP {
+				upstream = append(upstream, resolver)
+			}
+		}
+	}
+	udp, err := net.ListenPacket("udp", address)
+	if err != nil {
+		log.Println("failed start DNS server:", err)
+		return
+	}
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		_ = udp.Close()
+		log.Println("failed start DNS server:", err)
+		return
+	}
+	srv.running.lock.Lock()
+	defer srv.running.lock.Unlock()
+	srv.running.udp = udp
+	srv.running.tcp = tcp
+	srv.running.upstream = upstream
+	srv.running.done.Add(2)
+	go func() {
+		defer srv.running.done.Done()
+		srv.serveUDP(udp)
+	}()
+	go func() {
+		defer srv.running.done.Done()
+		srv.serveTCP(tcp)
+	}()
+	log.Println("DNS server of zone", srv.zone, "started on", address)
+}
+
+func (srv *Server) Stopped(payload daemon.Configuration) {
+	srv.running.lock.Lock()
+	if srv.running.udp != nil {
+		_ = srv.running.udp.Close()
+		_ = srv.running.tcp.Close()
+		srv.running.udp = nil
+		srv.running.tcp = nil
+	}
+	srv.running.lock.Unlock()
+	srv.running.done.Wait()
+}
+
+func (srv *Server) SubnetAdded(payload daemon.EventSubnetAdded) {}
+
+func (srv *Server) SubnetRemoved(payload daemon.EventSubnetRemoved) {}
+
+func (srv *Server) Ready(payload daemon.EventReady) {}
+
+// Answer to DNS query: names in zone are resolved locally, others are forwarded to upstream
+// over the same network (udp or tcp).
+func (srv *Server) Answer(ctx context.Context, network string, query []byte) ([]byte, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	if msg.Response || len(msg.Questions) != 1 {
+		return srv.reply(msg, dnsmessage.RCodeFormatError, nil, nil)
+	}
+	question := msg.Questions[0]
+	name := strings.ToLower(question.Name.String())
+	if name != srv.zone && !strings.HasSuffix(name, "."+srv.zone) {
+		reply, err := srv.forward(ctx, network, query)
+		if err != nil {
+			log.Println("failed forward DNS query", name, ":", err)
+			return srv.reply(msg, dnsmessage.RCodeServerFailure, nil, nil)
+		}
+		return reply, nil
+	}
+	answers, additionals, found := srv.resolve(question, strings.TrimSuffix(strings.TrimSuffix(name, srv.zone), "."))
+	if !found {
+		return srv.reply(msg, dnsmessage.RCodeNameError, nil, nil)
+	}
+	reply, err := srv.reply(msg, dnsmessage.RCodeSuccess, answers, additionals)
+	if err != nil || network != "udp" || len(reply) <= udpSize(msg) {
+		return reply, err
+	}
+	// additional records are optional, without answers client should retry over TCP
+	reply, err = srv.reply(msg, dnsmessage.RCodeSuccess, answers, nil)
+	if err != nil || len(reply) <= udpSize(msg) {
+		return reply, err
+	}
+	truncated := srv.message(msg, dnsmessage.RCodeSuccess, nil, nil)
+	truncated.Truncated = true
+	return truncated.Pack()
+}
+
+// udpSize of reply supported by client: advertised by EDNS or MaxUDPSize.
+func udpSize(query dnsmessage.Message) int {
+	for _, resource := range query.Additionals {
+		if resource.Header.Type == dnsmessage.TypeOPT && int(resource.Header.Class) > MaxUDPSize {
+			return int(resource.Header.Class) // OPT class is UDP payload size
+		}
+	}
+	return MaxUDPSize
+}
+
+// resolve name relative to zone: <node>, _<service>._<proto> or _<service>._<proto>.<node>.
+func (srv *Server) resolve(question dnsmessage.Question, relative string) (answers, additionals []dnsmessage.Resource, found bool) {
+	if relative == "" {
+		return nil, nil, true // zone apex
+	}
+	labels := strings.Split(relative, ".")
+	switch {
+	case len(labels) == 1:
+		node, ok := srv.node(labels[0])
+		if !ok {
+			return nil, nil, false
+		}
+		return srv.addresses(question.Name, node, question.Type), nil, true
+	case (len(labels) == 2 || len(labels) == 3) && strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_"):
+		filter := discovery.ServiceFilter{Name: labels[0][1:]}
+		if len(labels) == 3 {
+			node, ok := srv.node(labels[2])
+			if !ok {
+				return nil, nil, false
+			}
+			filter.Node = node
+		}
+		var targets = make(map[string]bool)
+		for _, entry := range discovery.Services(srv.ssd, srv.config, filter) {
+			if entry.Protocol != labels[1][1:] {
+				continue
+			}
+			found = true
+			target, err := dnsmessage.NewName(strings.ToLower(entry.Node) + "." + srv.zone)
+			if err != nil {
+				continue
+			}
+			if question.Type == dnsmessage.TypeSRV || question.Type == dnsmessage.TypeALL {
+				answers = append(answers, dnsmessage.Resource{
+					Header: srv.header(question.Name),
+					Body:   &dnsmessage.SRVResource{Target: target, Port: entry.Port},
+				})
+			}
+			if !targets[entry.Node] {
+				targets[entry.Node] = true
+				additionals = append(additionals, srv.addresses(target, entry.Node, dnsmessage.TypeALL)...)
+			}
+		}
+		return answers, additionals, found
+	default:
+		return nil, nil, false
+	}
+}
+
+// node name by case-insensitive DNS label. Removed nodes are not resolved.
+func (srv *Server) node(label string) (string, bool) {
+	names, err := srv.config.HostNames()
+	if err != nil {
+		log.Println("failed list hosts:", err)
+		return "", false
+	}
+	for _, name := range names {
+		if strings.EqualFold(name, label) && !srv.ssd.Removed(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// addresses of node (host subnets of host file) as A/AAAA records.
+func (srv *Server) addresses(name dnsmessage.Name, node string, qtype dnsmessage.Type) []dnsmessage.Resource {
+	content, err := srv.config.Host(node)
+	if err != nil {
+		return nil
+	}
+	var ans []dnsmessage.Resource
+	for _, ip := range ipam.HostAddresses(content) {
+		if ip4 := ip.To4(); ip4 != nil && (qtype == dnsmessage.TypeA || qtype == dnsmessage.TypeALL) {
+			var a dnsmessage.AResource
+			copy(a.A[:], ip4)
+			ans = append(ans, dnsmessage.Resource{Header: srv.header(name), Body: &a})
+		} else if ip4 == nil && (qtype == dnsmessage.TypeAAAA || qtype == dnsmessage.TypeALL) {
+			var aaaa dnsmessage.AAAAResource
+			copy(aaaa.AAAA[:], ip)
+			ans = append(ans, dnsmessage.Resource{Header: srv.header(name), Body: &aaaa})
+		}
+	}
+	return ans
+}
+
+func (srv *Server) header(name dnsmessage.Name) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: srv.TTL}
+}
+
+func (srv *Server) reply(query dnsmessage.Message, rcode dnsmessage.RCode, answers, additionals []dnsmessage.Resource) ([]byte, error) {
+	reply := srv.message(query, rcode, answers, additionals)
+	return reply.Pack()
+}
+
+func (srv *Server) message(query dnsmessage.Message, rcode dnsmessage.RCode, answers, additionals []dnsmessage.Resource) dnsmessage.Message {
+	return dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.ID,
+			Response:           true,
+			OpCode:             query.OpCode,
+			Authoritative:      rcode != dnsmessage.RCodeServerFailure,
+			RecursionDesired:   query.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		},
+		Questions:   query.Questions,
+		Answers:     answers,
+		Additionals: additionals,
+	}
+}
+
+// forward query to upstream resolvers till first answer.
+func (srv *Server) forward(ctx context.Context, network string, query []byte) ([]byte, error) {
+	const timeout = 3 * time.Second
+	upstream := srv.Upstream
+	if len(upstream) == 0 {
+		srv.running.lock.Lock()
+		upstream = srv.running.upstream
+		srv.running.lock.Unlock()
+	}
+	if len(upstream) == 0 {
+		return nil, fmt.Errorf("no upstream resolvers")
+	}
+	var lastErr error
+	for _, resolver := range upstream {
+		tctx, cancel := context.WithTimeout(ctx, timeout)
+		reply, err := exchange(tctx, network, resolver, query)
+		cancel()
+		if err == nil {
+			return reply, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", resolver, err)
+	}
+	return nil, lastErr
+}
+
+func (srv *Server) serveUDP(conn net.PacketConn) {
+	var buffer = make([]byte, 65535)
+	for {
+		n, source, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		query := append([]byte(nil), buffer[:n]...)
+		go func() {
+			reply, err := srv.Answer(context.Background(), "udp", query)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(reply, source)
+		}()
+	}
+}
+
+func (srv *Server) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go srv.handleTCP(conn)
+	}
+}
+
+func (srv *Server) handleTCP(conn net.Conn) {
+	const idle = 10 * time.Second
+	defer conn.Close()
+	for {
+		_ = conn.SetDeadline(time.Now().Add(idle))
+		query, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		reply, err := srv.Answer(context.Background(), "tcp", query)
+		if err != nil {
+			return
+		}
+		if err := writeFrame(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// SystemResolvers (host:port) from resolv.conf file.
+func SystemResolvers(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ans []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			ans = append(ans, net.JoinHostPort(fields[1], Port))
+		}
+	}
+	return ans, scanner.Err()
+}
+
+func exchange(ctx context.Context, network, resolver string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if network == "tcp" {
+		if err := writeFrame(conn, query); err != nil {
+			return nil, err
+		}
+		return readFrame(conn)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	var buffer = make([]byte, 65535)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return buffer[:n], nil
+}
+
+// readFrame of DNS over TCP (two bytes length prefix).
+func readFrame(conn io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func writeFrame(conn io.Writer, data []byte) error {
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(data)))
+	_, err := conn.Write(append(size[:], data...))
+	return err
+}
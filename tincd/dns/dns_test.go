@@ -0,0 +1,167 @@
+package dns_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/discovery"
+	"github.com/reddec/tinc-boot/tincd/dns"
+)
+
+func TestServer_Answer(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "hosts"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tinc.conf"), []byte("Name = alpha\n"), 0755))
+	dc := daemon.Default(dir)
+	require.NoError(t, dc.AddHost("alpha", []byte("Subnet = 10.0.0.1/32\nSubnet = 10.20.0.0/24\n")))
+	require.NoError(t, dc.AddHost("beta", []byte("Subnet = 10.0.0.2/32\n")))
+
+	ssd := discovery.NewSSD("")
+	ssd.Replace(discovery.Entity{Name: "alpha", Version: 1, Catalog: &discovery.Catalog{
+		Services: []discovery.Service{{Name: "web", Port: 8080, Protocol: "tcp"}},
+	}})
+	ssd.Replace(discovery.Entity{Name: "beta", Version: 1})
+
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go fakeUpstream(upstream)
+
+	srv := dns.New("Office.vpn", ssd, dc)
+	srv.Upstream = []string{upstream.LocalAddr().String()}
+	assert.Equal(t, "office.vpn.", srv.Zone())
+
+	ask := func(name string, qtype dnsmessage.Type) dnsmessage.Message {
+		query := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 42, RecursionDesired: true},
+			Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}},
+		}
+		packed, err := query.Pack()
+		require.NoError(t, err)
+		data, err := srv.Answer(context.Background(), "udp", packed)
+		require.NoError(t, err)
+		var reply dnsmessage.Message
+		require.NoError(t, reply.Unpack(data))
+		assert.Equal(t, uint16(42), reply.ID)
+		return reply
+	}
+
+	reply := ask("Alpha.Office.vpn.", dnsmessage.TypeA)
+	require.Equal(t, dnsmessage.RCodeSuccess, reply.RCode)
+	require.Len(t, reply.Answers, 1, "routed subnets are not node addresses")
+	assert.Equal(t, [4]byte{10, 0, 0, 1}, reply.Answers[0].Body.(*dnsmessage.AResource).A)
+
+	reply = ask("beta.office.vpn.", dnsmessage.TypeAAAA)
+	assert.Equal(t, dnsmessage.RCodeSuccess, reply.RCode)
+	assert.Empty(t, reply.Answers)
+
+	reply = ask("gamma.office.vpn.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, reply.RCode)
+
+	reply = ask("_web._tcp.office.vpn.", dnsmessage.TypeSRV)
+	require.Len(t, reply.Answers, 1)
+	srv1 := reply.Answers[0].Body.(*dnsmessage.SRVResource)
+	assert.Equal(t, uint16(8080), srv1.Port)
+	assert.Equal(t, "alpha.office.vpn.", srv1.Target.String())
+	assert.Len(t, reply.Additionals, 1)
+
+	reply = ask("_web._udp.office.vpn.", dnsmessage.TypeSRV)
+	assert.Equal(t, dnsmessage.RCodeNameError, reply.RCode)
+	reply = ask("_web._tcp.beta.office.vpn.", dnsmessage.TypeSRV)
+	assert.Equal(t, dnsmessage.RCodeNameError, reply.RCode)
+
+	reply = ask("example.com.", dnsmessage.TypeA)
+	require.Len(t, reply.Answers, 1, "forwarded to upstream")
+	assert.Equal(t, [4]byte{93, 184, 216, 34}, reply.Answers[0].Body.(*dnsmessage.AResource).A)
+}
+
+func TestServer_Truncate(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "hosts"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tinc.conf"), []byte("Name = alpha\n"), 0755))
+	dc := daemon.Default(dir)
+	var host []byte
+	for i := 1; i <= 60; i++ {
+		host = append(host, fmt.Sprintf("Subnet = 10.0.0.%d/32\n", i)...)
+	}
+	require.NoError(t, dc.AddHost("alpha", host))
+	srv := dns.New("office.vpn", discovery.NewSSD(""), dc)
+
+	ask := func(network string, edns uint16) dnsmessage.Message {
+		query := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 42},
+			Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("alpha.office.vpn."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		}
+		if edns > 0 {
+			query.Additionals = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("."), Type: dnsmessage.TypeOPT, Class: dnsmessage.Class(edns)},
+				Body:   &dnsmessage.OPTResource{},
+			}}
+		}
+		packed, err := query.Pack()
+		require.NoError(t, err)
+		data, err := srv.Answer(context.Background(), network, packed)
+		require.NoError(t, err)
+		if network == "udp" {
+			assert.LessOrEqual(t, len(data), dns.MaxUDPSize+int(edns))
+		}
+		var reply dnsmessage.Message
+		require.NoError(t, reply.Unpack(data))
+		return reply
+	}
+
+	reply := ask("udp", 0)
+	assert.True(t, reply.Truncated, "client should retry over TCP")
+	assert.Empty(t, reply.Answers)
+
+	reply = ask("udp", 4096)
+	assert.False(t, reply.Truncated)
+	assert.Len(t, reply.Answers, 60, "EDNS allows large replies")
+
+	reply = ask("tcp", 0)
+	assert.False(t, reply.Truncated)
+	assert.Len(t, reply.Answers, 60)
+}
+
+func TestSystemResolvers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, ioutil.WriteFile(file, []byte("# generated\nnameserver 127.0.0.53\noptions edns0\nnameserver ::1\n"), 0644))
+	resolvers, err := dns.SystemResolvers(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.53:53", "[::1]:53"}, resolvers)
+}
+
+// fakeUpstream answers any A query by fixed address.
+func fakeUpstream(conn net.PacketConn) {
+	var buffer = make([]byte, 65535)
+	for {
+		n, source, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buffer[:n]); err != nil {
+			continue
+		}
+		msg.Response = true
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}},
+		}}
+		reply, err := msg.Pack()
+		if err != nil {
+			continue
+		}
+		_, _ = conn.WriteTo(reply, source)
+	}
+}
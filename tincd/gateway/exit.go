@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/reddec/tinc-boot/tincd/config"
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/ipam"
+)
+
+// ExitTable is routing table of exit routes. The same number is used as priority of policy rules.
+const ExitTable = 5220
+
+// HostsSource provides actual content of host files (name -> content). Usually it's daemon.Config.
+type HostsSource interface {
+	Hosts() (map[string][]byte, error)
+}
+
+// NewExit routes all traffic of node through named exit node.
+func NewExit(node string, hosts HostsSource) *Exit {
+	return &Exit{
+		Node:    node,
+		Table:   ExitTable,
+		Resolve: net.LookupIP,
+		hosts:   hosts,
+	}
+}
+
+// Exit routes all traffic of node through exit node by policy routing. Traffic to public addresses of tinc peers
+// (Address of host files) stays outside of the tunnel. Implements daemon events listener.
+type Exit struct {
+	Node    string                              // name of exit node
+	IPv6    bool                                // own node has IPv6 address in the mesh: otherwise IPv6 is blocked
+	Table   int                                 // routing table and base priority of rules
+	Resolve func(host string) ([]net.IP, error) // resolver of peers addresses
+	hosts   HostsSource
+	lock    sync.Mutex
+	iface   string
+	applied [][]string // arguments of ip command (add)
+}
+
+// Commands of ip (arguments with add action) to route traffic through exit node on interface.
+func (ex *Exit) Commands(iface string) ([][]string, error) {
+	hosts, err := ex.hosts.Hosts()
+	if err != nil {
+		return nil, fmt.Errorf("read hosts: %w", err)
+	}
+	content, ok := hosts[ex.Node]
+	if !ok {
+		return nil, fmt.Errorf("exit node %s is unknown", ex.Node)
+	}
+	var node config.Node
+	if err := config.Unmarshal(content, &node); err != nil {
+		return nil, fmt.Errorf("parse host of exit node: %w", err)
+	}
+	var ipv4, ipv6 bool
+	for _, route := range node.Routes() {
+		if subnet, err := ipam.ParseSubnet(route); err == nil {
+			if ones, _ := subnet.Mask.Size(); ones == 0 {
+				ipv4 = ipv4 || subnet.IP.To4() != nil
+				ipv6 = ipv6 || subnet.IP.To4() == nil
+			}
+		}
+	}
+	if !ipv4 && !ipv6 {
+		return nil, fmt.Errorf("node %s is not an exit node (no default routes)", ex.Node)
+	}
+	ipv6 = ipv6 && ex.IPv6 // without own IPv6 address in the mesh replies can't come back
+
+	table := strconv.Itoa(ex.Table)
+	bypass := strconv.Itoa(ex.Table - 20)
+	suppress := strconv.Itoa(ex.Table - 10)
+	var ans [][]string
+	// routes first: rules are added when table is ready. Family not routed through exit node is blocked,
+	// otherwise it leaks outside of the tunnel.
+	if ipv4 {
+		ans = append(ans, []string{"-4", "route", "add", "default", "via", node.IP(), "dev", iface, "onlink", "table", table})
+	} else {
+		ans = append(ans, []string{"-4", "route", "add", "unreachable", "default", "table", table})
+	}
+	if ipv6 {
+		ans = append(ans, []string{"-6", "route", "add", "default", "dev", iface, "table", table})
+	} else {
+		ans = append(ans, []string{"-6", "route", "add", "unreachable", "default", "table", table})
+	}
+	for _, ip := range ex.peerAddresses(hosts) {
+		if ip.To4() != nil {
+			ans = append(ans, []string{"-4", "rule", "add", "to", ip.String() + "/32", "lookup", "main", "priority", bypass})
+		} else {
+			ans = append(ans, []string{"-6", "rule", "add", "to", ip.String() + "/128", "lookup", "main", "priority", bypass})
+		}
+	}
+	for _, flag := range []string{"-4", "-6"} {
+		// main table without default route keeps local networks and routes of the mesh
+		ans = append(ans,
+			[]string{flag, "rule", "add", "lookup", "main", "suppress_prefixlength", "0", "priority", suppress},
+			[]string{flag, "rule", "add", "lookup", table, "priority", table})
+	}
+	return ans, nil
+}
+
+// peerAddresses resolves public addresses of all peers (sorted, without duplicates).
+func (ex *Exit) peerAddresses(hosts map[string][]byte) []net.IP {
+	var ans []net.IP
+	var seen = make(map[string]bool)
+	for name, content := range hosts {
+		var node config.Node
+		if err := config.Unmarshal(content, &node); err != nil {
+			continue
+		}
+		for _, address := range node.Address {
+			fields := strings.Fields(address)
+			if len(fields) == 0 {
+				continue
+			}
+			ips, err := ex.Resolve(fields[0])
+			if err != nil {
+				log.Println("failed resolve address", fields[0], "of", name, ":", err)
+				continue
+			}
+			for _, ip := range ips {
+				if !seen[ip.String()] {
+					seen[ip.String()] = true
+					ans = append(ans, ip)
+				}
+			}
+		}
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return bytes.Compare(ans[i].To16(), ans[j].To16()) < 0
+	})
+	return ans
+}
+
+func (ex *Exit) Configured(payload daemon.Configuration) {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	ex.iface = payload.Interface
+	ex.apply()
+	log.Println("traffic routed through exit node", ex.Node)
+}
+
+func (ex *Exit) Stopped(payload daemon.Configuration) {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	for i := len(ex.applied) - 1; i >= 0; i-- {
+		ex.run(ex.applied[i], "del")
+	}
+	ex.applied = nil
+	ex.iface = ""
+}
+
+// Refresh routing after changes of host files (new peers or addresses).
+func (ex *Exit) Refresh() {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	if ex.iface != "" {
+		ex.apply()
+	}
+}
+
+func (ex *Exit) SubnetAdded(payload daemon.EventSubnetAdded) {}
+
+func (ex *Exit) SubnetRemoved(payload daemon.EventSubnetRemoved) {}
+
+func (ex *Exit) Ready(payload daemon.EventReady) {}
+
+// apply commands for current interface: obsolete commands are reverted, new are added.
+func (ex *Exit) apply() {
+	commands, err := ex.Commands(ex.iface)
+	if err != nil {
+		log.Println("failed route traffic through exit node:", err)
+		return
+	}
+	var wanted = make(map[string]bool, len(commands))
+	for _, args := range commands {
+		wanted[strings.Join(args, " ")] = true
+	}
+	var kept = make(map[string]bool, len(ex.applied))
+	var applied [][]string
+	for i := len(ex.applied) - 1; i >= 0; i-- {
+		key := strings.Join(ex.applied[i], " ")
+		if wanted[key] {
+			kept[key] = true
+		} else {
+			ex.run(ex.applied[i], "del")
+		}
+	}
+	for _, args := range commands {
+		key := strings.Join(args, " ")
+		if kept[key] || ex.run(args, "add") {
+			applied = append(applied, args)
+		}
+	}
+	ex.applied = applied
+}
+
+// run ip command with action replaced.
+func (ex *Exit) run(args []string, action string) bool {
+	var cmd = make([]string, len(args))
+	copy(cmd, args)
+	for i, arg := range cmd {
+		if arg == "add" {
+			cmd[i] = action
+			break
+		}
+	}
+	if out, err := exec.Command("ip", cmd...).CombinedOutput(); err != nil {
+		log.Println("failed", "ip", strings.Join(cmd, " "), ":", err, strings.TrimSpace(string(out)))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+// Package gateway configures node as gateway from the mesh to advertised networks: IP forwarding, forwarding
+// rules for VPN interface and optional masquerading by iptables. Everything is reverted when daemon stopped.
+// Linux only.
+package gateway
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/reddec/tinc-boot/tincd/daemon"
+	"github.com/reddec/tinc-boot/tincd/ipam"
+)
+
+// Forwarding switches of kernel by family.
+const (
+	ForwardingIPv4 = "/proc/sys/net/ipv4/ip_forward"
+	ForwardingIPv6 = "/proc/sys/net/ipv6/conf/all/forwarding"
+)
+
+// Rule of iptables (ip6tables for IPv6).
+type Rule struct {
+	Binary string
+	Table  string
+	Chain  string
+	Spec   []string
+}
+
+// Args of iptables command for action: -C (check), -I (insert) or -D (delete).
+func (r Rule) Args(action string) []string {
+	return append([]string{"-t", r.Table, action, r.Chain}, r.Spec...)
+}
+
+func (r Rule) String() string {
+	return r.Binary + " " + strings.Join(r.Args("-A"), " ")
+}
+
+// Gateway to advertised networks. Implements daemon events listener.
+type Gateway struct {
+	Network    string   // overlay network CIDR: source of masqueraded traffic
+	Routes     []string // advertised subnets in tinc notation (optional weight is allowed)
+	Masquerade bool     // masquerade traffic from overlay network to advertised subnets
+	lock       sync.Mutex
+	applied    []Rule
+	restore    map[string][]byte // forwarding switch -> previous value
+}
+
+// Rules for VPN interface: forwarding from interface to advertised subnets (anywhere for default routes), return
+// traffic to interface and masquerading (if enabled). Traffic to default routes (exit node) is always masqueraded.
+func (gw *Gateway) Rules(iface string) []Rule {
+	var forward = make(map[string][]Rule) // binary -> rules
+	var masquerade []Rule
+	for _, route := range gw.Routes {
+		subnet, err := ipam.ParseSubnet(route)
+		if err != nil {
+			continue
+		}
+		ones, _ := subnet.Mask.Size()
+		binary := "iptables"
+		if subnet.IP.To4() == nil {
+			binary = "ip6tables"
+		}
+		spec := []string{"-i", iface, "-d", subnet.String(), "-j", "ACCEPT"}
+		if ones == 0 {
+			spec = []string{"-i", iface, "-j", "ACCEPT"}
+		}
+		forward[binary] = append(forward[binary], Rule{
+			Binary: binary,
+			Table:  "filter",
+			Chain:  "FORWARD",
+			Spec:   spec,
+		})
+		if binary == "ip6tables" {
+			if ones == 0 {
+				// IPv6 addresses of nodes in the mesh are not routable outside
+				masquerade = append(masquerade, Rule{
+					Binary: "ip6tables",
+					Table:  "nat",
+					Chain:  "POSTROUTING",
+					Spec:   []string{"!", "-o", iface, "-j", "MASQUERADE"},
+				})
+			}
+			continue
+		}
+		if gw.Masquerade || ones == 0 {
+			masquerade = append(masquerade, Rule{
+				Binary: "iptables",
+				Table:  "nat",
+				Chain:  "POSTROUTING",
+				Spec:   []string{"-s", gw.Network, "-d", subnet.String(), "!", "-o", iface, "-j", "MASQUERADE"},
+			})
+		}
+	}
+	var ans []Rule
+	for _, binary := range []string{"iptables", "ip6tables"} {
+		if len(forward[binary]) == 0 {
+			continue
+		}
+		ans = append(ans, forward[binary]...)
+		ans = append(ans, Rule{
+			Binary: binary,
+			Table:  "filter",
+			Chain:  "FORWARD",
+			Spec:   []string{"-o", iface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+		})
+	}
+	return append(ans, masquerade...)
+}
+
+// Switches of kernel forwarding required for routes.
+func (gw *Gateway) Switches() []string {
+	var ans []string
+	var seen = make(map[string]bool)
+	for _, route := range gw.Routes {
+		subnet, err := ipam.ParseSubnet(route)
+		if err != nil {
+			continue
+		}
+		file := ForwardingIPv4
+		if subnet.IP.To4() == nil {
+			file = ForwardingIPv6
+		}
+		if !seen[file] {
+			seen[file] = true
+			ans = append(ans, file)
+		}
+	}
+	return ans
+}
+
+func (gw *Gateway) Configured(payload daemon.Configuration) {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+	gw.restore = make(map[string][]byte)
+	for _, file := range gw.Switches() {
+		previous, err := ioutil.ReadFile(file)
+		if err == nil && strings.TrimSpace(string(previous)) != "1" {
+			err = ioutil.WriteFile(file, []byte("1\n"), 0644)
+			if err == nil {
+				gw.restore[file] = previous
+			}
+		}
+		if err != nil {
+			log.Println("failed enable forwarding", file, ":", err)
+		}
+	}
+	for _, rule := range gw.Rules(payload.Interface) {
+		if exec.Command(rule.Binary, rule.Args("-C")...).Run() != nil {
+			if out, err := exec.Command(rule.Binary, rule.Args("-I")...).CombinedOutput(); err != nil {
+				log.Println("failed add rule", rule, ":", err, string(out))
+				continue
+			}
+		}
+		gw.applied = append(gw.applied, rule)
+	}
+	log.Println("gateway for", strings.Join(gw.Routes, ", "), "configured on", payload.Interface)
+}
+
+func (gw *Gateway) Stopped(payload daemon.Configuration) {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+	for _, rule := range gw.applied {
+		if out, err := exec.Command(rule.Binary, rule.Args("-D")...).CombinedOutput(); err != nil {
+			log.Println("failed remove rule", rule, ":", err, string(out))
+		}
+	}
+	gw.applied = nil
+	for file, previous := range gw.restore {
+		if err := ioutil.WriteFile(file, previous, 0644); err != nil {
+			log.Println("failed restore forwarding", file, ":", err)
+		}
+	}
+	gw.restore = nil
+}
+
+func (gw *Gateway) SubnetAdded(payload daemon.EventSubnetAdded) {}
+
+func (gw *Gateway) SubnetRemoved(payload daemon.EventSubnetRemoved) {}
+
+func (gw *Gateway) Ready(payload daemon.EventReady) {}
+
+// ParseRoute in tinc notation (subnet with optional weight: 10.20.0.0/24#10) and returns normalized form.
+func ParseRoute(route string) (string, error) {
+	parts := strings.SplitN(strings.TrimSpace(route), "#", 2)
+	subnet, err := ipam.ParseSubnet(parts[0])
+	if err != nil {
+		return "", err
+	}
+	if len(parts) == 1 {
+		return subnet.String(), nil
+	}
+	if _, err := strconv.ParseUint(parts[1], 10, 31); err != nil {
+		return "", fmt.Errorf("invalid weight of route %s: %w", route, err)
+	}
+	return subnet.String() + "#" + parts[1], nil
+}
@@ -0,0 +1,116 @@
+package gateway_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/gateway"
+)
+
+type staticHosts map[string][]byte
+
+func (sh staticHosts) Hosts() (map[string][]byte, error) {
+	return sh, nil
+}
+
+func TestParseRoute(t *testing.T) {
+	route, err := gateway.ParseRoute("10.20.0.7/24#10")
+	require.NoError(t, err)
+	assert.Equal(t, "10.20.0.0/24#10", route)
+
+	route, err = gateway.ParseRoute("::/0")
+	require.NoError(t, err)
+	assert.Equal(t, "::/0", route)
+
+	_, err = gateway.ParseRoute("10.20.0.0/24#high")
+	assert.Error(t, err)
+	_, err = gateway.ParseRoute("office")
+	assert.Error(t, err)
+}
+
+func TestGateway_Rules(t *testing.T) {
+	gw := &gateway.Gateway{
+		Network: "172.16.0.0/12",
+		Routes:  []string{"10.20.0.0/24#10", "0.0.0.0/0#10", "::/0#10"},
+	}
+	var rules []string
+	for _, rule := range gw.Rules("tunX") {
+		rules = append(rules, rule.String())
+	}
+	assert.Equal(t, []string{
+		"iptables -t filter -A FORWARD -i tunX -d 10.20.0.0/24 -j ACCEPT",
+		"iptables -t filter -A FORWARD -i tunX -j ACCEPT",
+		"iptables -t filter -A FORWARD -o tunX -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		"ip6tables -t filter -A FORWARD -i tunX -j ACCEPT",
+		"ip6tables -t filter -A FORWARD -o tunX -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		"iptables -t nat -A POSTROUTING -s 172.16.0.0/12 -d 0.0.0.0/0 ! -o tunX -j MASQUERADE",
+		"ip6tables -t nat -A POSTROUTING ! -o tunX -j MASQUERADE",
+	}, rules, "only default routes are masqueraded by default")
+	assert.Equal(t, []string{gateway.ForwardingIPv4, gateway.ForwardingIPv6}, gw.Switches())
+
+	gw.Masquerade = true
+	assert.Len(t, gw.Rules("tunX"), 8)
+
+	// site-to-site gateway forwards only to advertised networks
+	gw = &gateway.Gateway{Network: "172.16.0.0/12", Routes: []string{"10.20.0.0/24", "10.30.0.0/16#5"}}
+	rules = nil
+	for _, rule := range gw.Rules("tunX") {
+		rules = append(rules, rule.String())
+	}
+	assert.Equal(t, []string{
+		"iptables -t filter -A FORWARD -i tunX -d 10.20.0.0/24 -j ACCEPT",
+		"iptables -t filter -A FORWARD -i tunX -d 10.30.0.0/16 -j ACCEPT",
+		"iptables -t filter -A FORWARD -o tunX -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+	}, rules)
+}
+
+func TestExit_Commands(t *testing.T) {
+	hosts := staticHosts{
+		"exit":   []byte("Subnet = 172.16.0.1/32\nSubnet = 0.0.0.0/0#10\nAddress = exit.example.com 655\n"),
+		"office": []byte("Subnet = 172.16.0.2/32\nSubnet = 10.20.0.0/24\nAddress = 198.51.100.7\n"),
+		"laptop": []byte("Subnet = 172.16.0.3/32\n"),
+	}
+	exit := gateway.NewExit("exit", hosts)
+	exit.Resolve = func(host string) ([]net.IP, error) {
+		if host == "exit.example.com" {
+			return []net.IP{net.ParseIP("203.0.113.5"), net.ParseIP("2001:db8::5")}, nil
+		}
+		return []net.IP{net.ParseIP(host)}, nil
+	}
+	commands, err := exit.Commands("tunX")
+	require.NoError(t, err)
+	var lines []string
+	for _, args := range commands {
+		lines = append(lines, strings.Join(args, " "))
+	}
+	assert.Equal(t, []string{
+		"-4 route add default via 172.16.0.1 dev tunX onlink table 5220",
+		"-6 route add unreachable default table 5220",
+		"-4 rule add to 198.51.100.7/32 lookup main priority 5200",
+		"-4 rule add to 203.0.113.5/32 lookup main priority 5200",
+		"-6 rule add to 2001:db8::5/128 lookup main priority 5200",
+		"-4 rule add lookup main suppress_prefixlength 0 priority 5210",
+		"-4 rule add lookup 5220 priority 5220",
+		"-6 rule add lookup main suppress_prefixlength 0 priority 5210",
+		"-6 rule add lookup 5220 priority 5220",
+	}, lines, "IPv6 is blocked without IPv6 default route")
+
+	// IPv6 is routed only if own node has IPv6 address in the mesh
+	hosts["exit"] = []byte("Subnet = 172.16.0.1/32\nSubnet = 0.0.0.0/0#10\nSubnet = ::/0#10\n")
+	commands, err = exit.Commands("tunX")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-6", "route", "add", "unreachable", "default", "table", "5220"}, commands[1])
+	exit.IPv6 = true
+	commands, err = exit.Commands("tunX")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-6", "route", "add", "default", "dev", "tunX", "table", "5220"}, commands[1])
+
+	_, err = gateway.NewExit("office", hosts).Commands("tunX")
+	assert.Error(t, err, "not an exit node")
+	_, err = gateway.NewExit("unknown", hosts).Commands("tunX")
+	assert.Error(t, err)
+}
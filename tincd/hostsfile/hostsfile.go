@@ -0,0 +1,164 @@
+// Package hostsfile manages block of overlay node names in hosts file (/etc/hosts). Lines outside of
+// block markers are never touched.
+package hostsfile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/reddec/tinc-boot/tincd/ipam"
+)
+
+// Default location of hosts file.
+const Default = "/etc/hosts"
+
+// HostsSource provides actual content of host files (name -> content). Usually it's daemon.Config.
+type HostsSource interface {
+	Hosts() (map[string][]byte, error)
+}
+
+// Entry of hosts file.
+type Entry struct {
+	IP    net.IP
+	Names []string
+}
+
+// Entries for all nodes with addresses: <name>.<domain> (or just <name> if domain is empty).
+// Sorted by name.
+func Entries(source HostsSource, domain string) ([]Entry, error) {
+	hosts, err := source.Hosts()
+	if err != nil {
+		return nil, fmt.Errorf("read hosts: %w", err)
+	}
+	var names = make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ans []Entry
+	for _, name := range names {
+		fqdn := name
+		if domain != "" {
+			fqdn += "." + strings.Trim(domain, ".")
+		}
+		for _, ip := range ipam.HostAddresses(hosts[name]) {
+			ans = append(ans, Entry{IP: ip, Names: []string{fqdn}})
+		}
+	}
+	return ans, nil
+}
+
+// Block of entries in hosts file between markers. Go-routine safe.
+type Block struct {
+	File   string // hosts file
+	Marker string // block identifier in markers
+	lock   sync.Mutex
+}
+
+func (b *Block) begin() string {
+	return "# BEGIN tinc-boot " + b.Marker
+}
+
+func (b *Block) end() string {
+	return "# END tinc-boot " + b.Marker
+}
+
+// Write entries to block (replaces previous block). File is replaced atomically.
+func (b *Block) Write(entries []Entry) error {
+	var block = []string{b.begin()}
+	for _, entry := range entries {
+		block = append(block, entry.IP.String()+"\t"+strings.Join(entry.Names, " "))
+	}
+	block = append(block, b.end())
+	return b.update(block)
+}
+
+// Remove block from hosts file.
+func (b *Block) Remove() error {
+	return b.update(nil)
+}
+
+// update replaces existent block by new lines (or appends them to the end of file).
+func (b *Block) update(block []string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	content, err := ioutil.ReadFile(b.File)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read hosts file: %w", err)
+	}
+	var (
+		lines   []string
+		inBlock bool
+		placed  bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == b.begin():
+			inBlock = true
+		case inBlock && strings.TrimSpace(line) == b.end():
+			inBlock = false
+			if !placed {
+				lines = append(lines, block...)
+				placed = true
+			}
+		case !inBlock:
+			lines = append(lines, line)
+		}
+	}
+	if inBlock {
+		return fmt.Errorf("hosts file %s has unterminated block %s", b.File, b.Marker)
+	}
+	if !placed {
+		lines = append(lines, block...)
+	}
+	var out bytes.Buffer
+	for _, line := range lines {
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+	if bytes.Equal(out.Bytes(), content) {
+		return nil
+	}
+	return replaceFile(b.File, out.Bytes())
+}
+
+// replaceFile atomically by temp file in the same directory. Bind-mounted files (ex: /etc/hosts in containers)
+// can't be replaced - they are overwritten in place.
+func replaceFile(file string, content []byte) error {
+	var mode os.FileMode = 0644
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		_ = os.Remove(f.Name())
+		if err := ioutil.WriteFile(file, content, mode); err != nil {
+			return fmt.Errorf("write hosts file: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package hostsfile_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/reddec/tinc-boot/tincd/hostsfile"
+)
+
+type hosts map[string][]byte
+
+func (h hosts) Hosts() (map[string][]byte, error) {
+	return h, nil
+}
+
+func TestBlock(t *testing.T) {
+	const original = "127.0.0.1\tlocalhost\n# custom\n10.1.1.1\tgateway\n"
+	file := filepath.Join(t.TempDir(), "hosts")
+	require.NoError(t, ioutil.WriteFile(file, []byte(original), 0644))
+
+	entries, err := hostsfile.Entries(hosts{
+		"beta":  []byte("Subnet = 10.0.0.2/32\n"),
+		"alpha": []byte("Subnet = 10.0.0.1/32\nSubnet = 10.20.0.0/24\n"),
+	}, "office")
+	require.NoError(t, err)
+	block := &hostsfile.Block{File: file, Marker: "office"}
+	require.NoError(t, block.Write(entries))
+
+	content, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, original+"# BEGIN tinc-boot office\n10.0.0.1\talpha.office\n10.0.0.2\tbeta.office\n# END tinc-boot office\n", string(content))
+
+	// user adds line after block, block is rebuilt in place
+	require.NoError(t, ioutil.WriteFile(file, append(content, "10.1.1.2\tprinter\n"...), 0644))
+	require.NoError(t, block.Write(entries[:1]))
+	content, err = ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, original+"# BEGIN tinc-boot office\n10.0.0.1\talpha.office\n# END tinc-boot office\n10.1.1.2\tprinter\n", string(content))
+
+	// blocks of other networks are kept
+	other := &hostsfile.Block{File: file, Marker: "lab"}
+	require.NoError(t, other.Write(entries[1:]))
+	require.NoError(t, block.Remove())
+	content, err = ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, original+"10.1.1.2\tprinter\n# BEGIN tinc-boot lab\n10.0.0.2\tbeta.office\n# END tinc-boot lab\n", string(content))
+
+	require.NoError(t, other.Remove())
+	content, err = ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, original+"10.1.1.2\tprinter\n", string(content))
+}
+
+func TestBlock_Unterminated(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hosts")
+	const broken = "127.0.0.1\tlocalhost\n# BEGIN tinc-boot office\n10.0.0.1\talpha.office\n"
+	require.NoError(t, ioutil.WriteFile(file, []byte(broken), 0644))
+	block := &hostsfile.Block{File: file, Marker: "office"}
+	assert.Error(t, block.Write(nil), "lines after broken marker should not be dropped")
+	content, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, broken, string(content))
+}
@@ -0,0 +1,311 @@
+package ipam
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/reddec/tinc-boot/tincd/config"
+)
+
+// HostsSource provides actual content of host files (name -> content). Usually it's daemon.Config.
+type HostsSource interface {
+	Hosts() (map[string][]byte, error)
+}
+
+// New IP address manager for the network. Addresses are allocated only inside CIDR
+// and never overlap with subnets defined in host files.
+func New(cidr string, hosts HostsSource) (*Pool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("parse network CIDR: %w", err)
+	}
+	if network.IP.To4() == nil {
+		return nil, fmt.Errorf("only IPv4 networks supported")
+	}
+	return &Pool{
+		network: network,
+		hosts:   hosts,
+		pending: make(map[string]net.IP),
+	}, nil
+}
+
+// Pool of addresses in network. Go-routine safe.
+type Pool struct {
+	network *net.IPNet
+	hosts   HostsSource
+	lock    sync.Mutex
+	pending map[string]net.IP // allocated but not yet saved addresses
+}
+
+// Network CIDR.
+func (pool *Pool) Network() *net.IPNet {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return pool.network
+}
+
+// SetNetwork changes managed network CIDR.
+func (pool *Pool) SetNetwork(cidr string) error {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("parse network CIDR: %w", err)
+	}
+	if network.IP.To4() == nil {
+		return fmt.Errorf("only IPv4 networks supported")
+	}
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.network = network
+	return nil
+}
+
+// Contains checks that subnet is completely inside managed network.
+func (pool *Pool) Contains(subnet string) bool {
+	ipNet, err := ParseSubnet(subnet)
+	if err != nil {
+		return false
+	}
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	ones, _ := ipNet.Mask.Size()
+	poolOnes, _ := pool.network.Mask.Size()
+	return ones >= poolOnes && pool.network.Contains(ipNet.IP)
+}
+
+// Covers checks that subnet covers whole managed network (ex: default route of exit node).
+func (pool *Pool) Covers(subnet string) bool {
+	ipNet, err := ParseSubnet(subnet)
+	if err != nil {
+		return false
+	}
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	ones, _ := ipNet.Mask.Size()
+	poolOnes, _ := pool.network.Mask.Size()
+	return ones <= poolOnes && ipNet.Contains(pool.network.IP)
+}
+
+// Allocate free address for the node. Previously allocated but not yet saved address for the same
+// name will be returned again.
+func (pool *Pool) Allocate(name string) (net.IP, error) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	if ip, ok := pool.pending[name]; ok {
+		return ip, nil
+	}
+
+	used, err := pool.usedSubnets(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range pool.pending {
+		used = append(used, &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)})
+	}
+
+	ones, bits := pool.network.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	if size <= 2 {
+		return nil, fmt.Errorf("network %s is too small", pool.network)
+	}
+	base := binary.BigEndian.Uint32(pool.network.IP.To4())
+
+	// start from random offset to reduce collisions between independent allocators
+	var seed [4]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, fmt.Errorf("read random: %w", err)
+	}
+	start := binary.BigEndian.Uint32(seed[:]) % size
+	for i := uint32(0); i < size; i++ {
+		offset := (start + i) % size
+		if offset == 0 || offset == size-1 {
+			continue // network and broadcast addresses
+		}
+		var ip = make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, base+offset)
+		if !overlaps(used, ip) {
+			pool.pending[name] = ip
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("no free addresses in %s", pool.network)
+}
+
+// Release pending allocation for the node. Should be called once host file saved or join failed.
+func (pool *Pool) Release(name string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	delete(pool.pending, name)
+}
+
+// Conflicts returns names of other hosts which subnets overlap with provided subnet.
+func (pool *Pool) Conflicts(name string, subnet string) ([]string, error) {
+	return Conflicts(pool.hosts, name, subnet)
+}
+
+func (pool *Pool) usedSubnets(except string) ([]*net.IPNet, error) {
+	hosts, err := pool.hosts.Hosts()
+	if err != nil {
+		return nil, fmt.Errorf("read hosts: %w", err)
+	}
+	var ans []*net.IPNet
+	for host, content := range hosts {
+		if host == except {
+			continue
+		}
+		for _, subnet := range HostSubnets(content) {
+			ones, _ := subnet.Mask.Size()
+			poolOnes, _ := pool.network.Mask.Size()
+			if ones <= poolOnes && subnet.Contains(pool.network.IP) {
+				continue // route covers whole network (ex: default route of exit node)
+			}
+			ans = append(ans, subnet)
+		}
+	}
+	return ans, nil
+}
+
+// Conflicts returns names of hosts (except named one) which own subnets overlap with provided subnet.
+// Routes advertised by hosts are not checked: several gateways may advertise the same network.
+func Conflicts(hosts HostsSource, name string, subnet string) ([]string, error) {
+	target, err := ParseSubnet(subnet)
+	if err != nil {
+		return nil, err
+	}
+	list, err := hosts.Hosts()
+	if err != nil {
+		return nil, fmt.Errorf("read hosts: %w", err)
+	}
+	var ans []string
+	for host, content := range list {
+		if host == name {
+			continue
+		}
+		if other := HostSubnet(content); other != nil && (other.Contains(target.IP) || target.Contains(other.IP)) {
+			ans = append(ans, host)
+		}
+	}
+	return ans, nil
+}
+
+// HostSubnets parses all IP subnets from host file content. Non-IP subnets (ex: MAC) are ignored.
+func HostSubnets(content []byte) []*net.IPNet {
+	var node struct {
+		Subnet []string
+	}
+	if err := config.Unmarshal(content, &node); err != nil {
+		return nil
+	}
+	var ans []*net.IPNet
+	for _, subnet := range node.Subnet {
+		if ipNet, err := ParseSubnet(subnet); err == nil {
+			ans = append(ans, ipNet)
+		}
+	}
+	return ans
+}
+
+// HostSubnet parses own subnet of node from host file content: the first IP subnet. Other subnets are
+// routes advertised by node. Returns nil if there are no IP subnets.
+func HostSubnet(content []byte) *net.IPNet {
+	if subnets := HostSubnets(content); len(subnets) > 0 {
+		return subnets[0]
+	}
+	return nil
+}
+
+// HostAddresses parses addresses of node from host file content: only host subnets (/32 and /128),
+// routed networks are ignored.
+func HostAddresses(content []byte) []net.IP {
+	var ans []net.IP
+	for _, subnet := range HostSubnets(content) {
+		if ones, bits := subnet.Mask.Size(); ones == bits {
+			ans = append(ans, subnet.IP)
+		}
+	}
+	return ans
+}
+
+// ParseSubnet in tinc notation: address with optional prefix length and optional weight (10.0.0.1/32#10).
+func ParseSubnet(subnet string) (*net.IPNet, error) {
+	subnet = strings.TrimSpace(strings.SplitN(subnet, "#", 2)[0])
+	if !strings.Contains(subnet, "/") {
+		ip := net.ParseIP(subnet)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid subnet %s", subnet)
+		}
+		bits := 8 * net.IPv6len
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %s: %w", subnet, err)
+	}
+	return ipNet, nil
+}
+
+// ReplaceSubnet in host file content: first Subnet line with old value will be replaced to the new value.
+// All other lines (including keys) are kept as-is.
+func ReplaceSubnet(content []byte, oldSubnet, newSubnet string) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	var replaced bool
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !replaced {
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "Subnet") && strings.TrimSpace(kv[1]) == oldSubnet {
+				line = "Subnet = " + newSubnet
+				replaced = true
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// ReplaceRoutes in host file content: Subnet lines after the first one (own address) are replaced by routes.
+// All other lines are kept as-is.
+func ReplaceRoutes(content []byte, routes []string) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	var own bool
+	for scanner.Scan() {
+		line := scanner.Text()
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "Subnet") {
+			if own {
+				continue
+			}
+			own = true
+			out.WriteString(line)
+			out.WriteByte('\n')
+			for _, route := range routes {
+				out.WriteString("Subnet = " + route + "\n")
+			}
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+func overlaps(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package ipam_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/reddec/tinc-boot/tincd/ipam"
+)
+
+type staticHosts map[string][]byte
+
+func (sh staticHosts) Hosts() (map[string][]byte, error) {
+	return sh, nil
+}
+
+func TestPool_Allocate(t *testing.T) {
+	hosts := staticHosts{
+		"alpha": []byte("Subnet = 10.0.0.1/32\nPort = 1655\n"),
+		"beta":  []byte("Subnet = 10.0.0.2\n"),
+		"gamma": []byte("Subnet = 10.0.0.4/30#10\n"),
+	}
+	pool, err := ipam.New("10.0.0.0/29", hosts)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	first, err := pool.Allocate("delta")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.3", first.String())
+
+	again, err := pool.Allocate("delta")
+	assert.NoError(t, err)
+	assert.Equal(t, first, again, "pending allocation should be reused")
+
+	_, err = pool.Allocate("epsilon")
+	assert.Error(t, err, "network should be exhausted")
+
+	pool.Release("delta")
+	second, err := pool.Allocate("epsilon")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.3", second.String())
+}
+
+func TestConflicts(t *testing.T) {
+	hosts := staticHosts{
+		"alpha": []byte("Subnet = 10.0.0.1/32\n"),
+		"beta":  []byte("Subnet = 10.0.1.0/24\nSubnet = 6e:6a:5e:26:39:d2#10\n"),
+	}
+	conflicts, err := ipam.Conflicts(hosts, "alpha", "10.0.0.1/32")
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	conflicts, err = ipam.Conflicts(hosts, "gamma", "10.0.1.15/32")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"beta"}, conflicts)
+}
+
+func TestReplaceSubnet(t *testing.T) {
+	content := "Subnet = 10.0.0.1/32\nAddress = 1.2.3.4\n\n-----BEGIN RSA PUBLIC KEY-----\nXXX\n-----END RSA PUBLIC KEY-----\n"
+	updated := ipam.ReplaceSubnet([]byte(content), "10.0.0.1/32", "10.0.0.7/32")
+	assert.Equal(t, "Subnet = 10.0.0.7/32\nAddress = 1.2.3.4\n\n-----BEGIN RSA PUBLIC KEY-----\nXXX\n-----END RSA PUBLIC KEY-----\n", string(updated))
+}
+
+func TestReplaceRoutes(t *testing.T) {
+	content := "Subnet = 10.0.0.1/32\nSubnet = 10.30.0.0/24\nAddress = 1.2.3.4\n"
+	updated := ipam.ReplaceRoutes([]byte(content), []string{"10.20.0.0/24#5"})
+	assert.Equal(t, "Subnet = 10.0.0.1/32\nSubnet = 10.20.0.0/24#5\nAddress = 1.2.3.4\n", string(updated))
+
+	hosts := staticHosts{"gateway": updated, "alpha": []byte("Subnet = 10.0.0.2/32\n")}
+	conflicts, err := ipam.Conflicts(hosts, "other", "10.20.0.0/24#10")
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts, "routes can be advertised by several gateways")
+
+	assert.Equal(t, "Subnet = 10.0.0.1/32\nAddress = 1.2.3.4\n", string(ipam.ReplaceRoutes(updated, nil)))
+}
@@ -0,0 +1,253 @@
+// Package lan implements zero-config discovery of greeting services in local network by mDNS/DNS-SD:
+// members announce service _tinc-boot._tcp with network name and greeting port (never the token),
+// new nodes browse for it and join by normal boot exchange.
+package lan
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Service type of greeting service in DNS-SD.
+const Service = "_tinc-boot._tcp.local."
+
+// Group is mDNS multicast address.
+const Group = "224.0.0.251:5353"
+
+// Announcement of greeting service found in local network.
+type Announcement struct {
+	Instance string // node name
+	Network  string // network name
+	URL      string // greeting URL, with pinned fingerprint for TLS
+}
+
+// Responder announces greeting service of node.
+type Responder struct {
+	Instance    string   // node name
+	Network     string   // network name
+	Port        uint16   // greeting port
+	TLS         bool     // greeting service uses TLS
+	Fingerprint []byte   // SHA-256 fingerprint of TLS certificate (optional)
+	IPs         []net.IP // addresses of node in LAN (optional, A records)
+}
+
+// Serve mDNS queries till context canceled.
+func (r *Responder) Serve(ctx context.Context) error {
+	group, err := net.ResolveUDPAddr("udp4", Group)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("listen multicast: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	var buffer = make([]byte, 9000)
+	for {
+		n, source, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("read query: %w", err)
+		}
+		reply, err := r.Answer(buffer[:n])
+		if err != nil || reply == nil {
+			continue
+		}
+		target := group
+		if source.Port != group.Port {
+			target = source // legacy unicast query (RFC 6762 section 6.7)
+		}
+		if _, err := conn.WriteToUDP(reply, target); err != nil {
+			log.Println("failed send mDNS answer to", target, ":", err)
+		}
+	}
+}
+
+// Answer to mDNS query. Returns nil if query is not for greeting service.
+func (r *Responder) Answer(query []byte) ([]byte, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	if msg.Response || !asksService(msg.Questions) {
+		return nil, nil
+	}
+	service := dnsmessage.MustNewName(Service)
+	instance, err := dnsmessage.NewName(r.Instance + "." + Service)
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(r.Instance + ".local.")
+	if err != nil {
+		return nil, err
+	}
+	const ttl = 120
+	header := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: ttl}
+	}
+	proto := "http"
+	if r.TLS {
+		proto = "https"
+	}
+	txt := []string{"net=" + r.Network, "proto=" + proto}
+	if len(r.Fingerprint) > 0 {
+		txt = append(txt, "sha256="+hex.EncodeToString(r.Fingerprint))
+	}
+	reply := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: msg.ID, Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{
+			{Header: header(service), Body: &dnsmessage.PTRResource{PTR: instance}},
+			{Header: header(instance), Body: &dnsmessage.SRVResource{Target: host, Port: r.Port}},
+			{Header: header(instance), Body: &dnsmessage.TXTResource{TXT: txt}},
+		},
+	}
+	if msg.ID != 0 {
+		reply.Questions = msg.Questions // legacy unicast reply repeats question
+	}
+	for _, ip := range r.IPs {
+		if ip4 := ip.To4(); ip4 != nil {
+			var a dnsmessage.AResource
+			copy(a.A[:], ip4)
+			reply.Additionals = append(reply.Additionals, dnsmessage.Resource{Header: header(host), Body: &a})
+		}
+	}
+	return reply.Pack()
+}
+
+// Query for greeting services.
+func Query() ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(time.Now().UnixNano())},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(Service),
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	return msg.Pack()
+}
+
+// Parse answer of responder. Greeting URL uses source address of answer.
+func Parse(answer []byte, source net.IP) (*Announcement, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(answer); err != nil {
+		return nil, err
+	}
+	var ann Announcement
+	var port uint16
+	var proto, fingerprint string
+	for _, res := range append(msg.Answers, msg.Additionals...) {
+		switch body := res.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if strings.EqualFold(res.Header.Name.String(), Service) {
+				ann.Instance = strings.TrimSuffix(body.PTR.String(), "."+Service)
+			}
+		case *dnsmessage.SRVResource:
+			port = body.Port
+		case *dnsmessage.TXTResource:
+			for _, item := range body.TXT {
+				kv := strings.SplitN(item, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				switch kv[0] {
+				case "net":
+					ann.Network = kv[1]
+				case "proto":
+					proto = kv[1]
+				case "sha256":
+					fingerprint = kv[1]
+				}
+			}
+		}
+	}
+	if ann.Instance == "" || port == 0 {
+		return nil, fmt.Errorf("not a greeting service announcement")
+	}
+	if proto != "https" {
+		proto = "http"
+	}
+	ann.URL = proto + "://" + net.JoinHostPort(source.String(), strconv.Itoa(int(port)))
+	if proto == "https" && fingerprint != "" {
+		ann.URL += "#sha256=" + fingerprint
+	}
+	return &ann, nil
+}
+
+// Browse greeting services in local network during timeout. If network is not empty, only announcements
+// of the network are returned.
+func Browse(ctx context.Context, network string, timeout time.Duration) ([]Announcement, error) {
+	group, err := net.ResolveUDPAddr("udp4", Group)
+	if err != nil {
+		return nil, err
+	}
+	query, err := Query()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	if _, err := conn.WriteToUDP(query, group); err != nil {
+		return nil, fmt.Errorf("send query: %w", err)
+	}
+	var found []Announcement
+	var seen = make(map[string]bool)
+	var buffer = make([]byte, 9000)
+	for {
+		n, source, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return found, nil
+			}
+			if ctx.Err() != nil {
+				return found, ctx.Err()
+			}
+			return found, fmt.Errorf("read answer: %w", err)
+		}
+		ann, err := Parse(buffer[:n], source.IP)
+		if err != nil || seen[ann.URL] || (network != "" && ann.Network != network) {
+			continue
+		}
+		seen[ann.URL] = true
+		found = append(found, *ann)
+	}
+}
+
+func asksService(questions []dnsmessage.Question) bool {
+	for _, q := range questions {
+		if (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) && strings.EqualFold(q.Name.String(), Service) {
+			return true
+		}
+	}
+	return false
+}
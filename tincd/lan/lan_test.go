@@ -0,0 +1,46 @@
+package lan_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/reddec/tinc-boot/tincd/lan"
+)
+
+func TestResponder_Answer(t *testing.T) {
+	responder := &lan.Responder{
+		Instance:    "alpha",
+		Network:     "office",
+		Port:        8655,
+		TLS:         true,
+		Fingerprint: []byte{0xca, 0xfe},
+		IPs:         []net.IP{net.ParseIP("192.168.1.10")},
+	}
+	query, err := lan.Query()
+	require.NoError(t, err)
+	answer, err := responder.Answer(query)
+	require.NoError(t, err)
+	require.NotNil(t, answer)
+
+	ann, err := lan.Parse(answer, net.ParseIP("192.168.1.10"))
+	require.NoError(t, err)
+	assert.Equal(t, "alpha", ann.Instance)
+	assert.Equal(t, "office", ann.Network)
+	assert.Equal(t, "https://192.168.1.10:8655#sha256=cafe", ann.URL)
+
+	// other services are ignored
+	other := dnsmessage.Message{Questions: []dnsmessage.Question{{
+		Name:  dnsmessage.MustNewName("_http._tcp.local."),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	}}}
+	packed, err := other.Pack()
+	require.NoError(t, err)
+	answer, err = responder.Answer(packed)
+	require.NoError(t, err)
+	assert.Nil(t, answer)
+}